@@ -0,0 +1,8 @@
+-- Where station last_reported comes from, written by the GBFS service.
+-- last_reported is normalized to UTC and keeps sub-second precision. A
+-- station whose source sent no usable timestamp gets the time it was
+-- fetched, with last_reported_synthesized set so that guessed freshness can
+-- be told apart from reported freshness.
+
+ALTER TABLE bikeshare.station
+  ADD COLUMN IF NOT EXISTS last_reported_synthesized BOOLEAN NOT NULL DEFAULT false;
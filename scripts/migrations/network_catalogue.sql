@@ -0,0 +1,20 @@
+-- Network catalogue refresh, written by the GBFS service.
+-- Networks that disappear from citybik.es are marked inactive instead of
+-- deleted so stations keep their foreign keys. Every refresh that changes
+-- the catalogue records its diff as an event.
+
+ALTER TABLE bikeshare.network ADD COLUMN IF NOT EXISTS active BOOLEAN NOT NULL DEFAULT true;
+ALTER TABLE bikeshare.network ADD COLUMN IF NOT EXISTS removed_at TIMESTAMPTZ;
+
+CREATE TABLE IF NOT EXISTS bikeshare.network_catalogue_event (
+  id BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+  created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+  added INT NOT NULL,
+  removed INT NOT NULL,
+  changed INT NOT NULL,
+  restored INT NOT NULL,
+  diff JSONB NOT NULL
+);
+
+COMMENT ON TABLE bikeshare.network_catalogue_event IS
+  'Networks added, removed (marked inactive), changed or restored by each catalogue refresh';
@@ -0,0 +1,16 @@
+-- Ingest health per polled network, written by the GBFS service poller.
+-- The app reads status to show "live data unavailable" for a network.
+
+CREATE TABLE IF NOT EXISTS bikeshare.network_ingest_status (
+  network_id UUID PRIMARY KEY REFERENCES bikeshare.network (id) ON DELETE CASCADE,
+  status TEXT NOT NULL CHECK (status IN ('healthy', 'degraded', 'unavailable')),
+  breaker TEXT NOT NULL CHECK (breaker IN ('closed', 'open', 'half_open')),
+  consecutive_failures INT NOT NULL DEFAULT 0,
+  last_success_at TIMESTAMPTZ,
+  last_error_at TIMESTAMPTZ,
+  last_error TEXT,
+  updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+COMMENT ON TABLE bikeshare.network_ingest_status IS
+  'Polling health per network: healthy, degraded (recent failures) or unavailable (circuit breaker open)';
@@ -0,0 +1,28 @@
+-- Stale station sweep, run by the GBFS service (replaces
+-- refresh-stale-stations.ts and get_stale_networks).
+-- Stations that vanish from citybik.es get removed_at set instead of being
+-- deleted; the next upsert that sees them again clears it.
+
+ALTER TABLE bikeshare.station ADD COLUMN IF NOT EXISTS removed_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS station_network_id_active_index
+  ON bikeshare.station (network_id)
+  WHERE removed_at IS NULL;
+
+-- Oldest fetched_at of the stations still listed upstream, per active
+-- citybik.es network. NULL means the network has no stations yet.
+CREATE OR REPLACE VIEW bikeshare.network_staleness AS
+  SELECT
+    n.id,
+    n.name,
+    n.raw_data->>'id' AS citybikes_id,
+    MIN(s.fetched_at) AS oldest_fetched_at
+  FROM bikeshare.network n
+  LEFT JOIN bikeshare.station s ON s.network_id = n.id AND s.removed_at IS NULL
+  WHERE n.active AND n.raw_data->>'id' IS NOT NULL
+  GROUP BY n.id, n.name, n.raw_data->>'id';
+
+COMMENT ON VIEW bikeshare.network_staleness IS
+  'Oldest station fetch per active network, used to find stale networks';
+
+DROP FUNCTION IF EXISTS bikeshare.get_stale_networks(TIMESTAMPTZ, INT);
@@ -0,0 +1,48 @@
+-- Station availability history, written by the GBFS service.
+-- A snapshot is appended to station_status whenever a station's bikes,
+-- ebikes, docks or flags change; each holds until the station's next one.
+-- The service periodically folds snapshots older than the raw retention
+-- into fixed-width buckets of station_status_aggregate, weighting every
+-- value by how long it lasted in the bucket. The state at the cutoff is
+-- kept as a snapshot so the next buckets start from it, and a bucket
+-- without a change still gets its row.
+
+CREATE TABLE IF NOT EXISTS bikeshare.station_status (
+  station_id UUID NOT NULL REFERENCES bikeshare.station (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  recorded_at TIMESTAMPTZ NOT NULL,
+  num_bikes_available INT NOT NULL,
+  num_ebikes_available INT NOT NULL,
+  num_docks_available INT NOT NULL,
+  is_operational BOOLEAN NOT NULL,
+  is_renting BOOLEAN,
+  is_returning BOOLEAN,
+  PRIMARY KEY (station_id, recorded_at)
+);
+
+CREATE INDEX IF NOT EXISTS station_status_recorded_at_index
+  ON bikeshare.station_status (recorded_at);
+
+COMMENT ON TABLE bikeshare.station_status IS
+  'Station availability whenever it changed, kept raw for the configured retention';
+
+CREATE TABLE IF NOT EXISTS bikeshare.station_status_aggregate (
+  station_id UUID NOT NULL REFERENCES bikeshare.station (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  bucket_start TIMESTAMPTZ NOT NULL,
+  bucket_width INTERVAL NOT NULL,
+  observed_seconds INT NOT NULL,
+  avg_bikes_available REAL NOT NULL,
+  min_bikes_available INT NOT NULL,
+  max_bikes_available INT NOT NULL,
+  avg_ebikes_available REAL NOT NULL,
+  avg_docks_available REAL NOT NULL,
+  min_docks_available INT NOT NULL,
+  max_docks_available INT NOT NULL,
+  operational_ratio REAL NOT NULL,
+  PRIMARY KEY (station_id, bucket_start)
+);
+
+CREATE INDEX IF NOT EXISTS station_status_aggregate_bucket_start_index
+  ON bikeshare.station_status_aggregate (bucket_start);
+
+COMMENT ON TABLE bikeshare.station_status_aggregate IS
+  'Downsampled station availability per station and bucket; averages are weighted by how long each value lasted, over observed_seconds of the bucket';
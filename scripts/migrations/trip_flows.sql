@@ -0,0 +1,53 @@
+-- Inferred trips, written by the GBFS service.
+-- The service watches station bike counts from the websocket diffs, treats
+-- a drop as departures and a rise as arrivals, and pairs them into probable
+-- origin-destination trips. trip_flow is the resulting OD matrix per hour
+-- the trips started; add_trip_flows adds a batch of counts to it, so
+-- flushes from several processes or after a restart accumulate.
+
+CREATE TABLE IF NOT EXISTS bikeshare.trip_flow (
+  network_id UUID NOT NULL REFERENCES bikeshare.network (id) ON DELETE CASCADE,
+  hour_start TIMESTAMPTZ NOT NULL,
+  origin_station_id UUID NOT NULL REFERENCES bikeshare.station (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  destination_station_id UUID NOT NULL REFERENCES bikeshare.station (id) ON DELETE CASCADE ON UPDATE CASCADE,
+  trips INT NOT NULL,
+  total_duration_seconds BIGINT NOT NULL,
+  PRIMARY KEY (network_id, hour_start, origin_station_id, destination_station_id)
+);
+
+CREATE INDEX IF NOT EXISTS trip_flow_hour_start_index
+  ON bikeshare.trip_flow (hour_start);
+
+COMMENT ON TABLE bikeshare.trip_flow IS
+  'Inferred trips per hour and origin-destination station pair; average duration is total_duration_seconds / trips';
+
+-- Adds a JSON array of {network_id, hour_start, origin_station_id,
+-- destination_station_id, trips, total_duration_seconds} to trip_flow and
+-- returns how many rows it touched. A pair may appear only once per call.
+CREATE OR REPLACE FUNCTION bikeshare.add_trip_flows(flows JSONB)
+RETURNS INT
+LANGUAGE sql
+AS $$
+  WITH added AS (
+    INSERT INTO bikeshare.trip_flow AS f (
+      network_id, hour_start, origin_station_id, destination_station_id,
+      trips, total_duration_seconds
+    )
+    SELECT
+      network_id, hour_start, origin_station_id, destination_station_id,
+      trips, total_duration_seconds
+    FROM jsonb_to_recordset(flows) AS x (
+      network_id UUID,
+      hour_start TIMESTAMPTZ,
+      origin_station_id UUID,
+      destination_station_id UUID,
+      trips INT,
+      total_duration_seconds BIGINT
+    )
+    ON CONFLICT (network_id, hour_start, origin_station_id, destination_station_id) DO UPDATE SET
+      trips = f.trips + EXCLUDED.trips,
+      total_duration_seconds = f.total_duration_seconds + EXCLUDED.total_duration_seconds
+    RETURNING 1
+  )
+  SELECT COUNT(*)::INT FROM added;
+$$;
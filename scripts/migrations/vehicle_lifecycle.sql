@@ -0,0 +1,17 @@
+-- Vehicle lifecycle, written by the GBFS service.
+-- Vehicles a network stops reporting (picked up, rented, moved out of the
+-- feed) get gone_at and the time they were last seen instead of lingering
+-- at their old location. The next upsert that sees them again clears
+-- gone_at; the service can delete them once they have been gone for the
+-- configured retention.
+
+ALTER TABLE bikeshare.vehicle ADD COLUMN IF NOT EXISTS gone_at TIMESTAMPTZ;
+ALTER TABLE bikeshare.vehicle ADD COLUMN IF NOT EXISTS last_seen_at TIMESTAMPTZ;
+
+CREATE INDEX IF NOT EXISTS vehicle_network_id_present_index
+  ON bikeshare.vehicle (network_id)
+  WHERE gone_at IS NULL;
+
+CREATE INDEX IF NOT EXISTS vehicle_gone_at_index
+  ON bikeshare.vehicle (gone_at)
+  WHERE gone_at IS NOT NULL;
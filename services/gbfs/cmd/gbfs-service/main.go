@@ -1,57 +1,189 @@
 package main
 
 import (
+	"context"
+	"fmt"
+	"gbfs-service/internal/admin"
 	batchqueue "gbfs-service/internal/batch-queue"
-	citybikespoller "gbfs-service/internal/citybikes-poller"
+	changedetection "gbfs-service/internal/change-detection"
 	citybikeswebsocket "gbfs-service/internal/citybik.es-websocket"
-	"gbfs-service/internal/envkeys"
+	citybikespoller "gbfs-service/internal/citybikes-poller"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	mappingrules "gbfs-service/internal/mapping-rules"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	ratelimit "gbfs-service/internal/rate-limit"
 	supabaseClient "gbfs-service/internal/supabase"
-	"log"
+	"gbfs-service/internal/tracing"
+	tripinference "gbfs-service/internal/trip-inference"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"sync"
 	"syscall"
 	"time"
 )
 
+var logger = logging.For("gbfs-service")
+
 func main() {
-	log.Println("🚀 Starting SpinRoute GBFS Service")
+	// Load and validate all configuration up front so misconfiguration fails fast
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	logging.Setup(cfg.Logging)
+	logger.Info("starting SpinRoute GBFS service")
+
+	// Cancelled on SIGINT/SIGTERM so background consumers can stop cleanly
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Initialize tracing before anything starts emitting spans
+	shutdownTracing, err := tracing.Setup(ctx, cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to initialize tracing", logging.Err(err))
+		os.Exit(1)
+	}
 
 	// Initialize Supabase client
-	if err := supabaseClient.InitSupabase(); err != nil {
-		log.Fatalf("❌ Failed to initialize Supabase client: %v", err)
+	if err := supabaseClient.InitSupabase(cfg.Supabase); err != nil {
+		logger.Error("failed to initialize supabase client", logging.Err(err))
+		os.Exit(1)
 	}
 
+	// Every request to citybik.es (bootstrap, polls) draws from one budget per host
+	ratelimit.Configure(cfg.RateLimit)
+
+	// Both ingest paths only write stations and vehicles that changed
+	changedetection.Configure(cfg.ChangeDetection)
+
+	// Per-network mapping overrides; a rules file that doesn't load is a
+	// misconfiguration like any other
+	if err := mappingrules.Configure(cfg.Mapping); err != nil {
+		logger.Error("failed to load mapping rules", logging.Err(err))
+		os.Exit(2)
+	}
+
+	// Mapped records are checked before they are written
+	if err := mappingvalidation.Configure(cfg.Validation); err != nil {
+		logger.Error("invalid validation settings", logging.Err(err))
+		os.Exit(2)
+	}
+
+	// Station availability history is appended as stations are written
+	supabaseClient.ConfigureStatusHistory(cfg.History)
+
 	// Bootstrap networks from API sources before starting consumers
 	// This ensures all networks exist in the database before we receive updates
-	if err := supabaseClient.BootstrapNetworks(); err != nil {
-		log.Printf("⚠️  Network bootstrap failed: %v (continuing anyway)", err)
+	if err := supabaseClient.BootstrapNetworks(ctx); err != nil {
+		logger.Warn("network bootstrap failed, continuing anyway", logging.Err(err))
+	}
+
+	// Keep the catalogue current so renamed, moved and removed networks are picked up
+	if cfg.Catalogue.RefreshInterval > 0 {
+		go supabaseClient.RefreshNetworksEvery(ctx, cfg.Catalogue.RefreshInterval)
+	}
+
+	// Fold old station availability snapshots into aggregates
+	if cfg.History.Enabled {
+		go supabaseClient.DownsampleStatusHistoryEvery(ctx, cfg.History)
+	}
+
+	// Pair station departures and arrivals from the websocket into trips
+	// Trips inferred since the last flush are written before exiting
+	var tripsFlushed sync.WaitGroup
+	tripinference.Configure(cfg.Trips)
+	if cfg.Trips.Enabled {
+		if cfg.WebSocket.Enabled {
+			tripsFlushed.Add(1)
+			go func() {
+				defer tripsFlushed.Done()
+				tripinference.Run(ctx, cfg.Trips.FlushInterval)
+			}()
+		} else {
+			logger.Info("trip inference disabled, it needs the websocket consumer")
+		}
 	}
 
 	// Create batch queue for efficient database writes (stations only)
-	stationQueue := batchqueue.CreateBatchQueue(100, 10*time.Second)
+	stationQueue := batchqueue.CreateBatchQueue(cfg.Queue.MaxRecords, cfg.Queue.MaxAge)
 
 	// Start WebSocket consumer for real-time station updates
-	go citybikeswebsocket.ConnectToCityBikes(stationQueue)
+	var consumer *citybikeswebsocket.Consumer
+	if cfg.WebSocket.Enabled {
+		consumer = citybikeswebsocket.New(cfg.WebSocket, cfg.Queue.FlushInterval, stationQueue)
+		go consumer.Run(ctx)
+	} else {
+		logger.Info("websocket consumer disabled (set ENABLE_WEBSOCKET=true to enable)")
+	}
 
 	// Start REST API poller for vehicle data (and station verification)
-	if envkeys.Environment.EnablePoller {
-		go citybikespoller.StartPoller()
+	var poller *citybikespoller.Poller
+	if cfg.Poller.Enabled {
+		poller = citybikespoller.New(cfg.Poller)
+		go poller.Start(ctx)
 	} else {
-		log.Println("ℹ️  REST API poller disabled (set ENABLE_POLLER=true to enable)")
+		logger.Info("REST API poller disabled (set ENABLE_POLLER=true to enable)")
+	}
+
+	// Re-fetch networks whose stations fell behind and drop ghost stations
+	if cfg.Stale.RefreshInterval > 0 {
+		if poller != nil {
+			go poller.RefreshStaleEvery(ctx, cfg.Stale)
+		} else {
+			logger.Info("stale station sweep disabled, it fetches through the poller")
+		}
 	}
 
+	// Reload configuration on SIGHUP or config file changes; only the
+	// poller's network selection, the rate budgets, change detection, the
+	// mapping rules and validation apply without a restart
+	go config.Watch(ctx, os.Args[1:], cfg,
+		func(current, next *config.Config) {
+			// Compared with the last reload so an edit is only reported once
+			if sections := current.RestartRequired(next); len(sections) > 0 {
+				logger.Warn("configuration changes require a restart to take effect", "sections", sections)
+			}
+			ratelimit.Configure(next.RateLimit)
+			changedetection.Configure(next.ChangeDetection)
+			if err := mappingrules.Configure(next.Mapping); err != nil {
+				logger.Error("mapping rules reload rejected, keeping current rules", logging.Err(err))
+			}
+			if err := mappingvalidation.Configure(next.Validation); err != nil {
+				logger.Error("validation reload rejected, keeping current settings", logging.Err(err))
+			}
+			if poller != nil {
+				poller.Reload(next.Poller)
+			}
+		},
+		func(err error) {
+			logger.Error("configuration reload rejected, keeping current settings", logging.Err(err))
+		},
+	)
+
 	// Simple health check endpoint
 	http.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"ok"}`))
 	})
 
-	// Start HTTP server for health checks
-	port := os.Getenv("PORT")
-	if port == "" {
-		port = "8080"
+	// Authenticated operator endpoints (disabled unless ADMIN_TOKEN is set),
+	// and the hooks the app reports to without a token
+	components := admin.Components{
+		StationQueue: stationQueue,
+		Poller:       poller,
+		WebSocket:    consumer,
+		Stale:        cfg.Stale,
 	}
+	admin.Register(http.DefaultServeMux, cfg.Admin, components)
+	admin.RegisterApp(http.DefaultServeMux, components)
+
+	// Start HTTP server for health checks
+	port := strconv.Itoa(cfg.HTTP.Port)
 
 	server := &http.Server{
 		Addr:    ":" + port,
@@ -60,18 +192,25 @@ func main() {
 
 	// Run server in goroutine
 	go func() {
-		log.Printf("✅ HTTP server starting on port %s", port)
+		logger.Info("HTTP server starting", "port", port)
 		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatalf("❌ HTTP server error: %v", err)
+			logger.Error("HTTP server error", logging.Err(err))
+			os.Exit(1)
 		}
 	}()
 
 	// Wait for interrupt signal to gracefully shutdown
-	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	<-ctx.Done()
 
-	log.Println("🛑 Shutting down server...")
+	logger.Info("shutting down server")
 	server.Close()
-	log.Println("✅ Server stopped")
+	tripsFlushed.Wait()
+
+	// Give the exporter a bounded window to flush pending spans
+	flushCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := shutdownTracing(flushCtx); err != nil {
+		logger.Warn("failed to flush traces", logging.Err(err))
+	}
+	logger.Info("server stopped")
 }
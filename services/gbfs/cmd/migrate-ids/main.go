@@ -0,0 +1,286 @@
+// Command migrate-ids rewrites network, station and vehicle IDs generated by
+// the legacy uuidfy.UUIDfy to the namespaced UUIDv5 IDs of uuidfy.Network,
+// uuidfy.Station and uuidfy.Vehicle.
+//
+// It reads the current rows through Supabase using the service's
+// configuration (CONFIG_FILE and environment) and prints a single SQL
+// transaction to stdout, so the rewrite either applies completely or not at
+// all. Stop the service, then run:
+//
+//	migrate-ids > migrate_ids.sql
+//	psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -f migrate_ids.sql
+//
+// and deploy the build that writes the new IDs. Rows that already carry
+// their new ID are left alone, so the command can be run again safely.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	supabaseClient "gbfs-service/internal/supabase"
+	"gbfs-service/internal/uuidfy"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var logger = logging.For("migrate-ids")
+
+// pageSize stays under PostgREST's default row limit
+const pageSize = 1000
+
+// reference is a column holding a network ID
+type reference struct {
+	table  string
+	column string
+}
+
+// networkReferences lists every column referencing bikeshare.network, all
+// rewritten before the old networks are dropped. Station and vehicle IDs are
+// instead rewritten through ON UPDATE CASCADE; the transaction aborts on a
+// foreign key that is handled neither way, before it changes anything.
+var networkReferences = []reference{
+	{"station", "network_id"},
+	{"vehicle", "network_id"},
+	{"network_ingest_status", "network_id"},
+	{"trip_flow", "network_id"},
+}
+
+// row is the part of a network, station or vehicle the new ID is derived
+// from. SourceID is the ID citybik.es uses, kept in raw_data.
+type row struct {
+	ID        string `json:"id"`
+	NetworkID string `json:"network_id"`
+	SourceID  string `json:"source_id"`
+}
+
+// mapping is one ID to rewrite
+type mapping struct {
+	kind  string
+	oldID string
+	newID string
+}
+
+// summary counts what happened to the rows of one table
+type summary struct {
+	migrated int
+	current  int // Already carry the new ID
+	unknown  int // Neither the legacy nor the new ID
+}
+
+func main() {
+	// Logs go to stderr (logging.Setup is not called) so stdout is only SQL
+	cfg, err := config.Load(nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
+	if err := supabaseClient.InitSupabase(cfg.Supabase); err != nil {
+		logger.Error("failed to initialize supabase client", logging.Err(err))
+		os.Exit(1)
+	}
+
+	networks, err := fetchRows("network", "id,source_id:raw_data->>id")
+	if err != nil {
+		logger.Error("failed to read networks", logging.Err(err))
+		os.Exit(1)
+	}
+
+	// Children reference their network by its current ID, so keep the
+	// citybik.es ID of every network around
+	var mappings []mapping
+	sourceIDs := make(map[string]string, len(networks))
+	counts := map[string]*summary{"network": {}, "station": {}, "vehicle": {}}
+	for _, network := range networks {
+		if network.SourceID == "" {
+			counts["network"].unknown++
+			continue
+		}
+		sourceIDs[network.ID] = network.SourceID
+		mappings = classify(mappings, counts["network"], "network", network.ID, network.SourceID, uuidfy.Network)
+	}
+
+	for _, kind := range []string{"station", "vehicle"} {
+		rows, err := fetchRows(kind, "id,network_id,source_id:raw_data->>id")
+		if err != nil {
+			logger.Error("failed to read rows", "table", kind, logging.Err(err))
+			os.Exit(1)
+		}
+
+		generate := uuidfy.Station
+		if kind == "vehicle" {
+			generate = uuidfy.Vehicle
+		}
+		for _, r := range rows {
+			networkSourceID, ok := sourceIDs[r.NetworkID]
+			if !ok || r.SourceID == "" {
+				counts[kind].unknown++
+				continue
+			}
+			mappings = classify(mappings, counts[kind], kind, r.ID, r.SourceID, func(id string) (string, error) {
+				return generate(networkSourceID, id)
+			})
+		}
+	}
+
+	for _, kind := range []string{"network", "station", "vehicle"} {
+		logger.Info("planned ID rewrite",
+			"table", kind,
+			"migrated", counts[kind].migrated,
+			"current", counts[kind].current,
+			"unknown", counts[kind].unknown,
+		)
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	writeSQL(out, mappings, time.Now())
+	if err := out.Flush(); err != nil {
+		logger.Error("failed to write SQL", logging.Err(err))
+		os.Exit(1)
+	}
+}
+
+// classify adds a mapping for a row still carrying its legacy ID
+func classify(mappings []mapping, counts *summary, kind, id, sourceID string, generate func(string) (string, error)) []mapping {
+	newID, err := generate(sourceID)
+	if err != nil {
+		counts.unknown++
+		return mappings
+	}
+	if id == newID {
+		counts.current++
+		return mappings
+	}
+
+	// The legacy ID of stations and vehicles ignored the network, so it is
+	// checked against the bare source ID
+	legacyID, _ := uuidfy.UUIDfy(sourceID)
+	if id != legacyID {
+		logger.Warn("row has neither a legacy nor a current ID, leaving it", "table", kind, "id", id, "source_id", sourceID)
+		counts.unknown++
+		return mappings
+	}
+
+	counts.migrated++
+	return append(mappings, mapping{kind: kind, oldID: id, newID: newID})
+}
+
+// fetchRows reads every row of a table in pages
+func fetchRows(table, columns string) ([]row, error) {
+	var rows []row
+	for from := 0; ; from += pageSize {
+		data, _, err := supabaseClient.Config.Client.From(table).
+			Select(columns, "", false).
+			Order("id", nil).
+			Range(from, from+pageSize-1, "").
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s rows: %v", table, err)
+		}
+
+		var page []row
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse %s rows: %v", table, err)
+		}
+		rows = append(rows, page...)
+		if len(page) < pageSize {
+			return rows, nil
+		}
+	}
+}
+
+// writeSQL prints the transaction rewriting every mapped ID. Networks are
+// copied under their new ID first so children can be repointed without
+// breaking their foreign keys, then the old rows are dropped.
+func writeSQL(w io.Writer, mappings []mapping, now time.Time) {
+	fmt.Fprintf(w, "-- Generated by migrate-ids at %s: %d IDs to rewrite\n", now.UTC().Format(time.RFC3339), len(mappings))
+	fmt.Fprintln(w, "BEGIN;")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "CREATE TEMP TABLE id_map (")
+	fmt.Fprintln(w, "  kind TEXT NOT NULL,")
+	fmt.Fprintln(w, "  old_id UUID NOT NULL,")
+	fmt.Fprintln(w, "  new_id UUID NOT NULL,")
+	fmt.Fprintln(w, "  PRIMARY KEY (kind, old_id)")
+	fmt.Fprintln(w, ") ON COMMIT DROP;")
+
+	for i, m := range mappings {
+		if i%1000 == 0 {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "INSERT INTO id_map (kind, old_id, new_id) VALUES")
+		}
+		separator := ","
+		if i%1000 == 999 || i == len(mappings)-1 {
+			separator = ";"
+		}
+		fmt.Fprintf(w, "  ('%s', '%s', '%s')%s\n", m.kind, m.oldID, m.newID, separator)
+	}
+
+	handled := make([]string, 0, len(networkReferences))
+	for _, ref := range networkReferences {
+		handled = append(handled, fmt.Sprintf("('%s', '%s')", ref.table, ref.column))
+	}
+	fmt.Fprintf(w, `
+-- Abort on foreign keys this rewrite would break or silently cascade over
+DO $$
+DECLARE
+  unhandled TEXT;
+BEGIN
+  SELECT string_agg(format('%%I.%%I (%%I)', n.nspname, t.relname, a.attname), ', ')
+    INTO unhandled
+    FROM pg_constraint c
+    JOIN pg_class t ON t.oid = c.conrelid
+    JOIN pg_namespace n ON n.oid = t.relnamespace
+    JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = c.conkey[1]
+    WHERE c.contype = 'f'
+      AND (
+        (c.confrelid = 'bikeshare.network'::regclass
+          AND (n.nspname <> 'bikeshare'
+            OR (t.relname::TEXT, a.attname::TEXT) NOT IN (VALUES %s)))
+        OR (c.confrelid IN ('bikeshare.station'::regclass, 'bikeshare.vehicle'::regclass)
+          AND c.confupdtype <> 'c')
+      );
+  IF unhandled IS NOT NULL THEN
+    RAISE EXCEPTION 'migrate-ids does not rewrite the foreign keys of %%', unhandled;
+  END IF;
+END $$;
+`, strings.Join(handled, ", "))
+
+	fmt.Fprint(w, `
+-- Copy networks under their new ID
+INSERT INTO bikeshare.network
+  SELECT (jsonb_populate_record(n, jsonb_build_object('id', m.new_id))).*
+  FROM bikeshare.network n
+  JOIN id_map m ON m.kind = 'network' AND m.old_id = n.id;
+
+-- Rewrite station and vehicle IDs; references to them follow by cascade
+UPDATE bikeshare.station s SET id = m.new_id
+  FROM id_map m WHERE m.kind = 'station' AND m.old_id = s.id;
+UPDATE bikeshare.vehicle v SET id = m.new_id
+  FROM id_map m WHERE m.kind = 'vehicle' AND m.old_id = v.id;
+
+-- Point everything referencing a network at its new ID
+`)
+	for _, ref := range networkReferences {
+		fmt.Fprintf(w, `DO $$
+BEGIN
+  IF to_regclass('bikeshare.%[1]s') IS NOT NULL THEN
+    UPDATE bikeshare.%[1]s r SET %[2]s = m.new_id
+      FROM id_map m WHERE m.kind = 'network' AND m.old_id = r.%[2]s;
+  END IF;
+END $$;
+`, ref.table, ref.column)
+	}
+
+	fmt.Fprint(w, `
+-- Nothing references the old networks any more
+DELETE FROM bikeshare.network n
+  USING id_map m WHERE m.kind = 'network' AND m.old_id = n.id;
+
+COMMIT;
+`)
+}
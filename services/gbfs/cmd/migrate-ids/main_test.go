@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	tableStatement = regexp.MustCompile(`(?i)^\s*(?:CREATE TABLE(?: IF NOT EXISTS)?|ALTER TABLE(?: IF EXISTS)?(?: ONLY)?)\s+bikeshare\.(\w+)`)
+	foreignKey     = regexp.MustCompile(`(?i)^\s*(?:ADD (?:COLUMN )?(?:IF NOT EXISTS )?)?(\w+)\s.*REFERENCES\s+bikeshare\.(network|station|vehicle)\s*\(id\)(.*)`)
+)
+
+// migrationReference is a foreign key to a network, station or vehicle
+// found in a migration
+type migrationReference struct {
+	file    string
+	from    reference
+	to      string
+	options string
+}
+
+// migrationReferences reads the foreign keys of the repository migrations
+func migrationReferences(t *testing.T) []migrationReference {
+	t.Helper()
+
+	files, err := filepath.Glob("../../../../scripts/migrations/*.sql")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no migrations found: %v", err)
+	}
+
+	var refs []migrationReference
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		table := ""
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if m := tableStatement.FindStringSubmatch(line); m != nil {
+				table = m[1]
+			}
+			if m := foreignKey.FindStringSubmatch(line); m != nil {
+				refs = append(refs, migrationReference{
+					file:    filepath.Base(file),
+					from:    reference{table: table, column: m[1]},
+					to:      m[2],
+					options: strings.ToUpper(m[3]),
+				})
+			}
+		}
+		f.Close()
+		if err := scanner.Err(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return refs
+}
+
+func TestMigrationReferencesAreRewritten(t *testing.T) {
+	var sql strings.Builder
+	writeSQL(&sql, []mapping{{kind: "network", oldID: "old", newID: "new"}}, time.Now())
+	script := sql.String()
+
+	refs := migrationReferences(t)
+	if len(refs) == 0 {
+		t.Fatal("no foreign keys found in the migrations")
+	}
+
+	for _, ref := range refs {
+		switch ref.to {
+		case "network":
+			if !slices.Contains(networkReferences, ref.from) {
+				t.Errorf("%s: %s.%s references a network but isn't in networkReferences", ref.file, ref.from.table, ref.from.column)
+			}
+		default:
+			if !strings.Contains(ref.options, "ON UPDATE CASCADE") {
+				t.Errorf("%s: %s.%s references a %s without ON UPDATE CASCADE", ref.file, ref.from.table, ref.from.column, ref.to)
+			}
+		}
+	}
+
+	// The old networks are only dropped once nothing points at them
+	drop := strings.Index(script, "DELETE FROM bikeshare.network")
+	for _, ref := range networkReferences {
+		update := strings.Index(script, "UPDATE bikeshare."+ref.table+" r SET "+ref.column+" = m.new_id")
+		if update < 0 || update > drop {
+			t.Errorf("%s.%s isn't rewritten before the old networks are dropped", ref.table, ref.column)
+		}
+	}
+
+	// Foreign keys added outside the migrations abort the transaction
+	guard := strings.Index(script, "RAISE EXCEPTION")
+	if guard < 0 || guard > strings.Index(script, "INSERT INTO bikeshare.network") {
+		t.Error("the script doesn't check for unhandled foreign keys before changing anything")
+	}
+}
@@ -0,0 +1,7 @@
+package admin
+
+import "gbfs-service/internal/logging"
+
+const defaultErrorsLimit = 50
+
+var logger = logging.For("admin")
@@ -0,0 +1,45 @@
+package admin
+
+import (
+	batchqueue "gbfs-service/internal/batch-queue"
+	citybikeswebsocket "gbfs-service/internal/citybik.es-websocket"
+	citybikespoller "gbfs-service/internal/citybikes-poller"
+	"gbfs-service/internal/config"
+)
+
+// Components are the running parts of the service the admin API controls.
+// Poller and WebSocket are nil when disabled.
+type Components struct {
+	StationQueue *batchqueue.StationQueue
+	Poller       *citybikespoller.Poller
+	WebSocket    *citybikeswebsocket.Consumer
+
+	// Settings used when a stale station sweep is triggered by hand
+	Stale config.StaleConfig
+}
+
+// handlers serves the admin endpoints
+type handlers struct {
+	Components
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+type networksResponse struct {
+	Networks []string `json:"networks"`
+}
+
+type pollResponse struct {
+	NetworkID string `json:"network_id"`
+	Status    string `json:"status"`
+}
+
+type flushResponse struct {
+	Flushed int `json:"flushed"`
+}
+
+type websocketResponse struct {
+	Paused bool `json:"paused"`
+}
@@ -0,0 +1,229 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	changedetection "gbfs-service/internal/change-detection"
+	citybikespoller "gbfs-service/internal/citybikes-poller"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	mappingerrors "gbfs-service/internal/mapping-errors"
+	mappingrules "gbfs-service/internal/mapping-rules"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	ratelimit "gbfs-service/internal/rate-limit"
+	supabaseClient "gbfs-service/internal/supabase"
+	tripinference "gbfs-service/internal/trip-inference"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Register mounts the admin API on mux. The API is only exposed when a
+// token is configured; every request must carry it as a bearer token.
+func Register(mux *http.ServeMux, cfg config.AdminConfig, components Components) {
+	if cfg.Token == "" {
+		logger.Info("admin API disabled (set ADMIN_TOKEN to enable)")
+		return
+	}
+
+	h := &handlers{Components: components}
+	authenticate := authenticator(cfg.Token)
+
+	mux.Handle("GET /admin/networks", authenticate(h.listNetworks))
+	mux.Handle("POST /admin/networks/{id}/poll", authenticate(h.pollNetwork))
+	mux.Handle("GET /admin/schedule", authenticate(h.schedule))
+	mux.Handle("POST /admin/queue/flush", authenticate(h.flushQueue))
+	mux.Handle("POST /admin/bootstrap", authenticate(h.bootstrap))
+	mux.Handle("POST /admin/stale/refresh", authenticate(h.refreshStale))
+	mux.Handle("GET /admin/websocket", authenticate(h.websocketStatus))
+	mux.Handle("POST /admin/websocket/pause", authenticate(h.pauseWebsocket))
+	mux.Handle("POST /admin/websocket/resume", authenticate(h.resumeWebsocket))
+	mux.Handle("GET /admin/mapping-errors", authenticate(h.mappingErrors))
+	mux.Handle("GET /admin/mapping-rules", authenticate(h.mappingRules))
+	mux.Handle("GET /admin/validation", authenticate(h.validation))
+	mux.Handle("GET /admin/trips", authenticate(h.trips))
+	mux.Handle("GET /admin/rate-limits", authenticate(h.rateLimits))
+	mux.Handle("GET /admin/change-detection", authenticate(h.changeDetection))
+	mux.Handle("GET /admin/station-collisions", authenticate(h.stationCollisions))
+
+	logger.Info("admin API enabled")
+}
+
+// RegisterApp mounts the hooks the app calls without the admin token. They
+// can only nudge the poller within its budget, never spend more of it.
+func RegisterApp(mux *http.ServeMux, components Components) {
+	h := &handlers{Components: components}
+
+	mux.HandleFunc("POST /networks/{id}/viewed", h.networkViewed)
+}
+
+// authenticator returns middleware rejecting requests without the bearer token
+func authenticator(expected string) func(http.HandlerFunc) http.Handler {
+	return func(next http.HandlerFunc) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(expected)) != 1 {
+				writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "unauthorized"})
+				return
+			}
+
+			logger.InfoContext(r.Context(), "admin request", "method", r.Method, "path", r.URL.Path)
+			next(w, r)
+		})
+	}
+}
+
+func (h *handlers) listNetworks(w http.ResponseWriter, r *http.Request) {
+	if h.Poller == nil {
+		writeJSON(w, http.StatusOK, networksResponse{Networks: []string{}})
+		return
+	}
+	writeJSON(w, http.StatusOK, networksResponse{Networks: h.Poller.Networks()})
+}
+
+func (h *handlers) pollNetwork(w http.ResponseWriter, r *http.Request) {
+	networkID := r.PathValue("id")
+
+	if h.Poller == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "poller is disabled"})
+		return
+	}
+
+	err := h.Poller.PollNow(r.Context(), networkID)
+	switch {
+	case errors.Is(err, citybikespoller.ErrUnknownNetwork):
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+	case err != nil:
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+	default:
+		writeJSON(w, http.StatusOK, pollResponse{NetworkID: networkID, Status: "ok"})
+	}
+}
+
+func (h *handlers) networkViewed(w http.ResponseWriter, r *http.Request) {
+	networkID := r.PathValue("id")
+	w.Header().Set("Access-Control-Allow-Origin", "*") // Called from the web app
+
+	if h.Poller == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "poller is disabled"})
+		return
+	}
+
+	if err := h.Poller.MarkViewed(networkID); err != nil {
+		writeJSON(w, http.StatusNotFound, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, pollResponse{NetworkID: networkID, Status: "ok"})
+}
+
+func (h *handlers) schedule(w http.ResponseWriter, r *http.Request) {
+	if h.Poller == nil {
+		writeJSON(w, http.StatusOK, []citybikespoller.NetworkSchedule{})
+		return
+	}
+	writeJSON(w, http.StatusOK, h.Poller.Schedule())
+}
+
+func (h *handlers) flushQueue(w http.ResponseWriter, r *http.Request) {
+	pending := h.StationQueue.Len()
+	if err := h.StationQueue.FlushQueue(r.Context()); err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, flushResponse{Flushed: pending})
+}
+
+func (h *handlers) bootstrap(w http.ResponseWriter, r *http.Request) {
+	diff, err := supabaseClient.RefreshNetworks(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, diff)
+}
+
+func (h *handlers) refreshStale(w http.ResponseWriter, r *http.Request) {
+	if h.Poller == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "poller is disabled"})
+		return
+	}
+
+	sweep, err := h.Poller.RefreshStale(r.Context(), h.Stale)
+	if err != nil {
+		writeJSON(w, http.StatusBadGateway, errorResponse{Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, sweep)
+}
+
+func (h *handlers) websocketStatus(w http.ResponseWriter, r *http.Request) {
+	if h.WebSocket == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "websocket consumer is disabled"})
+		return
+	}
+	writeJSON(w, http.StatusOK, websocketResponse{Paused: h.WebSocket.IsPaused()})
+}
+
+func (h *handlers) pauseWebsocket(w http.ResponseWriter, r *http.Request) {
+	if h.WebSocket == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "websocket consumer is disabled"})
+		return
+	}
+	h.WebSocket.Pause()
+	writeJSON(w, http.StatusOK, websocketResponse{Paused: true})
+}
+
+func (h *handlers) resumeWebsocket(w http.ResponseWriter, r *http.Request) {
+	if h.WebSocket == nil {
+		writeJSON(w, http.StatusConflict, errorResponse{Error: "websocket consumer is disabled"})
+		return
+	}
+	h.WebSocket.Resume()
+	writeJSON(w, http.StatusOK, websocketResponse{Paused: false})
+}
+
+func (h *handlers) mappingErrors(w http.ResponseWriter, r *http.Request) {
+	limit := defaultErrorsLimit
+	if limitStr := r.URL.Query().Get("limit"); limitStr != "" {
+		parsed, err := strconv.Atoi(limitStr)
+		if err != nil || parsed <= 0 {
+			writeJSON(w, http.StatusBadRequest, errorResponse{Error: "limit must be a positive integer"})
+			return
+		}
+		limit = parsed
+	}
+	writeJSON(w, http.StatusOK, mappingerrors.Recent(limit))
+}
+
+func (h *handlers) mappingRules(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, mappingrules.Rules())
+}
+
+func (h *handlers) validation(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, mappingvalidation.AllStats(r.URL.Query().Get("network")))
+}
+
+func (h *handlers) trips(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, tripinference.AllStats(r.URL.Query().Get("network")))
+}
+
+func (h *handlers) rateLimits(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, ratelimit.Budgets())
+}
+
+func (h *handlers) changeDetection(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, changedetection.AllStats())
+}
+
+func (h *handlers) stationCollisions(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, supabaseClient.StationCollisions())
+}
+
+func writeJSON(w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		logger.Warn("failed to write admin response", logging.Err(err))
+	}
+}
@@ -0,0 +1,43 @@
+package admin
+
+import (
+	citybikespoller "gbfs-service/internal/citybikes-poller"
+	"gbfs-service/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNetworkViewedNeedsNoToken(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Poller.Networks = []string{"velib"}
+
+	mux := http.NewServeMux()
+	components := Components{Poller: citybikespoller.New(cfg.Poller)}
+	Register(mux, config.AdminConfig{Token: "secret"}, components)
+	RegisterApp(mux, components)
+
+	tests := []struct {
+		path string
+		want int
+	}{
+		{"/networks/velib/viewed", http.StatusOK},
+		{"/networks/unknown/viewed", http.StatusNotFound},
+		{"/admin/networks/velib/viewed", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, tt.path, nil))
+		if w.Code != tt.want {
+			t.Errorf("POST %s without a token: %d, want %d", tt.path, w.Code, tt.want)
+		}
+	}
+
+	viewed := false
+	for _, network := range components.Poller.Schedule() {
+		viewed = viewed || network.NetworkID == "velib" && network.Viewed
+	}
+	if !viewed {
+		t.Error("the view wasn't passed to the poller")
+	}
+}
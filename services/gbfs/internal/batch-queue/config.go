@@ -1,11 +1,10 @@
 package batchqueue
 
-import "gbfs-service/internal/envkeys"
+import (
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
+)
 
-type batchQueueConfig struct {
-	verbose bool
-}
+var logger = logging.For("batch-queue")
 
-var config = batchQueueConfig{
-	verbose: envkeys.Environment.Verbose,
-}
+var tracer = tracing.Tracer("batch-queue")
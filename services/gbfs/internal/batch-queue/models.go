@@ -1,6 +1,15 @@
 package batchqueue
 
-import "time"
+import (
+	"context"
+	changedetection "gbfs-service/internal/change-detection"
+	stationMapper "gbfs-service/internal/station-mapper"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
 
 // RecordType identifies the type of record in the queue
 type RecordType string
@@ -10,11 +19,36 @@ const (
 	RecordTypeVehicle RecordType = "vehicle"
 )
 
-type BatchQueue struct {
+type BatchQueue[R changedetection.Record] struct {
+	// Guards everything below; the websocket reader, the periodic flush and
+	// the admin API all touch the same queue
+	mu sync.Mutex
+
 	MaxRecords   int
 	RecordsCount int
 	MaxAge       time.Duration
 	Checkpoint   time.Time
-	Records      []map[string]any
+	Records      []R
 	RecordType   RecordType // Type of records in this queue
+
+	// How the records are written and which tracker remembers the ones that
+	// were
+	upsert  func(context.Context, []R) ([]R, error)
+	tracker *changedetection.Tracker[R]
+
+	// Parallel to Records: where each record came from and when it was
+	// queued, so the flush can be linked back to the originating span
+	origins []recordOrigin
+}
+
+// StationQueue and VehicleQueue are the queues of mapped stations and
+// vehicles
+type (
+	StationQueue = BatchQueue[*stationMapper.StationRecord]
+	VehicleQueue = BatchQueue[*vehicleMapper.VehicleRecord]
+)
+
+type recordOrigin struct {
+	spanContext trace.SpanContext
+	enqueuedAt  time.Time
 }
@@ -1,85 +1,156 @@
 package batchqueue
 
 import (
+	"context"
+	changedetection "gbfs-service/internal/change-detection"
+	"gbfs-service/internal/logging"
+	stationMapper "gbfs-service/internal/station-mapper"
 	supabaseClient "gbfs-service/internal/supabase"
-	"log"
+	"gbfs-service/internal/tracing"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-func (b *BatchQueue) Add(record map[string]any) {
+// Add queues a record; the span in ctx (if any) is remembered so the flush
+// can be traced back to the update that produced the record
+func (b *BatchQueue[R]) Add(ctx context.Context, record R) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	b.RecordsCount++
 	b.Records = append(b.Records, record)
+	b.origins = append(b.origins, recordOrigin{
+		spanContext: trace.SpanContextFromContext(ctx),
+		enqueuedAt:  time.Now(),
+	})
 }
 
-func (b *BatchQueue) IsFull() bool {
+func (b *BatchQueue[R]) IsFull() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	return b.RecordsCount >= b.MaxRecords || time.Since(b.Checkpoint) >= b.MaxAge
 }
 
-func (b *BatchQueue) FlushQueue() error {
+func (b *BatchQueue[R]) FlushQueue(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
 	if len(b.Records) == 0 {
 		return nil
 	}
 
-	var err error
-
-	// Use appropriate upsert based on record type
-	switch b.RecordType {
-	case RecordTypeVehicle:
-		err = supabaseClient.BatchUpsertVehicles(b.Records)
-		if err != nil {
-			log.Printf("Failed to batch upsert vehicles: %v", err)
-		}
-	case RecordTypeStation:
-		fallthrough
-	default:
-		err = supabaseClient.BatchUpsertStations(b.Records)
-		if err != nil {
-			log.Printf("Failed to batch upsert stations: %v", err)
+	// The flush span links to every record's originating span so a single
+	// station update can be followed from the websocket into the upsert
+	links := make([]trace.Link, 0, len(b.origins))
+	for _, origin := range b.origins {
+		if origin.spanContext.IsValid() {
+			links = append(links, trace.Link{SpanContext: origin.spanContext})
 		}
 	}
+	ctx, span := tracer.Start(ctx, "batch_queue.flush",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("record_type", string(b.RecordType)),
+			tracing.Count(len(b.Records)),
+		),
+	)
+	defer span.End()
+
+	b.traceResidency(span.SpanContext())
+
+	// Upsert, then remember what was written so identical updates are
+	// suppressed; refused records aren't, so they are tried again
+	written, err := b.upsert(ctx, b.Records)
+	if err != nil {
+		logger.ErrorContext(ctx, "failed to batch upsert records", "record_type", b.RecordType, "records", len(b.Records), logging.Err(err))
+	} else {
+		b.tracker.Commit(written, time.Now())
+	}
 
 	// Reset the bucket after processing (success or failure)
 	totalRecords := len(b.Records)
 	recordType := b.RecordType
-	b.Reset()
+	b.reset()
 
 	if err != nil {
-		return err
+		return tracing.RecordError(span, err)
 	}
 
-	if config.verbose {
-		log.Printf("✅ Successfully processed all %d %s records in bucket", totalRecords, recordType)
-	}
+	logger.DebugContext(ctx, "flushed queue", "records", totalRecords, "record_type", recordType)
 
 	return nil
 }
 
-func (b *BatchQueue) Reset() {
+// traceResidency emits one span per record, parented on the record's origin,
+// covering the time it spent waiting in the queue
+func (b *BatchQueue[R]) traceResidency(flush trace.SpanContext) {
+	now := time.Now()
+	for _, origin := range b.origins {
+		if !origin.spanContext.IsValid() {
+			continue
+		}
+		parent := trace.ContextWithSpanContext(context.Background(), origin.spanContext)
+		_, span := tracer.Start(parent, "batch_queue.residency",
+			trace.WithTimestamp(origin.enqueuedAt),
+			trace.WithLinks(trace.Link{SpanContext: flush}),
+			trace.WithAttributes(attribute.String("record_type", string(b.RecordType))),
+		)
+		span.End(trace.WithTimestamp(now))
+	}
+}
+
+// Len returns the number of queued records
+func (b *BatchQueue[R]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return len(b.Records)
+}
+
+func (b *BatchQueue[R]) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.reset()
+}
+
+func (b *BatchQueue[R]) reset() {
 	b.RecordsCount = 0
 	b.Checkpoint = time.Now()
-	b.Records = make([]map[string]any, 0, b.MaxRecords)
+	b.Records = make([]R, 0, b.MaxRecords)
+	b.origins = make([]recordOrigin, 0, b.MaxRecords)
 }
 
 // CreateBatchQueue creates a new batch queue for stations (default)
-func CreateBatchQueue(maxRecords int, maxAge time.Duration) *BatchQueue {
-	return &BatchQueue{
+func CreateBatchQueue(maxRecords int, maxAge time.Duration) *StationQueue {
+	return &StationQueue{
 		MaxRecords:   maxRecords,
 		RecordsCount: 0,
 		MaxAge:       maxAge,
 		Checkpoint:   time.Now(),
-		Records:      make([]map[string]any, 0, maxRecords),
+		Records:      make([]*stationMapper.StationRecord, 0, maxRecords),
 		RecordType:   RecordTypeStation,
+		upsert:       supabaseClient.BatchUpsertStations,
+		tracker:      changedetection.Stations,
+		origins:      make([]recordOrigin, 0, maxRecords),
 	}
 }
 
 // CreateVehicleBatchQueue creates a new batch queue for vehicles
-func CreateVehicleBatchQueue(maxRecords int, maxAge time.Duration) *BatchQueue {
-	return &BatchQueue{
+func CreateVehicleBatchQueue(maxRecords int, maxAge time.Duration) *VehicleQueue {
+	return &VehicleQueue{
 		MaxRecords:   maxRecords,
 		RecordsCount: 0,
 		MaxAge:       maxAge,
 		Checkpoint:   time.Now(),
-		Records:      make([]map[string]any, 0, maxRecords),
+		Records:      make([]*vehicleMapper.VehicleRecord, 0, maxRecords),
 		RecordType:   RecordTypeVehicle,
+		upsert:       supabaseClient.BatchUpsertVehicles,
+		tracker:      changedetection.Vehicles,
+		origins:      make([]recordOrigin, 0, maxRecords),
 	}
 }
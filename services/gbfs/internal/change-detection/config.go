@@ -0,0 +1,9 @@
+package changedetection
+
+import "gbfs-service/internal/logging"
+
+var logger = logging.For("change-detection")
+
+// evictAfter is how many heartbeats an entry may go unseen before it is
+// dropped from the cache
+const evictAfter = 4
@@ -0,0 +1,39 @@
+package changedetection
+
+import (
+	"sync"
+	"time"
+)
+
+// Record is a mapped station or vehicle
+type Record interface {
+	RecordID() string
+	Compared() any // The fields whose change makes the record worth writing
+	SetFetchedAt(fetchedAt string)
+}
+
+// Tracker remembers the last written state of every station or vehicle,
+// keyed by mapped ID
+type Tracker[R Record] struct {
+	kind string
+
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+	stats     Stats
+}
+
+type entry struct {
+	fingerprint uint64
+	writtenAt   time.Time
+	seenAt      time.Time
+}
+
+// Stats counts what a tracker let through since startup
+type Stats struct {
+	Kind       string `json:"kind"`
+	Tracked    int    `json:"tracked"`
+	Changed    int    `json:"changed"`    // Forwarded because a compared field changed
+	Heartbeats int    `json:"heartbeats"` // Forwarded unchanged to refresh fetched_at
+	Suppressed int    `json:"suppressed"` // Dropped as unchanged
+}
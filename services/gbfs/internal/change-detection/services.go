@@ -0,0 +1,162 @@
+package changedetection
+
+import (
+	"encoding/json"
+	"gbfs-service/internal/config"
+	stationMapper "gbfs-service/internal/station-mapper"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"hash/fnv"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	// Stations and Vehicles are shared by every ingest path so the poller
+	// and the websocket don't rewrite what the other already wrote
+	Stations = newTracker[*stationMapper.StationRecord]("station")
+	Vehicles = newTracker[*vehicleMapper.VehicleRecord]("vehicle")
+
+	settings atomic.Pointer[config.ChangeDetectionConfig]
+)
+
+func init() {
+	defaults := config.Defaults().ChangeDetection
+	settings.Store(&defaults)
+}
+
+// Configure applies the change detection settings to both trackers
+func Configure(cfg config.ChangeDetectionConfig) {
+	settings.Store(&cfg)
+	logger.Info("change detection configured", "enabled", cfg.Enabled, "heartbeat", cfg.Heartbeat)
+}
+
+// Heartbeat is how often unchanged records are rewritten to refresh fetched_at
+func Heartbeat() time.Duration {
+	return settings.Load().Heartbeat
+}
+
+// AllStats returns the counters of both trackers
+func AllStats() []Stats {
+	return []Stats{Stations.Stats(), Vehicles.Stats()}
+}
+
+func newTracker[R Record](kind string) *Tracker[R] {
+	return &Tracker[R]{
+		kind:    kind,
+		entries: make(map[string]*entry),
+	}
+}
+
+// Filter returns the mapped records that changed since they were last
+// written or are due a heartbeat, with fetched_at set to now. Nothing is
+// remembered until Commit, so records whose write fails come back next time.
+func (t *Tracker[R]) Filter(records []R, now time.Time) []R {
+	fetchedAt := now.UTC().Format(time.RFC3339)
+	cfg := settings.Load()
+
+	if !cfg.Enabled {
+		for _, record := range records {
+			record.SetFetchedAt(fetchedAt)
+		}
+		return records
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.sweep(cfg.Heartbeat, now)
+
+	out := make([]R, 0, len(records))
+	for _, record := range records {
+		previous, known := t.entries[record.RecordID()]
+		if known {
+			previous.seenAt = now
+		}
+
+		switch {
+		case !known || previous.fingerprint != t.fingerprint(record):
+			t.stats.Changed++
+		case now.Sub(previous.writtenAt) >= cfg.Heartbeat:
+			t.stats.Heartbeats++
+		default:
+			t.stats.Suppressed++
+			continue
+		}
+
+		record.SetFetchedAt(fetchedAt)
+		out = append(out, record)
+	}
+
+	if suppressed := len(records) - len(out); suppressed > 0 {
+		logger.Debug("suppressed unchanged records", "kind", t.kind, "forwarded", len(out), "suppressed", suppressed)
+	}
+	return out
+}
+
+// Commit records that records were written successfully at now
+func (t *Tracker[R]) Commit(records []R, now time.Time) {
+	if !settings.Load().Enabled {
+		return
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, record := range records {
+		id := record.RecordID()
+		if id == "" {
+			continue
+		}
+		t.entries[id] = &entry{
+			fingerprint: t.fingerprint(record),
+			writtenAt:   now,
+			seenAt:      now,
+		}
+	}
+}
+
+// Forget drops what is known about the given records, so they are written
+// again the next time they are seen
+func (t *Tracker[R]) Forget(ids []string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, id := range ids {
+		delete(t.entries, id)
+	}
+}
+
+// Stats returns the tracker's counters
+func (t *Tracker[R]) Stats() Stats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	stats := t.stats
+	stats.Kind = t.kind
+	stats.Tracked = len(t.entries)
+	return stats
+}
+
+// sweep drops entries that haven't been seen for several heartbeats, such as
+// stations a network removed; callers hold mu
+func (t *Tracker[R]) sweep(heartbeat time.Duration, now time.Time) {
+	if now.Sub(t.lastSweep) < heartbeat {
+		return
+	}
+	t.lastSweep = now
+
+	for id, e := range t.entries {
+		if now.Sub(e.seenAt) >= evictAfter*heartbeat {
+			delete(t.entries, id)
+		}
+	}
+}
+
+// fingerprint hashes the compared fields of a record. They are JSON
+// encoded so pointers compare by what they point to.
+func (t *Tracker[R]) fingerprint(record R) uint64 {
+	h := fnv.New64a()
+	encoded, _ := json.Marshal(record.Compared())
+	h.Write(encoded)
+	return h.Sum64()
+}
@@ -0,0 +1,133 @@
+package changedetection
+
+import (
+	"gbfs-service/internal/config"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"testing"
+	"time"
+)
+
+// step is a station seen at minutes past the start, then written unless
+// the write fails
+type step struct {
+	minutes   int
+	bikes     int
+	reported  string // Not compared
+	failed    bool
+	forget    bool // Forget the station before it is seen
+	forwarded bool
+}
+
+func TestFilter(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		disabled bool
+		steps    []step
+		stats    Stats
+	}{
+		{
+			name: "unchanged records are suppressed",
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: 1, bikes: 5},
+			},
+			stats: Stats{Changed: 1, Suppressed: 1, Tracked: 1},
+		},
+		{
+			name: "fields that aren't compared don't count as a change",
+			steps: []step{
+				{minutes: 0, bikes: 5, reported: "08:00", forwarded: true},
+				{minutes: 1, bikes: 5, reported: "08:01"},
+			},
+			stats: Stats{Changed: 1, Suppressed: 1, Tracked: 1},
+		},
+		{
+			name: "a compared field changing is forwarded",
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: 1, bikes: 6, forwarded: true},
+				{minutes: 2, bikes: 6},
+			},
+			stats: Stats{Changed: 2, Suppressed: 1, Tracked: 1},
+		},
+		{
+			name: "unchanged records are rewritten once per heartbeat",
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: 14, bikes: 5},
+				{minutes: 15, bikes: 5, forwarded: true},
+				{minutes: 16, bikes: 5},
+			},
+			stats: Stats{Changed: 1, Heartbeats: 1, Suppressed: 2, Tracked: 1},
+		},
+		{
+			name: "records whose write failed come back",
+			steps: []step{
+				{minutes: 0, bikes: 5, failed: true, forwarded: true},
+				{minutes: 1, bikes: 5, forwarded: true},
+				{minutes: 2, bikes: 5},
+			},
+			stats: Stats{Changed: 2, Suppressed: 1, Tracked: 1},
+		},
+		{
+			name: "forgotten records are written again",
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: 1, bikes: 5, forget: true, forwarded: true},
+			},
+			stats: Stats{Changed: 2, Tracked: 1},
+		},
+		{
+			name: "records unseen for several heartbeats are swept",
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: evictAfter * 15, bikes: 5, forwarded: true},
+			},
+			stats: Stats{Changed: 2, Tracked: 1},
+		},
+		{
+			name:     "disabled detection forwards everything",
+			disabled: true,
+			steps: []step{
+				{minutes: 0, bikes: 5, forwarded: true},
+				{minutes: 1, bikes: 5, forwarded: true},
+			},
+		},
+	}
+
+	previous := settings.Load()
+	t.Cleanup(func() { settings.Store(previous) })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			Configure(config.ChangeDetectionConfig{Enabled: !tt.disabled, Heartbeat: 15 * time.Minute})
+			tracker := newTracker[*stationMapper.StationRecord]("station")
+
+			for _, s := range tt.steps {
+				now := start.Add(time.Duration(s.minutes) * time.Minute)
+				if s.forget {
+					tracker.Forget([]string{"a"})
+				}
+
+				record := &stationMapper.StationRecord{ID: "a", NumBikesAvailable: s.bikes, LastReported: s.reported}
+				out := tracker.Filter([]*stationMapper.StationRecord{record}, now)
+				if forwarded := len(out) == 1; forwarded != s.forwarded {
+					t.Fatalf("minute %d: forwarded %v, want %v", s.minutes, forwarded, s.forwarded)
+				}
+				if s.forwarded && (record.FetchedAt == nil || *record.FetchedAt != now.Format(time.RFC3339)) {
+					t.Errorf("minute %d: fetched_at %v, want %s", s.minutes, record.FetchedAt, now.Format(time.RFC3339))
+				}
+				if !s.failed {
+					tracker.Commit(out, now)
+				}
+			}
+
+			tt.stats.Kind = "station"
+			if stats := tracker.Stats(); stats != tt.stats {
+				t.Errorf("stats %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
@@ -1,20 +1,14 @@
 package citybikeswebsocket
 
 import (
-	"gbfs-service/internal/envkeys"
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
 	"time"
 )
 
-type citybikeswebsocketConfig struct {
-	verbose               bool
-	maxReconnectAttempts  int
-	baseReconnectDelay    time.Duration
-	websocketPingInterval time.Duration
-}
+var logger = logging.For("websocket")
 
-var Config = citybikeswebsocketConfig{
-	verbose:               envkeys.Environment.Verbose,
-	maxReconnectAttempts:  10,
-	baseReconnectDelay:    5 * time.Second,
-	websocketPingInterval: 25 * time.Second,
-}
+var tracer = tracing.Tracer("websocket")
+
+// closeWriteTimeout bounds sending the close frame on shutdown
+const closeWriteTimeout = time.Second
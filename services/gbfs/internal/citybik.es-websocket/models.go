@@ -0,0 +1,24 @@
+package citybikeswebsocket
+
+import (
+	batchqueue "gbfs-service/internal/batch-queue"
+	"gbfs-service/internal/config"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// Consumer streams station diffs from the citybik.es websocket into the
+// station queue. It can be paused: pausing drops the current connection and
+// holds the reconnect loop until resumed.
+type Consumer struct {
+	cfg           config.WebSocketConfig
+	flushInterval time.Duration
+	stationQueue  *batchqueue.StationQueue
+
+	mu      sync.Mutex
+	paused  bool
+	resumed chan struct{} // Closed when a pause ends
+	conn    *websocket.Conn
+}
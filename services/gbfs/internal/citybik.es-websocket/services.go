@@ -1,20 +1,41 @@
 package citybikeswebsocket
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	batchqueue "gbfs-service/internal/batch-queue"
+	changedetection "gbfs-service/internal/change-detection"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	mappingerrors "gbfs-service/internal/mapping-errors"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
 	stationMapper "gbfs-service/internal/station-mapper"
+	"gbfs-service/internal/tracing"
+	tripinference "gbfs-service/internal/trip-inference"
 	"gbfs-service/internal/uuidfy"
-	"log"
 	"strings"
 	"time"
+	"unicode/utf8"
 
 	"github.com/gorilla/websocket"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
+// New creates a consumer; flushInterval is how often the station queue is
+// checked for records that have waited longer than its max age
+func New(cfg config.WebSocketConfig, flushInterval time.Duration, stationQueue *batchqueue.StationQueue) *Consumer {
+	return &Consumer{
+		cfg:           cfg,
+		flushInterval: flushInterval,
+		stationQueue:  stationQueue,
+	}
+}
+
 // processWebSocketMessage extracts message processing logic into a separate function
-func processWebSocketMessage(msg string, stationQueue *batchqueue.BatchQueue) error {
+func processWebSocketMessage(ctx context.Context, msg string, stationQueue *batchqueue.StationQueue) error {
 	// Socket.IO packet types:
 	// 0 = open, 1 = close, 2 = ping, 3 = pong, 4 = message
 	switch {
@@ -24,89 +45,111 @@ func processWebSocketMessage(msg string, stationQueue *batchqueue.BatchQueue) er
 
 	case strings.HasPrefix(msg, "42"):
 		// This is a Socket.IO event message
-		// Remove the "42" prefix to get the JSON array
-		jsonStr := msg[2:]
-
-		// Parse the event array
-		var eventArray []json.RawMessage
-		if err := json.Unmarshal([]byte(jsonStr), &eventArray); err != nil {
-			log.Printf("⚠️ Failed to parse event: %v", err)
+		eventName, payload, err := parseEvent(strings.TrimPrefix(msg, "42"))
+		if err != nil {
+			logger.Warn("failed to parse event", logging.Err(err))
 			return err
 		}
 
-		// Get event name
-		var eventName string
-		if len(eventArray) > 0 {
-			if err := json.Unmarshal(eventArray[0], &eventName); err == nil {
-				if Config.verbose {
-					log.Printf("📬 Event type: %s", eventName)
-				}
-				if eventName == "diff" && len(eventArray) > 1 {
-					// Process the diff event
-					return processDiffEvent(eventArray[1], stationQueue)
-				}
-			}
+		if eventName != "" {
+			logger.Debug("received event", "event", eventName)
+		}
+		if eventName == "diff" && payload != nil {
+			// Process the diff event
+			return processDiffEvent(ctx, payload, stationQueue)
 		}
 
 	default:
-		if Config.verbose {
-			log.Printf("🔍 Unknown message type: %s", msg[:min(len(msg), 50)])
-		}
+		logger.Debug("unknown message type", "message", truncate(msg, 50))
 	}
 
 	return nil
 }
 
-// Extract diff processing logic - WebSocket only sends station updates
-func processDiffEvent(diffRaw json.RawMessage, stationQueue *batchqueue.BatchQueue) error {
-	// Parse the diff data
-	var diffData map[string]any
-	if err := json.Unmarshal(diffRaw, &diffData); err != nil {
-		return fmt.Errorf("failed to parse diff data: %v", err)
+// parseEvent reads the JSON array of a Socket.IO event: its name and first
+// argument. The name is empty if it isn't a string, the payload nil if the
+// event has no arguments.
+func parseEvent(data string) (name string, payload json.RawMessage, err error) {
+	var eventArray []json.RawMessage
+	if err := json.Unmarshal([]byte(data), &eventArray); err != nil {
+		return "", nil, err
 	}
 
-	// Extract specific fields
-	message, ok := diffData["message"].(map[string]any)
-	if !ok {
-		return nil
+	if len(eventArray) == 0 || json.Unmarshal(eventArray[0], &name) != nil {
+		return "", nil, nil
 	}
+	if len(eventArray) > 1 {
+		payload = eventArray[1]
+	}
+	return name, payload, nil
+}
+
+// Extract diff processing logic - WebSocket only sends station updates
+func processDiffEvent(ctx context.Context, diffRaw json.RawMessage, stationQueue *batchqueue.StationQueue) error {
+	// Every diff starts its own trace; the queue flush links back to it
+	ctx, span := tracer.Start(ctx, "websocket.diff", trace.WithNewRoot())
+	defer span.End()
 
-	action, _ := message["action"].(string)
-	n, _ := message["n"].(float64)
-	network, _ := message["network"].(string)
+	// Parse the diff data
+	var diff citybikes.Diff
+	if err := json.Unmarshal(diffRaw, &diff); err != nil {
+		return tracing.RecordError(span, fmt.Errorf("failed to parse diff data: %v", err))
+	}
 
 	// Process station update
-	station, ok := message["station"].(map[string]any)
-	if !ok {
+	message := diff.Message
+	if message == nil || message.Station == nil {
 		return nil
 	}
 
-	return processStationUpdate(station, network, action, int(n), stationQueue)
+	span.SetAttributes(
+		tracing.NetworkID(message.Network),
+		tracing.StationID(message.Station.ID),
+		attribute.String("action", message.Action),
+	)
+
+	return tracing.RecordError(span, processStationUpdate(ctx, *message.Station, message.Network, message.Action, int(message.N), stationQueue))
 }
 
 // processStationUpdate handles station diff events
-func processStationUpdate(station map[string]any, network, action string, n int, bucket *batchqueue.BatchQueue) error {
-	name, _ := station["name"].(string)
-	networkId, _ := uuidfy.UUIDfy(network)
+func processStationUpdate(ctx context.Context, station citybikes.Station, network, action string, n int, bucket *batchqueue.StationQueue) error {
+	networkId, _ := uuidfy.Network(network)
 
-	if Config.verbose {
-		log.Printf("🚲 Station update - network: %q (%s), station: %q, action: %s, bikes: %d",
-			network, networkId, name, action, n)
-	}
+	logger.DebugContext(ctx, "station update",
+		logging.NetworkID(network),
+		"network_uuid", networkId,
+		"station_name", station.Name,
+		"action", action,
+		"bikes", n,
+	)
 
 	// Map the station data to Supabase format
+	_, mapSpan := tracer.Start(ctx, "station.map")
 	mappedStation, err := stationMapper.MapStationData(station, network)
+	if err == nil {
+		err = mappingvalidation.Station(station, mappedStation, network)
+	}
 	if err != nil {
-		return fmt.Errorf("failed to map station data: %v", err)
+		mappingerrors.Record(mappingerrors.SourceWebSocket, "station", network, station.ID, err)
+		err = tracing.RecordError(mapSpan, fmt.Errorf("failed to map station data: %v", err))
+		mapSpan.End()
+		return err
 	}
+	mapSpan.SetAttributes(attribute.String("station_uuid", mappedStation.ID))
+	mapSpan.End()
 
-	// Add the mapped station to the bucket
-	bucket.Add(mappedStation)
+	// Every change in bikes counts for trip inference, written or not
+	tripinference.Observe(mappedStation, network, time.Now())
+
+	// Add the mapped station to the bucket unless it matches what was last written
+	if changed := changedetection.Stations.Filter([]*stationMapper.StationRecord{mappedStation}, time.Now()); len(changed) > 0 {
+		bucket.Add(ctx, mappedStation)
+	}
 
 	// Check if the bucket is full or needs to be emptied
 	if bucket.IsFull() {
-		if err := bucket.FlushQueue(); err != nil {
-			log.Printf("Failed to flush station bucket: %v", err)
+		if err := bucket.FlushQueue(ctx); err != nil {
+			logger.ErrorContext(ctx, "failed to flush station bucket", logging.Err(err))
 		}
 	}
 
@@ -114,23 +157,38 @@ func processStationUpdate(station map[string]any, network, action string, n int,
 }
 
 // handleConnection handles an active WebSocket connection
-func handleConnection(conn *websocket.Conn, stationQueue *batchqueue.BatchQueue) bool {
+func (c *Consumer) handleConnection(ctx context.Context, conn *websocket.Conn) bool {
+	stationQueue := c.stationQueue
+
 	defer conn.Close()
 
 	// Channel to signal when to stop the ping goroutine
 	stopPing := make(chan struct{})
 	defer close(stopPing)
 
+	// Close the connection on shutdown so the blocking read returns. Unlike
+	// WriteMessage, WriteControl may run alongside the ping goroutine's writes.
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.WriteControl(websocket.CloseMessage,
+				websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""),
+				time.Now().Add(closeWriteTimeout))
+			conn.Close()
+		case <-stopPing:
+		}
+	}()
+
 	// Handle ping/pong to keep connection alive
 	go func() {
-		ticker := time.NewTicker(Config.websocketPingInterval)
+		ticker := time.NewTicker(c.cfg.PingInterval)
 		defer ticker.Stop()
 
 		for {
 			select {
 			case <-ticker.C:
 				if err := conn.WriteMessage(websocket.TextMessage, []byte("2")); err != nil {
-					log.Printf("⚠️ Ping failed: %v", err)
+					logger.Warn("ping failed", logging.Err(err))
 					return
 				}
 			case <-stopPing:
@@ -141,7 +199,7 @@ func handleConnection(conn *websocket.Conn, stationQueue *batchqueue.BatchQueue)
 
 	// Periodic flush for queue that hasn't reached capacity
 	go func() {
-		ticker := time.NewTicker(10 * time.Second)
+		ticker := time.NewTicker(c.flushInterval)
 		defer ticker.Stop()
 
 		for {
@@ -149,8 +207,8 @@ func handleConnection(conn *websocket.Conn, stationQueue *batchqueue.BatchQueue)
 			case <-ticker.C:
 				// Flush station queue if it has records and is past max age
 				if stationQueue.IsFull() {
-					if err := stationQueue.FlushQueue(); err != nil {
-						log.Printf("⚠️ Periodic station flush failed: %v", err)
+					if err := stationQueue.FlushQueue(ctx); err != nil {
+						logger.Warn("periodic station flush failed", logging.Err(err))
 					}
 				}
 			case <-stopPing:
@@ -163,7 +221,13 @@ func handleConnection(conn *websocket.Conn, stationQueue *batchqueue.BatchQueue)
 	for {
 		_, message, err := conn.ReadMessage()
 		if err != nil {
-			log.Printf("❌ Read error: %v", err)
+			if ctx.Err() != nil {
+				return true // Shutting down
+			}
+			if c.IsPaused() {
+				return false // Dropped by Pause; the reconnect loop waits for Resume
+			}
+			logger.Error("read error", logging.Err(err))
 
 			// Check if it's a normal closure (user requested shutdown)
 			if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
@@ -177,65 +241,159 @@ func handleConnection(conn *websocket.Conn, stationQueue *batchqueue.BatchQueue)
 		msg := string(message)
 
 		// Process the message
-		if err := processWebSocketMessage(msg, stationQueue); err != nil {
-			log.Printf("⚠️ Error processing message: %v", err)
+		if err := processWebSocketMessage(ctx, msg, stationQueue); err != nil {
+			logger.Warn("error processing message", logging.Err(err))
 			// Continue processing other messages
 		}
 	}
 }
 
-// ConnectToCityBikes establishes WebSocket connection with retry logic
-func ConnectToCityBikes(stationQueue *batchqueue.BatchQueue) {
+// Pause disconnects from CityBikes and stops reconnecting until Resume is called
+func (c *Consumer) Pause() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused {
+		return
+	}
+	c.paused = true
+	c.resumed = make(chan struct{})
+
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	logger.Info("websocket consumer paused")
+}
+
+// Resume lets a paused consumer reconnect
+func (c *Consumer) Resume() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.paused {
+		return
+	}
+	c.paused = false
+	close(c.resumed)
+	logger.Info("websocket consumer resumed")
+}
+
+// IsPaused reports whether the consumer is paused
+func (c *Consumer) IsPaused() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.paused
+}
+
+// waitWhilePaused blocks until the consumer is resumed; returns false on shutdown
+func (c *Consumer) waitWhilePaused(ctx context.Context) bool {
+	c.mu.Lock()
+	paused, resumed := c.paused, c.resumed
+	c.mu.Unlock()
+
+	if !paused {
+		return true
+	}
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-resumed:
+		return true
+	}
+}
+
+// setConn registers the active connection (nil clears it); it refuses and
+// closes a new connection if a pause happened while dialing
+func (c *Consumer) setConn(conn *websocket.Conn) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.paused && conn != nil {
+		conn.Close()
+		c.conn = nil
+		return false
+	}
+	c.conn = conn
+	return true
+}
+
+// Run establishes the WebSocket connection with retry logic and consumes it
+// until ctx is done or reconnection attempts are exhausted
+func (c *Consumer) Run(ctx context.Context) {
 	attempts := 0
 
 	for {
+		if !c.waitWhilePaused(ctx) {
+			return
+		}
+
 		attempts++
-		log.Printf("🔄 Attempting to connect to CityBikes (attempt %d/%d)...", attempts, Config.maxReconnectAttempts)
+		logger.Info("connecting to CityBikes", "attempt", attempts, "max_attempts", c.cfg.MaxReconnectAttempts)
 
-		// Calculate exponential backoff delay (but cap it at 2 minutes)
-		delay := time.Duration(attempts-1) * time.Duration(Config.baseReconnectDelay)
-		if delay > 2*time.Minute {
-			delay = 2 * time.Minute
+		// Calculate linear backoff delay (capped)
+		delay := time.Duration(attempts-1) * c.cfg.BaseReconnectDelay
+		if delay > c.cfg.MaxReconnectDelay {
+			delay = c.cfg.MaxReconnectDelay
 		}
 
 		if attempts > 1 {
-			log.Printf("⏳ Waiting %v before reconnection attempt...", delay)
-			time.Sleep(delay)
+			logger.Info("waiting before reconnection attempt", "delay", delay)
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
 		}
 
 		// Try to establish connection
-		conn, _, err := websocket.DefaultDialer.Dial("wss://ws.citybik.es/socket.io/?EIO=3&transport=websocket", nil)
+		conn, _, err := websocket.DefaultDialer.DialContext(ctx, c.cfg.URL, nil)
 		if err != nil {
-			log.Printf("❌ CityBikes connection failed (attempt %d): %v", attempts, err)
+			logger.Error("CityBikes connection failed", "attempt", attempts, logging.Err(err))
 
-			if attempts >= Config.maxReconnectAttempts {
-				log.Printf("💀 Maximum reconnection attempts reached. Giving up.")
+			if attempts >= c.cfg.MaxReconnectAttempts {
+				logger.Error("maximum reconnection attempts reached, giving up")
 				return
 			}
 			continue
 		}
 
-		log.Println("✅ Connected to CityBikes! Listening for station updates...")
+		if !c.setConn(conn) {
+			attempts = 0
+			continue
+		}
+
+		logger.Info("connected to CityBikes, listening for station updates")
 
 		// Reset attempt counter on successful connection
 		attempts = 0
 
 		// Handle the connection - this will block until connection fails
-		if handleConnection(conn, stationQueue) {
+		shutdown := c.handleConnection(ctx, conn)
+		c.setConn(nil)
+		if shutdown {
 			// If handleConnection returns true, it means we should stop trying to reconnect
-			log.Println("🛑 WebSocket handler requested shutdown")
+			logger.Info("websocket handler requested shutdown")
 			return
 		}
 
+		if c.IsPaused() {
+			continue
+		}
+
 		// Connection failed, loop will retry
-		log.Println("🔄 Connection lost, attempting to reconnect...")
+		logger.Warn("connection lost, attempting to reconnect")
 	}
 }
 
-// Helper function for min
-func min(a, b int) int {
-	if a < b {
-		return a
+// truncate cuts s to at most n bytes without splitting a character
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
 	}
-	return b
+	return s[:n]
 }
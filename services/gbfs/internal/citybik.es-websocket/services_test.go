@@ -0,0 +1,153 @@
+package citybikeswebsocket
+
+import (
+	"context"
+	"encoding/json"
+	batchqueue "gbfs-service/internal/batch-queue"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// seedFrames adds the frames captured in testdata/*.txt to the corpus
+func seedFrames(f *testing.F) []string {
+	f.Helper()
+
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.txt"))
+	if err != nil {
+		f.Fatal(err)
+	}
+
+	frames := make([]string, 0, len(paths))
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		frames = append(frames, string(data))
+	}
+	return frames
+}
+
+// newQueue is a queue that never flushes during a fuzz iteration. A diff
+// queues at most one station, so a small capacity is never reached.
+func newQueue() *batchqueue.StationQueue {
+	return batchqueue.CreateBatchQueue(8, time.Hour)
+}
+
+// checkQueued fails if a queued station wouldn't pass validation. A diff
+// carries one station, so whatever was queued came from it.
+func checkQueued(t *testing.T, queue *batchqueue.StationQueue, diff []byte) {
+	t.Helper()
+
+	if len(queue.Records) == 0 {
+		return
+	}
+	if len(queue.Records) > 1 {
+		t.Fatalf("one diff queued %d stations", len(queue.Records))
+	}
+
+	var parsed citybikes.Diff
+	if err := json.Unmarshal(diff, &parsed); err != nil || parsed.Message == nil || parsed.Message.Station == nil {
+		t.Fatalf("station queued from a diff without one: %s", diff)
+	}
+	message := parsed.Message
+	if err := mappingvalidation.Station(*message.Station, queue.Records[0], message.Network); err != nil {
+		t.Fatalf("queued station fails validation: %v", err)
+	}
+}
+
+func FuzzProcessWebSocketMessage(f *testing.F) {
+	for _, frame := range seedFrames(f) {
+		f.Add(frame)
+	}
+	f.Add("2")
+	f.Add("42")
+	f.Add(`42["diff"]`)
+	f.Add(`42[1,{}]`)
+
+	f.Fuzz(func(t *testing.T, msg string) {
+		queue := newQueue()
+		processWebSocketMessage(context.Background(), msg, queue)
+
+		if len(queue.Records) > 0 {
+			_, payload, err := parseEvent(strings.TrimPrefix(msg, "42"))
+			if err != nil {
+				t.Fatalf("station queued from an unparseable frame: %v", err)
+			}
+			checkQueued(t, queue, payload)
+		}
+	})
+}
+
+func FuzzProcessDiffEvent(f *testing.F) {
+	for _, frame := range seedFrames(f) {
+		if _, payload, err := parseEvent(strings.TrimPrefix(frame, "42")); err == nil && payload != nil {
+			f.Add([]byte(payload))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, diff []byte) {
+		queue := newQueue()
+		processDiffEvent(context.Background(), diff, queue)
+		checkQueued(t, queue, diff)
+	})
+}
+
+// TestShutdownWhilePinging closes a connection that is pinging as fast as it
+// can; the close frame must not race the ping writes (run with -race)
+func TestShutdownWhilePinging(t *testing.T) {
+	closed := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+					close(closed)
+				}
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := New(config.WebSocketConfig{PingInterval: time.Millisecond}, time.Hour, newQueue())
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() { done <- c.handleConnection(ctx, conn) }()
+
+	time.Sleep(20 * time.Millisecond)
+	cancel()
+
+	select {
+	case shutdown := <-done:
+		if !shutdown {
+			t.Error("handleConnection asked to reconnect on shutdown")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("handleConnection didn't return on shutdown")
+	}
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Error("the server never got a normal close frame")
+	}
+}
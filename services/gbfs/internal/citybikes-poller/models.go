@@ -0,0 +1,125 @@
+package citybikespoller
+
+import (
+	"crypto/sha256"
+	"gbfs-service/internal/config"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Poller periodically fetches station and vehicle data for the configured
+// networks from the citybik.es REST API
+type Poller struct {
+	client *http.Client
+
+	// cfg can be swapped at runtime by Reload; readers take a snapshot
+	mu  sync.RWMutex
+	cfg config.PollerConfig
+
+	schedule *schedule
+
+	// Validators of the last payload written per network
+	payloadsMu sync.Mutex
+	payloads   map[string]payloadValidators
+
+	// Wakes the polling loop when the schedule changed outside of a poll
+	reschedule chan struct{}
+
+	// When each network last failed a stale sweep, so a broken network
+	// doesn't take the head of every sweep
+	staleMu       sync.Mutex
+	staleFailures map[string]time.Time
+}
+
+// payloadValidators identify a network payload so an unchanged one can be
+// recognised without downloading or mapping it again
+type payloadValidators struct {
+	etag         string
+	lastModified string
+	hash         [sha256.Size]byte
+	writtenAt    time.Time
+}
+
+// schedule decides when each network is polled next
+type schedule struct {
+	mu       sync.Mutex
+	networks map[string]*networkState
+}
+
+// networkState is what the scheduler knows about one network
+type networkState struct {
+	lastPoll time.Time
+	nextPoll time.Time
+	interval time.Duration
+	inFlight bool
+
+	// Exponentially weighted changes per hour; negative until two polls
+	// have been compared
+	changeRate float64
+
+	// Fingerprint of every station and vehicle at the last poll
+	fingerprints map[string]uint64
+
+	viewedUntil time.Time
+
+	// Circuit breaker and health
+	breaker             BreakerState
+	consecutiveFailures int
+	lastSuccess         time.Time
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+// BreakerState is the state of a network's circuit breaker. An open breaker
+// stops polling the network until a single half-open probe succeeds.
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half_open"
+)
+
+// Ingest statuses shown to the app
+const (
+	StatusHealthy     = "healthy"
+	StatusDegraded    = "degraded"    // Recent failures, breaker still closed
+	StatusUnavailable = "unavailable" // Breaker open or probing
+)
+
+// networkHealth is a snapshot of a network's breaker and failure history
+type networkHealth struct {
+	status              string
+	breaker             BreakerState
+	consecutiveFailures int
+	lastSuccess         time.Time
+	lastError           string
+	lastErrorAt         time.Time
+}
+
+// StaleSweep summarises one sweep for stale stations. Networks are
+// identified by their citybik.es ID.
+type StaleSweep struct {
+	Stale     int      `json:"stale"`     // Stale networks found
+	Refreshed []string `json:"refreshed"` // Re-fetched and written
+	Failed    []string `json:"failed"`
+	Deferred  []string `json:"deferred"` // Left for a later sweep
+	Removed   int      `json:"removed"`  // Stations marked removed
+}
+
+// NetworkSchedule is a snapshot of one network's polling schedule
+type NetworkSchedule struct {
+	NetworkID       string     `json:"network_id"`
+	IntervalSeconds float64    `json:"interval_seconds"`
+	LastPoll        *time.Time `json:"last_poll,omitempty"`
+	NextPoll        time.Time  `json:"next_poll"`
+	ChangesPerHour  *float64   `json:"changes_per_hour,omitempty"`
+	Viewed          bool       `json:"viewed"`
+
+	Status              string       `json:"status"`
+	Breaker             BreakerState `json:"breaker"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	LastSuccess         *time.Time   `json:"last_success,omitempty"`
+	LastError           string       `json:"last_error,omitempty"`
+}
@@ -0,0 +1,434 @@
+package citybikespoller
+
+import (
+	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	"hash/fnv"
+	"math"
+	"slices"
+	"strings"
+	"time"
+)
+
+// changeRateSmoothing is the weight of the newest observation in the
+// exponentially weighted change rate
+const changeRateSmoothing = 0.3
+
+func newSchedule() *schedule {
+	return &schedule{networks: make(map[string]*networkState)}
+}
+
+// sync adds and removes networks to match the configured list. New networks
+// are due immediately.
+func (s *schedule) sync(cfg config.PollerConfig, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for id := range s.networks {
+		if !slices.Contains(cfg.Networks, id) {
+			delete(s.networks, id)
+		}
+	}
+	for _, id := range cfg.Networks {
+		if _, ok := s.networks[id]; !ok {
+			s.networks[id] = &networkState{nextPoll: now, changeRate: -1, breaker: BreakerClosed}
+		}
+	}
+	s.allocate(cfg, now)
+}
+
+// observe compares a poll's data with the previous poll of the network,
+// updates its change rate, closes its breaker and schedules its next poll
+func (s *schedule) observe(cfg config.PollerConfig, networkID string, data *citybikes.NetworkResponse, now time.Time) (networkHealth, bool) {
+	fingerprints := make(map[string]uint64, len(data.Network.Stations)+len(data.Network.Vehicles))
+	for _, station := range data.Network.Stations {
+		if station.ID != "" {
+			fingerprints["station:"+station.ID] = fingerprint(station.FreeBikes, station.EmptySlots, station.Latitude, station.Longitude)
+		}
+	}
+	for _, vehicle := range data.Network.Vehicles {
+		if vehicle.ID != "" {
+			latitude, longitude, _ := vehicle.Coordinates()
+			fingerprints["vehicle:"+vehicle.ID] = fingerprint(latitude, longitude)
+		}
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.networks[networkID]
+	if !ok {
+		return networkHealth{}, false // Removed by a reload while the poll was in flight
+	}
+
+	if state.fingerprints != nil {
+		s.updateRate(state, countChanges(state.fingerprints, fingerprints), now)
+	}
+	state.fingerprints = fingerprints
+	state.lastPoll = now
+	succeeded(networkID, state, now)
+
+	s.allocate(cfg, now)
+	return state.health(), true
+}
+
+// unchanged records a poll that found the network exactly as before
+func (s *schedule) unchanged(cfg config.PollerConfig, networkID string, now time.Time) (networkHealth, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.networks[networkID]
+	if !ok {
+		return networkHealth{}, false
+	}
+	if state.fingerprints != nil {
+		s.updateRate(state, 0, now)
+	}
+	state.lastPoll = now
+	succeeded(networkID, state, now)
+
+	s.allocate(cfg, now)
+	return state.health(), true
+}
+
+// updateRate folds the changes seen since the last poll into the network's
+// change rate; callers hold mu
+func (s *schedule) updateRate(state *networkState, changes int, now time.Time) {
+	elapsed := now.Sub(state.lastPoll).Hours()
+	if elapsed <= 0 {
+		return
+	}
+	rate := float64(changes) / elapsed
+	if state.changeRate < 0 {
+		state.changeRate = rate
+	} else {
+		state.changeRate = changeRateSmoothing*rate + (1-changeRateSmoothing)*state.changeRate
+	}
+}
+
+// failed records a failed fetch. The breaker opens after BreakerThreshold
+// consecutive failures, or straight away when a half-open probe fails.
+func (s *schedule) failed(cfg config.PollerConfig, networkID string, err error, now time.Time) (networkHealth, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.networks[networkID]
+	if !ok {
+		return networkHealth{}, false
+	}
+
+	state.lastPoll = now
+	state.consecutiveFailures++
+	state.lastError = err.Error()
+	state.lastErrorAt = now
+
+	if state.breaker == BreakerHalfOpen || (state.breaker == BreakerClosed && state.consecutiveFailures >= cfg.BreakerThreshold) {
+		state.breaker = BreakerOpen
+		logger.Warn("circuit breaker opened, pausing polls",
+			logging.NetworkID(networkID),
+			"consecutive_failures", state.consecutiveFailures,
+			"retry_at", now.Add(cfg.BreakerCooldown),
+		)
+	}
+
+	s.allocate(cfg, now)
+	return state.health(), true
+}
+
+// skipped reschedules a network whose poll didn't reach upstream, keeping
+// its interval and breaker as they were
+func (s *schedule) skipped(cfg config.PollerConfig, networkID string, now time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.networks[networkID]; ok {
+		state.lastPoll = now
+		if state.breaker == BreakerHalfOpen {
+			state.breaker = BreakerOpen
+		}
+		s.allocate(cfg, now)
+	}
+}
+
+// probe is called when a poll starts; an open breaker turns half-open so the
+// poll's outcome decides whether it closes again
+func (s *schedule) probe(networkID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.networks[networkID]; ok && state.breaker == BreakerOpen {
+		state.breaker = BreakerHalfOpen
+		logger.Info("circuit breaker half-open, probing network", logging.NetworkID(networkID))
+	}
+}
+
+// succeeded resets a network's failure count and closes its breaker
+func succeeded(networkID string, state *networkState, now time.Time) {
+	if state.breaker != BreakerClosed {
+		logger.Info("circuit breaker closed, network recovered",
+			logging.NetworkID(networkID),
+			"failures", state.consecutiveFailures,
+		)
+	}
+	state.breaker = BreakerClosed
+	state.consecutiveFailures = 0
+	state.lastSuccess = now
+}
+
+// lastSuccess returns when the network was last fetched successfully
+func (s *schedule) lastSuccess(networkID string) time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.networks[networkID]; ok {
+		return state.lastSuccess
+	}
+	return time.Time{}
+}
+
+// health summarises a network's breaker for the app and operators
+func (state *networkState) health() networkHealth {
+	status := StatusHealthy
+	switch {
+	case state.breaker != BreakerClosed:
+		status = StatusUnavailable
+	case state.consecutiveFailures > 0:
+		status = StatusDegraded
+	}
+	return networkHealth{
+		status:              status,
+		breaker:             state.breaker,
+		consecutiveFailures: state.consecutiveFailures,
+		lastSuccess:         state.lastSuccess,
+		lastError:           state.lastError,
+		lastErrorAt:         state.lastErrorAt,
+	}
+}
+
+// view marks a network as being looked at by users until the TTL passes
+func (s *schedule) view(cfg config.PollerConfig, networkID string, now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.networks[networkID]
+	if !ok {
+		return false
+	}
+	state.viewedUntil = now.Add(cfg.ViewerTTL)
+	s.allocate(cfg, now)
+	return true
+}
+
+// next returns the network that is due first and when, ignoring networks
+// that are being polled
+func (s *schedule) next() (string, time.Time, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var (
+		due   string
+		dueAt time.Time
+	)
+	for id, state := range s.networks {
+		if state.inFlight {
+			continue
+		}
+		if due == "" || state.nextPoll.Before(dueAt) || (state.nextPoll.Equal(dueAt) && id < due) {
+			due, dueAt = id, state.nextPoll
+		}
+	}
+	return due, dueAt, due != ""
+}
+
+// dispatch marks a network as being polled
+func (s *schedule) dispatch(networkID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.networks[networkID]; ok {
+		state.inFlight = true
+	}
+}
+
+// finish marks a network's poll as done
+func (s *schedule) finish(networkID string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if state, ok := s.networks[networkID]; ok {
+		state.inFlight = false
+	}
+}
+
+// snapshot returns every network's schedule, sorted by network ID
+func (s *schedule) snapshot(now time.Time) []NetworkSchedule {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]NetworkSchedule, 0, len(s.networks))
+	for id, state := range s.networks {
+		entry := NetworkSchedule{
+			NetworkID:       id,
+			IntervalSeconds: state.interval.Seconds(),
+			NextPoll:        state.nextPoll,
+			Viewed:          now.Before(state.viewedUntil),
+		}
+		if !state.lastPoll.IsZero() {
+			lastPoll := state.lastPoll
+			entry.LastPoll = &lastPoll
+		}
+		if state.changeRate >= 0 {
+			rate := state.changeRate
+			entry.ChangesPerHour = &rate
+		}
+		health := state.health()
+		entry.Status = health.status
+		entry.Breaker = health.breaker
+		entry.ConsecutiveFailures = health.consecutiveFailures
+		entry.LastError = health.lastError
+		if !health.lastSuccess.IsZero() {
+			lastSuccess := health.lastSuccess
+			entry.LastSuccess = &lastSuccess
+		}
+		out = append(out, entry)
+	}
+	slices.SortFunc(out, func(a, b NetworkSchedule) int { return strings.Compare(a.NetworkID, b.NetworkID) })
+	return out
+}
+
+// allocate shares the hourly budget out in proportion to each network's
+// weight, clamped to [MinInterval, MaxInterval], and moves next polls
+// accordingly. Networks with an open breaker only get one probe per
+// BreakerCooldown; the rest of their share goes to healthy networks.
+// Callers hold mu.
+func (s *schedule) allocate(cfg config.PollerConfig, now time.Time) {
+	if len(s.networks) == 0 {
+		return
+	}
+
+	// Networks without a measured change rate yet get the average weight
+	var known, total float64
+	for _, state := range s.networks {
+		if state.changeRate >= 0 {
+			known++
+			total += state.changeRate
+		}
+	}
+	fallback := 1.0
+	if known > 0 && total > 0 {
+		fallback = total / known
+	}
+
+	budget := float64(cfg.RequestsPerHour)
+	weights := make(map[string]float64, len(s.networks))
+	for id, state := range s.networks {
+		if state.breaker != BreakerClosed {
+			// Probes never take more than an even share of the budget
+			budget -= min(time.Hour.Seconds()/cfg.BreakerCooldown.Seconds(), float64(cfg.RequestsPerHour)/float64(len(s.networks)))
+			continue
+		}
+
+		weight := fallback
+		if state.changeRate >= 0 {
+			// A floor keeps networks that never change at a minimal share
+			weight = max(state.changeRate, fallback*0.01, 1e-6)
+		}
+		if now.Before(state.viewedUntil) {
+			weight *= cfg.ViewerBoost
+		}
+		weights[id] = weight
+	}
+
+	rates := allocateRates(weights, budget,
+		time.Hour.Seconds()/cfg.MaxInterval.Seconds(),
+		time.Hour.Seconds()/cfg.MinInterval.Seconds(),
+	)
+
+	for id, state := range s.networks {
+		if state.breaker != BreakerClosed {
+			state.interval = cfg.BreakerCooldown
+			state.nextPoll = state.lastPoll.Add(cfg.BreakerCooldown)
+			continue
+		}
+
+		// Rounded up to whole seconds so rounding never overspends the budget
+		state.interval = time.Duration(math.Ceil(time.Hour.Seconds()/rates[id])) * time.Second
+		if state.lastPoll.IsZero() {
+			continue // Still waiting for its first poll
+		}
+		state.nextPoll = state.lastPoll.Add(state.interval)
+	}
+}
+
+// allocateRates splits budget (polls per hour) across weights, keeping every
+// rate within [minRate, maxRate]. Rates that hit a bound are fixed there and
+// the rest of the budget is shared out again among the others. Rates above
+// maxRate are fixed first: the budget they free may lift others off minRate.
+func allocateRates(weights map[string]float64, budget, minRate, maxRate float64) map[string]float64 {
+	rates := make(map[string]float64, len(weights))
+	fixed := make(map[string]bool, len(weights))
+
+	for len(fixed) < len(weights) {
+		remaining, weightSum := budget, 0.0
+		for id, weight := range weights {
+			if fixed[id] {
+				remaining -= rates[id]
+			} else {
+				weightSum += weight
+			}
+		}
+
+		var above, below []string
+		for id, weight := range weights {
+			if fixed[id] {
+				continue
+			}
+			rates[id] = max(remaining, 0) * weight / weightSum
+			switch {
+			case rates[id] > maxRate:
+				above = append(above, id)
+			case rates[id] < minRate:
+				below = append(below, id)
+			}
+		}
+
+		clamp, bound := above, maxRate
+		if len(above) == 0 {
+			clamp, bound = below, minRate
+		}
+		if len(clamp) == 0 {
+			break
+		}
+		for _, id := range clamp {
+			rates[id], fixed[id] = bound, true
+		}
+	}
+	return rates
+}
+
+// fingerprint hashes the fields of a record that indicate a change
+func fingerprint(values ...any) uint64 {
+	h := fnv.New64a()
+	for _, value := range values {
+		fmt.Fprintf(h, "%v\x00", value)
+	}
+	return h.Sum64()
+}
+
+// countChanges counts records that appeared, disappeared or changed
+func countChanges(previous, current map[string]uint64) int {
+	changes := 0
+	for id, fp := range current {
+		if old, ok := previous[id]; !ok || old != fp {
+			changes++
+		}
+	}
+	for id := range previous {
+		if _, ok := current[id]; !ok {
+			changes++
+		}
+	}
+	return changes
+}
@@ -0,0 +1,122 @@
+package citybikespoller
+
+import (
+	"errors"
+	"fmt"
+	"gbfs-service/internal/config"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestAllocateRates(t *testing.T) {
+	tests := []struct {
+		name             string
+		weights          map[string]float64
+		budget           float64
+		minRate, maxRate float64
+		want             map[string]float64
+	}{
+		{
+			name:    "shared in proportion to weight",
+			weights: map[string]float64{"a": 1, "b": 3},
+			budget:  100, minRate: 1, maxRate: 100,
+			want: map[string]float64{"a": 25, "b": 75},
+		},
+		{
+			name:    "share above the maximum goes to the others",
+			weights: map[string]float64{"a": 1, "b": 1, "c": 100},
+			budget:  100, minRate: 1, maxRate: 40,
+			want: map[string]float64{"a": 30, "b": 30, "c": 40},
+		},
+		{
+			name:    "networks below the minimum are lifted to it",
+			weights: map[string]float64{"a": 0.001, "b": 1, "c": 1},
+			budget:  100, minRate: 10, maxRate: 100,
+			want: map[string]float64{"a": 10, "b": 45, "c": 45},
+		},
+		{
+			name:    "budget freed at the maximum lifts networks off the minimum",
+			weights: map[string]float64{"a": 1, "b": 1000, "c": 1},
+			budget:  100, minRate: 10, maxRate: 50,
+			want: map[string]float64{"a": 25, "b": 50, "c": 25},
+		},
+		{
+			name:    "budget beyond every maximum is left unused",
+			weights: map[string]float64{"a": 1, "b": 2},
+			budget:  100, minRate: 1, maxRate: 20,
+			want: map[string]float64{"a": 20, "b": 20},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rates := allocateRates(tt.weights, tt.budget, tt.minRate, tt.maxRate)
+
+			sum := 0.0
+			for id, want := range tt.want {
+				if math.Abs(rates[id]-want) > 1e-9 {
+					t.Errorf("%s: rate %g, want %g", id, rates[id], want)
+				}
+				if rates[id] < tt.minRate-1e-9 || rates[id] > tt.maxRate+1e-9 {
+					t.Errorf("%s: rate %g outside [%g, %g]", id, rates[id], tt.minRate, tt.maxRate)
+				}
+				sum += rates[id]
+			}
+			if sum > tt.budget+1e-9 {
+				t.Errorf("rates sum to %g, over the budget of %g", sum, tt.budget)
+			}
+		})
+	}
+}
+
+// TestAllocate checks the intervals a schedule gives its networks against
+// the budget and the configured bounds
+func TestAllocate(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	cfg := config.Defaults().Poller
+	cfg.Networks = nil
+	for i := range 40 {
+		cfg.Networks = append(cfg.Networks, fmt.Sprintf("network-%02d", i))
+	}
+
+	s := newSchedule()
+	s.sync(cfg, start)
+	for i, id := range cfg.Networks {
+		state := s.networks[id]
+		state.lastPoll = start
+		state.changeRate = float64(i * i) // From never changing to very busy
+	}
+	s.networks["network-00"].changeRate = 500 // Same as network-01 once viewed...
+	s.networks["network-01"].changeRate = 500
+	s.view(cfg, "network-01", start) // ...but looked at
+	for range cfg.BreakerThreshold {
+		s.failed(cfg, "network-02", errors.New("unavailable"), start)
+	}
+
+	spent := 0.0
+	for id, state := range s.networks {
+		if state.breaker != BreakerClosed {
+			if state.interval != cfg.BreakerCooldown {
+				t.Errorf("%s: interval %s with an open breaker, want the cooldown %s", id, state.interval, cfg.BreakerCooldown)
+			}
+		} else if state.interval < cfg.MinInterval || state.interval > cfg.MaxInterval {
+			t.Errorf("%s: interval %s outside [%s, %s]", id, state.interval, cfg.MinInterval, cfg.MaxInterval)
+		}
+		spent += time.Hour.Seconds() / state.interval.Seconds()
+	}
+	if spent > float64(cfg.RequestsPerHour) {
+		t.Errorf("schedule spends %.1f polls an hour, budget is %d", spent, cfg.RequestsPerHour)
+	}
+
+	viewed, unviewed := s.networks["network-01"].interval, s.networks["network-00"].interval
+	if ratio := unviewed.Seconds() / viewed.Seconds(); math.Abs(ratio-cfg.ViewerBoost) > 0.1 {
+		t.Errorf("viewed network polled every %s, unviewed every %s: %.2fx, want the %gx viewer boost", viewed, unviewed, ratio, cfg.ViewerBoost)
+	}
+
+	// The boost ends with the TTL
+	s.allocate(cfg, start.Add(cfg.ViewerTTL))
+	if viewed, unviewed := s.networks["network-01"].interval, s.networks["network-00"].interval; viewed != unviewed {
+		t.Errorf("viewed network still polled every %s after the TTL, unviewed every %s", viewed, unviewed)
+	}
+}
@@ -1,177 +1,634 @@
 package citybikespoller
 
 import (
+	"bufio"
+	"compress/flate"
 	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
 	"fmt"
+	changedetection "gbfs-service/internal/change-detection"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	mappingerrors "gbfs-service/internal/mapping-errors"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	ratelimit "gbfs-service/internal/rate-limit"
 	stationMapper "gbfs-service/internal/station-mapper"
 	supabaseClient "gbfs-service/internal/supabase"
+	"gbfs-service/internal/tracing"
+	"gbfs-service/internal/uuidfy"
 	vehicleMapper "gbfs-service/internal/vehicle-mapper"
 	"io"
-	"log"
 	"net/http"
+	"slices"
 	"strings"
+	"sync"
 	"time"
+
+	"github.com/andybalholm/brotli"
+	"go.opentelemetry.io/otel/attribute"
 )
 
-// CityBikesNetworkResponse represents the API response
-type CityBikesNetworkResponse struct {
-	Network struct {
-		ID       string                   `json:"id"`
-		Stations []map[string]any         `json:"stations"`
-		Vehicles []map[string]any         `json:"vehicles"`
-	} `json:"network"`
+// ErrUnknownNetwork is returned for networks that aren't configured for polling
+var ErrUnknownNetwork = errors.New("network is not configured for polling")
+
+// acceptEncoding lists exactly the encodings decodeContent can undo
+const acceptEncoding = "gzip, deflate, br"
+
+var (
+	// errNotModified means the server answered a conditional request with 304
+	errNotModified = errors.New("not modified")
+
+	// errUnchanged means the payload hashes the same as the last one written
+	errUnchanged = errors.New("payload unchanged")
+)
+
+// New creates a poller from validated configuration
+func New(cfg config.PollerConfig) *Poller {
+	p := &Poller{
+		cfg:        cfg,
+		client:     ratelimit.NewClient(cfg.RequestTimeout),
+		schedule:   newSchedule(),
+		payloads:   make(map[string]payloadValidators),
+		reschedule: make(chan struct{}, 1),
+
+		staleFailures: make(map[string]time.Time),
+	}
+	p.schedule.sync(cfg, time.Now())
+	return p
+}
+
+// config returns a snapshot of the current configuration
+func (p *Poller) config() config.PollerConfig {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	cfg := p.cfg
+	cfg.Networks = slices.Clone(p.cfg.Networks)
+	return cfg
+}
+
+// Reload applies a new network list, rate budget, scheduling bounds and
+// vehicle expiry settings. Polls already in flight finish with the settings
+// they started with; the loop picks up the new schedule before its next poll.
+func (p *Poller) Reload(next config.PollerConfig) {
+	p.mu.Lock()
+	previous := p.cfg
+	p.cfg.Networks = slices.Clone(next.Networks)
+	p.cfg.RequestsPerHour = next.RequestsPerHour
+	p.cfg.MinInterval = next.MinInterval
+	p.cfg.MaxInterval = next.MaxInterval
+	p.cfg.ViewerBoost = next.ViewerBoost
+	p.cfg.ViewerTTL = next.ViewerTTL
+	p.cfg.BreakerThreshold = next.BreakerThreshold
+	p.cfg.BreakerCooldown = next.BreakerCooldown
+	p.cfg.ExpireVehicles = next.ExpireVehicles
+	p.cfg.VehicleRetention = next.VehicleRetention
+	current := p.cfg
+	p.mu.Unlock()
+
+	p.schedule.sync(current, time.Now())
+
+	added, removed := diffNetworks(previous.Networks, current.Networks)
+	p.payloadsMu.Lock()
+	for _, id := range removed {
+		delete(p.payloads, id)
+	}
+	p.payloadsMu.Unlock()
+
+	logger.Info("poller configuration reloaded",
+		"networks", current.Networks,
+		"added", added,
+		"removed", removed,
+		"requests_per_hour", current.RequestsPerHour,
+	)
+	p.wake()
 }
 
-// fetchNetwork fetches station and vehicle data for a network
-func fetchNetwork(networkID string) (*CityBikesNetworkResponse, error) {
-	url := fmt.Sprintf("https://api.citybik.es/v2/networks/%s?fields=id,stations,vehicles", networkID)
+// wake tells the polling loop to look at the schedule again
+func (p *Poller) wake() {
+	select {
+	case p.reschedule <- struct{}{}:
+	default: // A wake-up is already pending
+	}
+}
+
+// diffNetworks returns the IDs only in next and only in previous
+func diffNetworks(previous, next []string) (added, removed []string) {
+	for _, id := range next {
+		if !slices.Contains(previous, id) {
+			added = append(added, id)
+		}
+	}
+	for _, id := range previous {
+		if !slices.Contains(next, id) {
+			removed = append(removed, id)
+		}
+	}
+	return added, removed
+}
+
+// validators returns what is known about the last payload written for a network
+func (p *Poller) validators(networkID string) (payloadValidators, bool) {
+	p.payloadsMu.Lock()
+	defer p.payloadsMu.Unlock()
+
+	v, ok := p.payloads[networkID]
+	return v, ok
+}
+
+func (p *Poller) storeValidators(networkID string, v payloadValidators) {
+	p.payloadsMu.Lock()
+	defer p.payloadsMu.Unlock()
+
+	p.payloads[networkID] = v
+}
+
+// fetchNetwork fetches station and vehicle data for a network. Unless force
+// is set the request is conditional on the last payload written, and
+// errNotModified or errUnchanged is returned when nothing changed.
+func (p *Poller) fetchNetwork(ctx context.Context, networkID string, force bool) (*citybikes.NetworkResponse, payloadValidators, error) {
+	ctx, span := tracer.Start(ctx, "poller.fetch_network")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(networkID))
+
+	cfg := p.config()
+	previous, cached := p.validators(networkID)
+	cached = cached && !force
 
-	req, err := http.NewRequest("GET", url, nil)
+	url := fmt.Sprintf("%s/networks/%s?fields=id,stations,vehicles", cfg.BaseURL, networkID)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %v", err)
+		return nil, previous, tracing.RecordError(span, fmt.Errorf("failed to create request: %v", err))
+	}
+
+	// Wait for the upstream budget first so the timeout only covers the
+	// request itself
+	if err := ratelimit.For(req.URL.Host).Wait(ctx); err != nil {
+		return nil, previous, tracing.RecordError(span, err)
 	}
+	reqCtx, cancel := context.WithTimeout(ratelimit.WithAcquired(ctx), cfg.RequestTimeout)
+	defer cancel()
+	req = req.WithContext(reqCtx)
 
 	// Set headers to mimic browser request (required for rate limiting)
-	req.Header.Set("User-Agent", Config.UserAgent)
+	req.Header.Set("User-Agent", cfg.UserAgent)
 	req.Header.Set("Accept", "*/*")
 	req.Header.Set("Accept-Language", "en-US,en;q=0.5")
-	req.Header.Set("Accept-Encoding", "gzip, deflate, br")
-	req.Header.Set("Origin", Config.Origin)
-	req.Header.Set("Referer", Config.Referer)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	req.Header.Set("Origin", cfg.Origin)
+	req.Header.Set("Referer", cfg.Referer)
 	req.Header.Set("Sec-Fetch-Dest", "empty")
 	req.Header.Set("Sec-Fetch-Mode", "cors")
 	req.Header.Set("Sec-Fetch-Site", "same-site")
+	if cached {
+		if previous.etag != "" {
+			req.Header.Set("If-None-Match", previous.etag)
+		}
+		if previous.lastModified != "" {
+			req.Header.Set("If-Modified-Since", previous.lastModified)
+		}
+	}
 
-	client := &http.Client{Timeout: 30 * time.Second}
-	resp, err := client.Do(req)
+	resp, err := p.client.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %v", err)
+		return nil, previous, tracing.RecordError(span, fmt.Errorf("request failed: %v", err))
 	}
 	defer resp.Body.Close()
 
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
+	if resp.StatusCode == http.StatusNotModified && cached {
+		return nil, previous, errNotModified
+	}
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d error", resp.StatusCode)
+		return nil, previous, tracing.RecordError(span, fmt.Errorf("HTTP %d error", resp.StatusCode))
+	}
+
+	// Decode while hashing the decompressed bytes, so large networks stream
+	// through the decoder instead of being buffered whole
+	reader, err := decodeContent(resp.Body, resp.Header.Get("Content-Encoding"))
+	if err != nil {
+		return nil, previous, tracing.RecordError(span, err)
+	}
+	defer reader.Close()
+
+	hasher := sha256.New()
+	counter := &countingWriter{}
+	body := io.TeeReader(reader, io.MultiWriter(hasher, counter))
+
+	var result citybikes.NetworkResponse
+	if err := json.NewDecoder(body).Decode(&result); err != nil {
+		return nil, previous, tracing.RecordError(span, fmt.Errorf("failed to parse JSON: %v", err))
+	}
+	// Trailing bytes still count towards the hash
+	if _, err := io.Copy(io.Discard, body); err != nil {
+		return nil, previous, tracing.RecordError(span, fmt.Errorf("failed to read response: %v", err))
+	}
+	span.SetAttributes(attribute.Int64("http.response.body.size", counter.n))
+
+	current := payloadValidators{
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+		writtenAt:    previous.writtenAt,
+	}
+	hasher.Sum(current.hash[:0])
+	if cached && current.hash == previous.hash {
+		return nil, current, errUnchanged
 	}
 
-	// Handle gzip-compressed responses
-	var reader io.Reader = resp.Body
-	if strings.Contains(resp.Header.Get("Content-Encoding"), "gzip") {
-		gzReader, err := gzip.NewReader(resp.Body)
+	return &result, current, nil
+}
+
+// decodeContent undoes every Content-Encoding of a response body. Encodings
+// are listed in the order they were applied, so they're removed in reverse.
+func decodeContent(body io.Reader, contentEncoding string) (io.ReadCloser, error) {
+	var encodings []string
+	for _, encoding := range strings.Split(contentEncoding, ",") {
+		if encoding = strings.ToLower(strings.TrimSpace(encoding)); encoding != "" && encoding != "identity" {
+			encodings = append(encodings, encoding)
+		}
+	}
+
+	reader := io.NopCloser(body)
+	var closers []io.Closer
+	for _, encoding := range slices.Backward(encodings) {
+		var err error
+		switch encoding {
+		case "gzip", "x-gzip":
+			var gz *gzip.Reader
+			gz, err = gzip.NewReader(reader)
+			reader = gz
+		case "deflate":
+			reader, err = newDeflateReader(reader)
+		case "br":
+			reader = io.NopCloser(brotli.NewReader(reader))
+		default:
+			err = fmt.Errorf("unsupported content encoding %q", encoding)
+		}
 		if err != nil {
-			return nil, fmt.Errorf("failed to create gzip reader: %v", err)
+			closeAll(closers)
+			return nil, fmt.Errorf("failed to decode %s response: %v", encoding, err)
 		}
-		defer gzReader.Close()
-		reader = gzReader
+		closers = append(closers, reader)
 	}
 
-	body, err := io.ReadAll(reader)
+	return &multiCloser{Reader: reader, closers: closers}, nil
+}
+
+// newDeflateReader reads HTTP "deflate", which should be zlib-wrapped but is
+// sent as a raw deflate stream by some servers
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	buffered := bufio.NewReader(r)
+	header, err := buffered.Peek(2)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response: %v", err)
+		return nil, err
 	}
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(buffered)
+	}
+	return flate.NewReader(buffered), nil
+}
+
+// multiCloser closes every decoder layer, last created first
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
 
-	var result CityBikesNetworkResponse
-	if err := json.Unmarshal(body, &result); err != nil {
-		return nil, fmt.Errorf("failed to parse JSON: %v", err)
+func (m *multiCloser) Close() error {
+	return closeAll(m.closers)
+}
+
+func closeAll(closers []io.Closer) error {
+	var errs []error
+	for _, c := range slices.Backward(closers) {
+		errs = append(errs, c.Close())
 	}
+	return errors.Join(errs...)
+}
+
+// countingWriter counts the bytes written to it
+type countingWriter struct {
+	n int64
+}
 
-	return &result, nil
+func (c *countingWriter) Write(p []byte) (int, error) {
+	c.n += int64(len(p))
+	return len(p), nil
 }
 
 // processNetworkData processes and upserts station and vehicle data
-func processNetworkData(networkID string, data *CityBikesNetworkResponse) error {
-	log.Printf("📊 Processing %s: %d stations, %d vehicles",
-		networkID, len(data.Network.Stations), len(data.Network.Vehicles))
+func processNetworkData(ctx context.Context, networkID string, data *citybikes.NetworkResponse) error {
+	logger.InfoContext(ctx, "processing network",
+		logging.NetworkID(networkID),
+		"stations", len(data.Network.Stations),
+		"vehicles", len(data.Network.Vehicles),
+	)
+
+	var errs []error
+
+	// Records are checked against where the network says it is
+	mappingvalidation.SetArea(networkID, data.Network.Location)
 
 	// Process stations
 	if len(data.Network.Stations) > 0 {
-		stations := make([]map[string]any, 0, len(data.Network.Stations))
-		for _, stationData := range data.Network.Stations {
-			mapped, err := stationMapper.MapStationData(stationData, networkID)
-			if err != nil {
-				log.Printf("⚠️  Failed to map station: %v", err)
-				continue
-			}
-			stations = append(stations, mapped)
-		}
+		now := time.Now()
+		stations := changedetection.Stations.Filter(mapStations(ctx, networkID, data.Network.Stations), now)
 
 		if len(stations) > 0 {
-			if err := supabaseClient.BatchUpsertStations(stations); err != nil {
-				log.Printf("❌ Failed to upsert stations for %s: %v", networkID, err)
+			if written, err := supabaseClient.BatchUpsertStations(ctx, stations); err != nil {
+				logger.ErrorContext(ctx, "failed to upsert stations", logging.NetworkID(networkID), logging.Err(err))
+				errs = append(errs, err)
 			} else {
-				log.Printf("✅ Upserted %d stations for %s", len(stations), networkID)
+				// Stations refused for an ID collision aren't remembered as written
+				changedetection.Stations.Commit(written, now)
+				logger.InfoContext(ctx, "upserted stations", logging.NetworkID(networkID), "count", len(written), "refused", len(stations)-len(written))
 			}
 		}
 	}
 
 	// Process vehicles
 	if len(data.Network.Vehicles) > 0 {
-		vehicles := make([]map[string]any, 0, len(data.Network.Vehicles))
-		for _, vehicleData := range data.Network.Vehicles {
-			mapped, err := vehicleMapper.MapVehicleData(vehicleData, networkID)
-			if err != nil {
-				log.Printf("⚠️  Failed to map vehicle: %v", err)
-				continue
-			}
-			vehicles = append(vehicles, mapped)
-		}
+		now := time.Now()
+		vehicles := changedetection.Vehicles.Filter(mapVehicles(ctx, networkID, data.Network.Vehicles), now)
 
 		if len(vehicles) > 0 {
-			if err := supabaseClient.BatchUpsertVehicles(vehicles); err != nil {
-				log.Printf("❌ Failed to upsert vehicles for %s: %v", networkID, err)
+			if written, err := supabaseClient.BatchUpsertVehicles(ctx, vehicles); err != nil {
+				logger.ErrorContext(ctx, "failed to upsert vehicles", logging.NetworkID(networkID), logging.Err(err))
+				errs = append(errs, err)
 			} else {
-				log.Printf("🛴 Upserted %d vehicles for %s", len(vehicles), networkID)
+				changedetection.Vehicles.Commit(written, now)
+				logger.InfoContext(ctx, "upserted vehicles", logging.NetworkID(networkID), "count", len(vehicles))
 			}
 		}
 	}
 
-	return nil
+	// Returned so the payload isn't treated as written and skipped next time
+	return errors.Join(errs...)
 }
 
-// pollNetwork fetches and processes data for a single network
-func pollNetwork(networkID string) {
-	log.Printf("🔄 Polling network: %s", networkID)
+// mapStations maps a network's stations, recording rejects on the span
+func mapStations(ctx context.Context, networkID string, raw []citybikes.Station) []*stationMapper.StationRecord {
+	ctx, span := tracer.Start(ctx, "poller.map_stations")
+	defer span.End()
+
+	stations := make([]*stationMapper.StationRecord, 0, len(raw))
+	for _, station := range raw {
+		mapped, err := stationMapper.MapStationData(station, networkID)
+		if err == nil {
+			err = mappingvalidation.Station(station, mapped, networkID)
+		}
+		if err != nil {
+			span.RecordError(err)
+			mappingerrors.Record(mappingerrors.SourcePoller, "station", networkID, station.ID, err)
+			logger.WarnContext(ctx, "failed to map station", logging.NetworkID(networkID), logging.Err(err))
+			continue
+		}
+		stations = append(stations, mapped)
+	}
+
+	span.SetAttributes(
+		tracing.NetworkID(networkID),
+		tracing.Count(len(stations)),
+		attribute.Int("rejected", len(raw)-len(stations)),
+	)
+	return stations
+}
+
+// mapVehicles maps a network's vehicles, recording rejects on the span
+func mapVehicles(ctx context.Context, networkID string, raw []citybikes.Vehicle) []*vehicleMapper.VehicleRecord {
+	ctx, span := tracer.Start(ctx, "poller.map_vehicles")
+	defer span.End()
+
+	vehicles := make([]*vehicleMapper.VehicleRecord, 0, len(raw))
+	for _, vehicle := range raw {
+		mapped, err := vehicleMapper.MapVehicleData(vehicle, networkID)
+		if err == nil {
+			err = mappingvalidation.Vehicle(vehicle, mapped, networkID)
+		}
+		if err != nil {
+			span.RecordError(err)
+			mappingerrors.Record(mappingerrors.SourcePoller, "vehicle", networkID, vehicle.ID, err)
+			logger.WarnContext(ctx, "failed to map vehicle", logging.NetworkID(networkID), logging.Err(err))
+			continue
+		}
+		vehicles = append(vehicles, mapped)
+	}
+
+	span.SetAttributes(
+		tracing.NetworkID(networkID),
+		tracing.Count(len(vehicles)),
+		attribute.Int("rejected", len(raw)-len(vehicles)),
+	)
+	return vehicles
+}
+
+// pollNetwork fetches and processes data for a single network. A payload
+// identical to the last one written is skipped unless force is set.
+func (p *Poller) pollNetwork(ctx context.Context, networkID string, force bool) error {
+	ctx, span := tracer.Start(ctx, "poller.poll_network")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(networkID))
+
+	logger.DebugContext(ctx, "polling network", logging.NetworkID(networkID))
+	p.schedule.probe(networkID)
+	previousSuccess := p.schedule.lastSuccess(networkID)
+
+	// An unchanged payload still has to be processed once per heartbeat so
+	// fetched_at is refreshed
+	if previous, ok := p.validators(networkID); ok && time.Since(previous.writtenAt) >= changedetection.Heartbeat() {
+		force = true
+	}
+
+	data, validators, err := p.fetchNetwork(ctx, networkID, force)
+	switch {
+	case errors.Is(err, errNotModified), errors.Is(err, errUnchanged):
+		if health, ok := p.schedule.unchanged(p.config(), networkID, time.Now()); ok {
+			persistHealth(ctx, networkID, health)
+		}
+		p.storeValidators(networkID, validators)
+		span.SetAttributes(attribute.Bool("unchanged", true))
+		logger.DebugContext(ctx, "network unchanged, skipping", logging.NetworkID(networkID), "reason", err.Error())
+		return nil
+	case errors.Is(err, ratelimit.ErrBudgetExhausted), ctx.Err() != nil:
+		// Not the network's fault, so the breaker doesn't count it
+		p.schedule.skipped(p.config(), networkID, time.Now())
+		tracing.RecordError(span, err)
+		logger.WarnContext(ctx, "network poll skipped", logging.NetworkID(networkID), logging.Err(err))
+		return err
+	case err != nil:
+		if health, ok := p.schedule.failed(p.config(), networkID, err, time.Now()); ok {
+			persistHealth(ctx, networkID, health)
+		}
+		tracing.RecordError(span, err)
+		logger.ErrorContext(ctx, "failed to fetch network", logging.NetworkID(networkID), logging.Err(err))
+		return err
+	}
+
+	// The poll only counts as a success once its data was written, so failing
+	// writes trip the breaker and show in the ingest status
+	if err := processNetworkData(ctx, networkID, data); err != nil {
+		if ctx.Err() != nil {
+			p.schedule.skipped(p.config(), networkID, time.Now())
+		} else if health, ok := p.schedule.failed(p.config(), networkID, err, time.Now()); ok {
+			persistHealth(ctx, networkID, health)
+		}
+		tracing.RecordError(span, err)
+		logger.ErrorContext(ctx, "failed to process network", logging.NetworkID(networkID), logging.Err(err))
+		return err
+	}
+	if health, ok := p.schedule.observe(p.config(), networkID, data, time.Now()); ok {
+		persistHealth(ctx, networkID, health)
+	}
+	validators.writtenAt = time.Now()
+	p.storeValidators(networkID, validators)
+
+	if p.config().ExpireVehicles {
+		if err := p.expireVehicles(ctx, networkID, data.Network.Vehicles, previousSuccess); err != nil {
+			logger.WarnContext(ctx, "failed to expire vehicles", logging.NetworkID(networkID), logging.Err(err))
+		}
+	}
 
-	data, err := fetchNetwork(networkID)
+	return nil
+}
+
+// persistHealth stores a network's ingest status so the app can tell when
+// live data is unavailable
+func persistHealth(ctx context.Context, networkID string, health networkHealth) {
+	networkUUID, err := uuidfy.Network(networkID)
 	if err != nil {
-		log.Printf("❌ Failed to fetch %s: %v", networkID, err)
+		logger.WarnContext(ctx, "failed to generate network ID for ingest status", logging.NetworkID(networkID), logging.Err(err))
 		return
 	}
 
-	if err := processNetworkData(networkID, data); err != nil {
-		log.Printf("❌ Failed to process %s: %v", networkID, err)
+	record := supabaseClient.IngestStatusRecord{
+		NetworkID:           networkUUID,
+		Status:              health.status,
+		Breaker:             string(health.breaker),
+		ConsecutiveFailures: health.consecutiveFailures,
+		LastSuccessAt:       formatTime(health.lastSuccess),
+		LastErrorAt:         formatTime(health.lastErrorAt),
+		UpdatedAt:           time.Now().UTC().Format(time.RFC3339),
+	}
+	if health.lastError != "" {
+		record.LastError = &health.lastError
 	}
+
+	if err := supabaseClient.UpsertIngestStatus(ctx, record); err != nil {
+		logger.WarnContext(ctx, "failed to persist ingest status", logging.NetworkID(networkID), logging.Err(err))
+	}
+}
+
+// formatTime formats t as RFC 3339 in UTC, or nil for the zero time
+func formatTime(t time.Time) *string {
+	if t.IsZero() {
+		return nil
+	}
+	formatted := t.UTC().Format(time.RFC3339)
+	return &formatted
+}
+
+// Networks returns the network IDs the poller is configured to poll
+func (p *Poller) Networks() []string {
+	return p.config().Networks
 }
 
-// StartPoller starts the polling loop for all configured networks
-func StartPoller() {
-	if len(Config.NetworkIDs) == 0 {
-		log.Println("⚠️  No networks configured for polling")
+// Schedule returns the current polling schedule of every network
+func (p *Poller) Schedule() []NetworkSchedule {
+	return p.schedule.snapshot(time.Now())
+}
+
+// PollNow polls a configured network immediately, outside the regular schedule
+func (p *Poller) PollNow(ctx context.Context, networkID string) error {
+	if !slices.Contains(p.config().Networks, networkID) {
+		return fmt.Errorf("%w: %s", ErrUnknownNetwork, networkID)
+	}
+
+	// Operators expect a manual poll to rewrite the data, so skip the cache
+	logger.InfoContext(ctx, "manual poll requested", logging.NetworkID(networkID))
+	return p.pollNetwork(ctx, networkID, true)
+}
+
+// MarkViewed records that users are looking at a network. It gets a larger
+// share of the budget until the viewer TTL passes without another call.
+func (p *Poller) MarkViewed(networkID string) error {
+	if !p.schedule.view(p.config(), networkID, time.Now()) {
+		return fmt.Errorf("%w: %s", ErrUnknownNetwork, networkID)
+	}
+
+	logger.Debug("network viewed", logging.NetworkID(networkID))
+	p.wake()
+	return nil
+}
+
+// Start polls each configured network whenever the schedule says it is due,
+// until ctx is done. Due networks are handed to a pool of Concurrency
+// workers so one slow upstream doesn't hold up the others. Every network is
+// due once at startup; the shared rate limiter paces those first polls.
+func (p *Poller) Start(ctx context.Context) {
+	cfg := p.config()
+	if len(cfg.Networks) == 0 {
+		logger.Warn("no networks configured for polling")
 		return
 	}
 
-	log.Printf("🚀 Starting CityBikes poller")
-	log.Printf("   Networks: %v", Config.NetworkIDs)
-	log.Printf("   Rate limit: %d requests/hour", Config.RequestsPerHour)
-	log.Printf("   Polling interval: %v", Config.PollingInterval)
+	logger.Info("starting CityBikes poller",
+		"networks", cfg.Networks,
+		"requests_per_hour", cfg.RequestsPerHour,
+		"concurrency", cfg.Concurrency,
+	)
 
-	// Initial poll for all networks
-	for _, networkID := range Config.NetworkIDs {
-		pollNetwork(networkID)
-		// Small delay between initial requests
-		time.Sleep(2 * time.Second)
+	jobs := make(chan string)
+	var workers sync.WaitGroup
+	for range cfg.Concurrency {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for networkID := range jobs {
+				p.pollNetwork(ctx, networkID, false)
+				p.schedule.finish(networkID)
+				p.wake()
+			}
+		}()
 	}
+	defer func() {
+		close(jobs)
+		workers.Wait()
+		logger.Info("poller stopped")
+	}()
+
+	go p.purgeGoneVehicles(ctx)
 
-	// Start polling loop
-	ticker := time.NewTicker(Config.PollingInterval)
-	defer ticker.Stop()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
 
-	networkIndex := 0
-	for range ticker.C {
-		// Round-robin through networks
-		networkID := Config.NetworkIDs[networkIndex]
-		pollNetwork(networkID)
+	for {
+		networkID, dueAt, ok := p.schedule.next()
+		if ok {
+			timer.Reset(max(time.Until(dueAt), 0))
+		} else {
+			timer.Stop()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.reschedule:
+			continue
+		case <-timer.C:
+		}
 
-		networkIndex = (networkIndex + 1) % len(Config.NetworkIDs)
+		// Blocks while every worker is busy
+		p.schedule.dispatch(networkID)
+		select {
+		case jobs <- networkID:
+		case <-ctx.Done():
+			return
+		}
 	}
 }
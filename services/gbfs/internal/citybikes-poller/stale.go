@@ -0,0 +1,178 @@
+package citybikespoller
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	changedetection "gbfs-service/internal/change-detection"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	ratelimit "gbfs-service/internal/rate-limit"
+	supabaseClient "gbfs-service/internal/supabase"
+	"gbfs-service/internal/tracing"
+	"gbfs-service/internal/uuidfy"
+	"slices"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// RefreshStaleEvery sweeps for stale stations every cfg.RefreshInterval
+// until ctx is done
+func (p *Poller) RefreshStaleEvery(ctx context.Context, cfg config.StaleConfig) {
+	logger.Info("starting stale station sweep",
+		"interval", cfg.RefreshInterval,
+		"threshold", cfg.Threshold,
+		"max_networks", cfg.MaxNetworks,
+	)
+
+	ticker := time.NewTicker(cfg.RefreshInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := p.RefreshStale(ctx, cfg); err != nil {
+			logger.WarnContext(ctx, "stale station sweep failed", logging.Err(err))
+		}
+	}
+}
+
+// RefreshStale re-fetches up to cfg.MaxNetworks networks whose stations
+// haven't been written within cfg.Threshold, stalest first, and marks
+// stations they no longer list as removed. Requests draw from the same
+// upstream budget as regular polls; once it runs dry the remaining networks
+// are deferred to the next sweep. Networks the poller schedules itself are
+// left to the schedule.
+func (p *Poller) RefreshStale(ctx context.Context, cfg config.StaleConfig) (*StaleSweep, error) {
+	ctx, span := tracer.Start(ctx, "poller.refresh_stale")
+	defer span.End()
+
+	now := time.Now()
+	networks, err := supabaseClient.FetchStaleNetworks(ctx, now.Add(-cfg.Threshold), cfg.MaxNetworks)
+	if err != nil {
+		return nil, tracing.RecordError(span, err)
+	}
+
+	sweep := &StaleSweep{Stale: len(networks)}
+	scheduled := p.config().Networks
+
+	for i, network := range networks {
+		networkID := network.CitybikesID
+		if slices.Contains(scheduled, networkID) {
+			continue
+		}
+		if p.staleBackoff(networkID, cfg.Threshold, now) {
+			sweep.Deferred = append(sweep.Deferred, networkID)
+			continue
+		}
+
+		removed, err := p.refreshStaleNetwork(ctx, network)
+		switch {
+		case errors.Is(err, ratelimit.ErrBudgetExhausted), ctx.Err() != nil:
+			for _, rest := range networks[i:] {
+				sweep.Deferred = append(sweep.Deferred, rest.CitybikesID)
+			}
+			logger.InfoContext(ctx, "upstream budget exhausted, deferring stale networks", "deferred", len(networks)-i)
+		case err != nil:
+			p.staleFailed(networkID, now)
+			sweep.Failed = append(sweep.Failed, networkID)
+			logger.WarnContext(ctx, "failed to refresh stale network", logging.NetworkID(networkID), logging.Err(err))
+			continue
+		default:
+			sweep.Refreshed = append(sweep.Refreshed, networkID)
+			sweep.Removed += removed
+			continue
+		}
+		break
+	}
+
+	span.SetAttributes(
+		attribute.Int("stale", sweep.Stale),
+		attribute.Int("refreshed", len(sweep.Refreshed)),
+		attribute.Int("failed", len(sweep.Failed)),
+		attribute.Int("deferred", len(sweep.Deferred)),
+		attribute.Int("removed", sweep.Removed),
+	)
+	if sweep.Stale > 0 {
+		logger.InfoContext(ctx, "stale station sweep finished",
+			"stale", sweep.Stale,
+			"refreshed", len(sweep.Refreshed),
+			"failed", len(sweep.Failed),
+			"deferred", len(sweep.Deferred),
+			"removed", sweep.Removed,
+		)
+	}
+	return sweep, nil
+}
+
+// refreshStaleNetwork fetches and writes one stale network, then marks the
+// stations it no longer lists as removed. It returns how many were marked.
+func (p *Poller) refreshStaleNetwork(ctx context.Context, network supabaseClient.StaleNetwork) (int, error) {
+	ctx, span := tracer.Start(ctx, "poller.refresh_stale_network")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(network.CitybikesID))
+
+	logger.DebugContext(ctx, "refreshing stale network", logging.NetworkID(network.CitybikesID), "oldest_fetched_at", network.OldestFetchedAt)
+
+	data, _, err := p.fetchNetwork(ctx, network.CitybikesID, true)
+	if err != nil {
+		return 0, tracing.RecordError(span, err)
+	}
+	if err := processNetworkData(ctx, network.CitybikesID, data); err != nil {
+		return 0, tracing.RecordError(span, err)
+	}
+	if p.config().ExpireVehicles {
+		if err := p.expireVehicles(ctx, network.CitybikesID, data.Network.Vehicles, time.Time{}); err != nil {
+			logger.WarnContext(ctx, "failed to expire vehicles", logging.NetworkID(network.CitybikesID), logging.Err(err))
+		}
+	}
+
+	// An empty listing is more likely an upstream hiccup than a network
+	// that removed every station; the catalogue refresh handles networks
+	// that are really gone
+	if len(data.Network.Stations) == 0 {
+		logger.WarnContext(ctx, "stale network listed no stations, not marking any removed", logging.NetworkID(network.CitybikesID))
+		return 0, nil
+	}
+
+	listed := make(map[string]bool, len(data.Network.Stations))
+	for _, station := range data.Network.Stations {
+		if id, err := uuidfy.Station(network.CitybikesID, station.ID); err == nil {
+			listed[id] = true
+		}
+	}
+
+	removed, err := supabaseClient.MarkStationsRemoved(ctx, network.ID, listed)
+	// A removed station that comes back has to be written even if nothing
+	// else about it changed, so removed_at is cleared
+	changedetection.Stations.Forget(removed)
+	if err != nil {
+		return len(removed), tracing.RecordError(span, fmt.Errorf("failed to mark vanished stations: %w", err))
+	}
+	return len(removed), nil
+}
+
+// staleBackoff reports whether the network failed a sweep within backoff
+func (p *Poller) staleBackoff(networkID string, backoff time.Duration, now time.Time) bool {
+	p.staleMu.Lock()
+	defer p.staleMu.Unlock()
+
+	failedAt, ok := p.staleFailures[networkID]
+	if ok && now.Sub(failedAt) >= backoff {
+		delete(p.staleFailures, networkID)
+		return false
+	}
+	return ok
+}
+
+func (p *Poller) staleFailed(networkID string, now time.Time) {
+	p.staleMu.Lock()
+	defer p.staleMu.Unlock()
+
+	p.staleFailures[networkID] = now
+}
@@ -0,0 +1,104 @@
+package citybikespoller
+
+import (
+	"context"
+	changedetection "gbfs-service/internal/change-detection"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/logging"
+	supabaseClient "gbfs-service/internal/supabase"
+	"gbfs-service/internal/timestamps"
+	"gbfs-service/internal/tracing"
+	"gbfs-service/internal/uuidfy"
+	"time"
+)
+
+// vehiclePurgeInterval is how often vehicles gone for longer than the
+// retention are deleted
+const vehiclePurgeInterval = time.Hour
+
+// expireVehicles marks the network's stored vehicles that the latest payload
+// no longer reports as gone. A vehicle was last seen when it was last
+// written or at the previous successful fetch, whichever is later.
+func (p *Poller) expireVehicles(ctx context.Context, networkID string, reported []citybikes.Vehicle, previousSuccess time.Time) error {
+	ctx, span := tracer.Start(ctx, "poller.expire_vehicles")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(networkID))
+
+	// Station-only networks report no vehicles, and a network whose whole
+	// fleet vanished at once is more likely an upstream hiccup
+	if len(reported) == 0 {
+		return nil
+	}
+
+	recordID, err := uuidfy.Network(networkID)
+	if err != nil {
+		return tracing.RecordError(span, err)
+	}
+
+	present := make(map[string]bool, len(reported))
+	for _, vehicle := range reported {
+		if id, err := uuidfy.Vehicle(networkID, vehicle.ID); err == nil {
+			present[id] = true
+		}
+	}
+
+	stored, err := supabaseClient.FetchPresentVehicles(ctx, recordID)
+	if err != nil {
+		return tracing.RecordError(span, err)
+	}
+
+	// Vehicles sharing a last-seen time are marked in one update
+	gone := make(map[time.Time][]string)
+	for _, vehicle := range stored {
+		if present[vehicle.ID] {
+			continue
+		}
+		lastSeen := previousSuccess
+		if vehicle.FetchedAt != nil {
+			if fetchedAt, err := timestamps.Parse(*vehicle.FetchedAt); err == nil && fetchedAt.After(lastSeen) {
+				lastSeen = fetchedAt
+			}
+		}
+		gone[lastSeen] = append(gone[lastSeen], vehicle.ID)
+	}
+
+	marked := 0
+	for lastSeen, ids := range gone {
+		if err := supabaseClient.MarkVehiclesGone(ctx, ids, lastSeen); err != nil {
+			return tracing.RecordError(span, err)
+		}
+		// A vehicle that comes back has to be written even if it didn't
+		// move, so gone_at is cleared
+		changedetection.Vehicles.Forget(ids)
+		marked += len(ids)
+	}
+
+	span.SetAttributes(tracing.Count(marked))
+	if marked > 0 {
+		logger.InfoContext(ctx, "marked vehicles gone", logging.NetworkID(networkID), "count", marked)
+	}
+	return nil
+}
+
+// purgeGoneVehicles deletes vehicles that have been gone for longer than
+// the configured retention, every vehiclePurgeInterval until ctx is done
+func (p *Poller) purgeGoneVehicles(ctx context.Context) {
+	ticker := time.NewTicker(vehiclePurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		retention := p.config().VehicleRetention
+		if retention <= 0 {
+			continue
+		}
+		if _, err := supabaseClient.DeleteGoneVehicles(ctx, time.Now().Add(-retention)); err != nil {
+			logger.WarnContext(ctx, "failed to delete gone vehicles", logging.Err(err))
+		}
+	}
+}
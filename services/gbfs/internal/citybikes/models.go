@@ -0,0 +1,129 @@
+package citybikes
+
+import "encoding/json"
+
+// NetworksResponse is the body of /v2/networks
+type NetworksResponse struct {
+	Networks []Network `json:"networks"`
+}
+
+// NetworkResponse is the body of /v2/networks/{id}
+type NetworkResponse struct {
+	Network Network `json:"network"`
+}
+
+// Network is a bike share system. The catalogue only lists its metadata;
+// stations and vehicles are filled in when a single network is fetched.
+type Network struct {
+	ID       string    `json:"id"`
+	Name     string    `json:"name,omitempty"`
+	Href     string    `json:"href,omitempty"`
+	Company  Companies `json:"company,omitempty"`
+	Location *Location `json:"location,omitempty"`
+	GBFSHref string    `json:"gbfs_href,omitempty"`
+	Stations []Station `json:"stations,omitempty"`
+	Vehicles []Vehicle `json:"vehicles,omitempty"`
+
+	Raw json.RawMessage `json:"-"` // The network exactly as received
+}
+
+// Location is where a network operates
+type Location struct {
+	Latitude  float64 `json:"latitude"`
+	Longitude float64 `json:"longitude"`
+	City      string  `json:"city,omitempty"`
+	Country   string  `json:"country,omitempty"`
+}
+
+// Companies operating a network. Most networks list them, a few send a
+// single string.
+type Companies []string
+
+// Station is a docking station or a virtual station
+type Station struct {
+	ID         string        `json:"id"`
+	Name       string        `json:"name"`
+	Latitude   float64       `json:"latitude"`
+	Longitude  float64       `json:"longitude"`
+	Timestamp  Timestamp     `json:"timestamp,omitempty"`
+	FreeBikes  int           `json:"free_bikes"`
+	EmptySlots int           `json:"empty_slots"` // Null for virtual stations, decoded as 0
+	Extra      *StationExtra `json:"extra,omitempty"`
+
+	Raw json.RawMessage `json:"-"` // The station exactly as received
+}
+
+// StationExtra holds the extra station fields the mapper understands.
+// Everything else is kept in Unknown and written back by MarshalJSON.
+type StationExtra struct {
+	UID         string `json:"uid,omitempty"`
+	Address     string `json:"address,omitempty"`
+	Status      string `json:"status,omitempty"`
+	Slots       *int   `json:"slots,omitempty"`
+	Ebikes      *int   `json:"ebikes,omitempty"`
+	NormalBikes *int   `json:"normal_bikes,omitempty"`
+	Operational *Flag  `json:"operational,omitempty"`
+	Online      *Flag  `json:"online,omitempty"`
+	Renting     *Flag  `json:"renting,omitempty"`
+	Returning   *Flag  `json:"returning,omitempty"`
+	Virtual     *Flag  `json:"virtual,omitempty"`
+
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// Vehicle is a free-floating vehicle
+type Vehicle struct {
+	ID          string         `json:"id"`
+	Latitude    *float64       `json:"latitude,omitempty"`
+	Longitude   *float64       `json:"longitude,omitempty"`
+	Timestamp   Timestamp      `json:"timestamp,omitempty"`
+	Kind        string         `json:"kind,omitempty"`
+	VehicleType string         `json:"vehicle_type,omitempty"`
+	Battery     *float64       `json:"battery,omitempty"`
+	IsReserved  *bool          `json:"is_reserved,omitempty"`
+	IsDisabled  *bool          `json:"is_disabled,omitempty"`
+	RentalURIs  map[string]any `json:"rental_uris,omitempty"`
+	Extra       *VehicleExtra  `json:"extra,omitempty"`
+
+	Raw json.RawMessage `json:"-"` // The vehicle exactly as received
+}
+
+// VehicleExtra holds the extra vehicle fields the mapper understands.
+// Everything else is kept in Unknown and written back by MarshalJSON.
+type VehicleExtra struct {
+	VehicleType       string          `json:"vehicle_type,omitempty"`
+	Kind              string          `json:"kind,omitempty"`
+	BikeType          string          `json:"bike_type,omitempty"`
+	Ebike             json.RawMessage `json:"ebike,omitempty"` // Only its presence matters
+	Battery           *float64        `json:"battery,omitempty"`
+	BatteryLevel      *float64        `json:"battery_level,omitempty"`
+	BatteryPercentage *float64        `json:"battery_percentage,omitempty"`
+	IsReserved        *bool           `json:"is_reserved,omitempty"`
+	Reserved          *bool           `json:"reserved,omitempty"`
+	IsDisabled        *bool           `json:"is_disabled,omitempty"`
+	Disabled          *bool           `json:"disabled,omitempty"`
+	PricingPlanID     string          `json:"pricing_plan_id,omitempty"`
+	RentalURIs        map[string]any  `json:"rental_uris,omitempty"`
+
+	Unknown map[string]json.RawMessage `json:"-"`
+}
+
+// Timestamp is a timestamp as sent. It is usually RFC 3339; epoch seconds
+// or milliseconds sent as a number are kept as their digits.
+type Timestamp string
+
+// Flag is a boolean that some networks send as 0 or 1
+type Flag bool
+
+// Diff is the payload of a websocket "diff" event
+type Diff struct {
+	Message *DiffMessage `json:"message"`
+}
+
+// DiffMessage is a change to one station of a network
+type DiffMessage struct {
+	Action  string   `json:"action"`
+	N       float64  `json:"n"`
+	Network string   `json:"network"`
+	Station *Station `json:"station"`
+}
@@ -0,0 +1,306 @@
+package citybikes
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// FetchNetworks fetches the network catalogue from a discovery URL
+func FetchNetworks(ctx context.Context, client *http.Client, url string) ([]Network, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("HTTP request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("HTTP %d error", resp.StatusCode)
+	}
+
+	var data NetworksResponse
+	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
+		return nil, fmt.Errorf("failed to decode JSON: %v", err)
+	}
+	if data.Networks == nil {
+		return nil, fmt.Errorf("no 'networks' field in response")
+	}
+	return data.Networks, nil
+}
+
+// object is a JSON object whose known fields are taken out one at a time;
+// whatever is left over is unknown
+type object map[string]json.RawMessage
+
+// decodeObject returns nil for a JSON null
+func decodeObject(data []byte) (object, error) {
+	var fields object
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// take decodes a field into v and removes it from the object. A field that
+// is missing or of the wrong type leaves v alone, the way a failed type
+// assertion on a map did.
+func take[T any](fields object, key string, v *T) {
+	raw, ok := fields[key]
+	if !ok {
+		return
+	}
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return
+	}
+	*v = value
+	delete(fields, key)
+}
+
+// unknown returns what is left of the object, or nil if nothing is
+func (o object) unknown() map[string]json.RawMessage {
+	if len(o) == 0 {
+		return nil
+	}
+	return o
+}
+
+// marshalWith encodes known and adds the unknown fields next to it
+func marshalWith(known any, unknown map[string]json.RawMessage) ([]byte, error) {
+	data, err := json.Marshal(known)
+	if err != nil || len(unknown) == 0 {
+		return data, err
+	}
+	fields, err := decodeObject(data)
+	if err != nil {
+		return nil, err
+	}
+	merged := maps.Clone(unknown)
+	maps.Copy(merged, fields)
+	return json.Marshal(merged)
+}
+
+// UnmarshalJSON decodes a network. Stations and vehicles must decode, the
+// metadata is taken as far as it is valid.
+func (n *Network) UnmarshalJSON(data []byte) error {
+	fields, err := decodeObject(data)
+	if err != nil || fields == nil {
+		return err
+	}
+
+	*n = Network{Raw: slices.Clone(data)}
+	take(fields, "id", &n.ID)
+	take(fields, "name", &n.Name)
+	take(fields, "href", &n.Href)
+	take(fields, "company", &n.Company)
+	take(fields, "location", &n.Location)
+	take(fields, "gbfs_href", &n.GBFSHref)
+
+	if raw, ok := fields["stations"]; ok {
+		if err := json.Unmarshal(raw, &n.Stations); err != nil {
+			return fmt.Errorf("network %q: stations: %v", n.ID, err)
+		}
+	}
+	if raw, ok := fields["vehicles"]; ok {
+		if err := json.Unmarshal(raw, &n.Vehicles); err != nil {
+			return fmt.Errorf("network %q: vehicles: %v", n.ID, err)
+		}
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts a list of companies or a single one
+func (c *Companies) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+	case string:
+		*c = Companies{v}
+	case []any:
+		names := make(Companies, 0, len(v))
+		for _, company := range v {
+			if name, ok := company.(string); ok {
+				names = append(names, name)
+			}
+		}
+		*c = names
+	default:
+		return fmt.Errorf("cannot use %s as companies", data)
+	}
+	return nil
+}
+
+// UnmarshalJSON decodes a station. It only fails on something that isn't an
+// object, so one odd station can't reject the whole network; the mapper
+// decides what a usable station is.
+func (s *Station) UnmarshalJSON(data []byte) error {
+	fields, err := decodeObject(data)
+	if err != nil || fields == nil {
+		return err
+	}
+
+	*s = Station{Raw: slices.Clone(data)}
+	take(fields, "id", &s.ID)
+	take(fields, "name", &s.Name)
+	take(fields, "latitude", &s.Latitude)
+	take(fields, "longitude", &s.Longitude)
+	take(fields, "timestamp", &s.Timestamp)
+	take(fields, "free_bikes", &s.FreeBikes)
+	take(fields, "empty_slots", &s.EmptySlots)
+	take(fields, "extra", &s.Extra)
+	return nil
+}
+
+// UnmarshalJSON decodes the known extra fields and keeps the rest
+func (e *StationExtra) UnmarshalJSON(data []byte) error {
+	fields, err := decodeObject(data)
+	if err != nil || fields == nil {
+		return err
+	}
+
+	*e = StationExtra{}
+	take(fields, "uid", &e.UID)
+	take(fields, "address", &e.Address)
+	take(fields, "status", &e.Status)
+	take(fields, "slots", &e.Slots)
+	take(fields, "ebikes", &e.Ebikes)
+	take(fields, "normal_bikes", &e.NormalBikes)
+	take(fields, "operational", &e.Operational)
+	take(fields, "online", &e.Online)
+	take(fields, "renting", &e.Renting)
+	take(fields, "returning", &e.Returning)
+	take(fields, "virtual", &e.Virtual)
+	e.Unknown = fields.unknown()
+	return nil
+}
+
+// MarshalJSON writes the known extra fields and the unknown ones
+func (e StationExtra) MarshalJSON() ([]byte, error) {
+	type known StationExtra
+	return marshalWith(known(e), e.Unknown)
+}
+
+// UnmarshalJSON decodes a vehicle. Like stations, it only fails on
+// something that isn't an object.
+func (v *Vehicle) UnmarshalJSON(data []byte) error {
+	fields, err := decodeObject(data)
+	if err != nil || fields == nil {
+		return err
+	}
+
+	*v = Vehicle{Raw: slices.Clone(data)}
+	take(fields, "id", &v.ID)
+	take(fields, "latitude", &v.Latitude)
+	take(fields, "longitude", &v.Longitude)
+	take(fields, "timestamp", &v.Timestamp)
+	take(fields, "kind", &v.Kind)
+	take(fields, "vehicle_type", &v.VehicleType)
+	take(fields, "battery", &v.Battery)
+	take(fields, "is_reserved", &v.IsReserved)
+	take(fields, "is_disabled", &v.IsDisabled)
+	take(fields, "rental_uris", &v.RentalURIs)
+	take(fields, "extra", &v.Extra)
+	return nil
+}
+
+// Coordinates returns where the vehicle is; ok is false unless both
+// latitude and longitude were given
+func (v *Vehicle) Coordinates() (latitude, longitude float64, ok bool) {
+	if v.Latitude == nil || v.Longitude == nil {
+		return 0, 0, false
+	}
+	return *v.Latitude, *v.Longitude, true
+}
+
+// UnmarshalJSON decodes the known extra fields and keeps the rest
+func (e *VehicleExtra) UnmarshalJSON(data []byte) error {
+	fields, err := decodeObject(data)
+	if err != nil || fields == nil {
+		return err
+	}
+
+	*e = VehicleExtra{}
+	take(fields, "vehicle_type", &e.VehicleType)
+	take(fields, "kind", &e.Kind)
+	take(fields, "bike_type", &e.BikeType)
+	take(fields, "battery", &e.Battery)
+	take(fields, "battery_level", &e.BatteryLevel)
+	take(fields, "battery_percentage", &e.BatteryPercentage)
+	take(fields, "is_reserved", &e.IsReserved)
+	take(fields, "reserved", &e.Reserved)
+	take(fields, "is_disabled", &e.IsDisabled)
+	take(fields, "disabled", &e.Disabled)
+	take(fields, "pricing_plan_id", &e.PricingPlanID)
+	take(fields, "rental_uris", &e.RentalURIs)
+	if raw, ok := fields["ebike"]; ok {
+		e.Ebike = raw
+		delete(fields, "ebike")
+	}
+	e.Unknown = fields.unknown()
+	return nil
+}
+
+// MarshalJSON writes the known extra fields and the unknown ones
+func (e VehicleExtra) MarshalJSON() ([]byte, error) {
+	type known VehicleExtra
+	return marshalWith(known(e), e.Unknown)
+}
+
+// HasEbike reports whether the extra data mentions an ebike at all
+func (e *VehicleExtra) HasEbike() bool {
+	return e != nil && e.Ebike != nil
+}
+
+// UnmarshalJSON accepts a string or a number
+func (t *Timestamp) UnmarshalJSON(data []byte) error {
+	var value any
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+	case string:
+		*t = Timestamp(v)
+	case json.Number:
+		*t = Timestamp(v.String())
+	default:
+		return fmt.Errorf("cannot use %s as a timestamp", data)
+	}
+	return nil
+}
+
+// UnmarshalJSON accepts true/false as well as numbers, where anything but
+// 0 is true
+func (f *Flag) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case nil:
+	case bool:
+		*f = Flag(v)
+	case float64:
+		*f = v != 0
+	default:
+		return fmt.Errorf("cannot use %s as a flag", data)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package config
+
+import "time"
+
+// Defaults returns the configuration used when nothing overrides it
+func Defaults() Config {
+	return Config{
+		HTTP: HTTPConfig{
+			Port: 8080,
+		},
+		Supabase: SupabaseConfig{
+			Schema: "bikeshare",
+		},
+		Logging: LoggingConfig{
+			Level:            "info",
+			Levels:           map[string]string{},
+			Format:           "json",
+			SampleInitial:    10,
+			SampleThereafter: 100,
+			SampleTick:       time.Second,
+		},
+		Tracing: TracingConfig{
+			ServiceName: "gbfs-service",
+		},
+		Queue: QueueConfig{
+			MaxRecords:    100,
+			MaxAge:        10 * time.Second,
+			FlushInterval: 10 * time.Second,
+		},
+		WebSocket: WebSocketConfig{
+			Enabled:              true,
+			URL:                  "wss://ws.citybik.es/socket.io/?EIO=3&transport=websocket",
+			MaxReconnectAttempts: 10,
+			BaseReconnectDelay:   5 * time.Second,
+			MaxReconnectDelay:    2 * time.Minute,
+			PingInterval:         25 * time.Second,
+		},
+		Poller: PollerConfig{
+			Enabled:          true,
+			Networks:         []string{"capital-bikeshare"}, // Washington DC
+			RequestsPerHour:  240,                           // Safe default under 300/hour limit
+			MinInterval:      15 * time.Second,
+			MaxInterval:      30 * time.Minute,
+			ViewerBoost:      4,
+			ViewerTTL:        5 * time.Minute,
+			BreakerThreshold: 5,
+			BreakerCooldown:  10 * time.Minute,
+			Concurrency:      4,
+			ExpireVehicles:   true,
+			BaseURL:          "https://api.citybik.es/v2",
+			RequestTimeout:   30 * time.Second,
+			// HTTP headers to mimic browser request
+			UserAgent: "Mozilla/5.0 (X11; Linux x86_64; rv:145.0) Gecko/20100101 Firefox/145.0",
+			Origin:    "https://citybik.es",
+			Referer:   "https://citybik.es/",
+		},
+		RateLimit: RateLimitConfig{
+			// A full bucket is spent on top of the refill, so both share the limit
+			RequestsPerHour: maxRequestsPerHour - 5,
+			Burst:           5,
+			RetryAfter:      time.Minute,
+		},
+		ChangeDetection: ChangeDetectionConfig{
+			Enabled:   true,
+			Heartbeat: 15 * time.Minute,
+		},
+		Catalogue: CatalogueConfig{
+			RefreshInterval: 24 * time.Hour,
+		},
+		Stale: StaleConfig{
+			RefreshInterval: 15 * time.Minute,
+			Threshold:       30 * time.Minute,
+			MaxNetworks:     10, // 40 requests/hour next to the poller's 240
+		},
+		Validation: ValidationConfig{
+			Enabled:      true,
+			MaxDistance:  150,
+			MaxClockSkew: 5 * time.Minute,
+		},
+		History: HistoryConfig{
+			Enabled:            false,
+			RawRetention:       7 * 24 * time.Hour,
+			Bucket:             15 * time.Minute,
+			DownsampleInterval: time.Hour,
+		},
+		Trips: TripsConfig{
+			Enabled:       false,
+			MinDuration:   time.Minute,
+			MaxDuration:   45 * time.Minute,
+			Speed:         12,
+			MaxDelta:      3,
+			FlushInterval: 5 * time.Minute,
+		},
+		Reload: ReloadConfig{
+			WatchInterval: 30 * time.Second,
+		},
+	}
+}
+
+// maxRequestsPerHour is the citybik.es rate limit
+const maxRequestsPerHour = 300
+
+// maxPollConcurrency caps parallel fetches; more wouldn't fit the budget
+const maxPollConcurrency = 32
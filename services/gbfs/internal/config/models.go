@@ -0,0 +1,203 @@
+package config
+
+import "time"
+
+// Config is the complete service configuration. It is assembled at startup
+// from defaults, an optional YAML file, environment variables and
+// command-line flags (in that order of precedence) and then handed to each
+// component. Components with a lifecycle of their own, like the poller and
+// the websocket consumer, take their section in New. The stages the mappers
+// and HTTP clients call as plain functions (rate limiting, change detection,
+// mapping rules, validation, trip inference and the Supabase client) keep
+// theirs process-wide instead, set by Configure at startup and swapped on
+// reload, so a reload reaches every caller at once.
+type Config struct {
+	HTTP      HTTPConfig      `yaml:"http"`
+	Supabase  SupabaseConfig  `yaml:"supabase"`
+	Logging   LoggingConfig   `yaml:"logging"`
+	Tracing   TracingConfig   `yaml:"tracing"`
+	Admin     AdminConfig     `yaml:"admin"`
+	Queue     QueueConfig     `yaml:"queue"`
+	WebSocket WebSocketConfig `yaml:"websocket"`
+	Poller    PollerConfig    `yaml:"poller"`
+	RateLimit RateLimitConfig `yaml:"rate_limit"`
+
+	ChangeDetection ChangeDetectionConfig `yaml:"change_detection"`
+	Catalogue       CatalogueConfig       `yaml:"catalogue"`
+	Stale           StaleConfig           `yaml:"stale"`
+	Mapping         MappingConfig         `yaml:"mapping"`
+	Validation      ValidationConfig      `yaml:"validation"`
+	History         HistoryConfig         `yaml:"history"`
+	Trips           TripsConfig           `yaml:"trips"`
+	Reload          ReloadConfig          `yaml:"reload"`
+
+	// File the configuration was loaded from, if any
+	path string
+}
+
+// ReloadConfig controls hot reloading. SIGHUP always triggers a reload; the
+// config file is additionally checked for changes every WatchInterval.
+type ReloadConfig struct {
+	WatchInterval time.Duration `yaml:"watch_interval"` // 0 disables file watching
+}
+
+type HTTPConfig struct {
+	Port int `yaml:"port"` // Health check and admin API port
+}
+
+type SupabaseConfig struct {
+	URL    string `yaml:"url"`
+	Key    string `yaml:"key"`
+	Schema string `yaml:"schema"`
+}
+
+type LoggingConfig struct {
+	Level  string            `yaml:"level"`  // Default level (debug, info, warn, error)
+	Levels map[string]string `yaml:"levels"` // Per-component overrides
+	Format string            `yaml:"format"` // "json" or "text"
+
+	// Debug records are sampled per component and message: the first
+	// Initial records in each Tick window are kept, then every Thereafter-th
+	SampleInitial    int           `yaml:"sample_initial"`
+	SampleThereafter int           `yaml:"sample_thereafter"`
+	SampleTick       time.Duration `yaml:"sample_tick"`
+}
+
+type TracingConfig struct {
+	// Exporter endpoint, headers and sampler come from the standard
+	// OTEL_EXPORTER_OTLP_* and OTEL_TRACES_* variables read by the SDK
+	Enabled     bool   `yaml:"enabled"`
+	ServiceName string `yaml:"service_name"`
+}
+
+type AdminConfig struct {
+	Token string `yaml:"token"` // Bearer token; the admin API is disabled when empty
+}
+
+type QueueConfig struct {
+	MaxRecords    int           `yaml:"max_records"`    // Flush once this many records are queued
+	MaxAge        time.Duration `yaml:"max_age"`        // Flush once the oldest batch is this old
+	FlushInterval time.Duration `yaml:"flush_interval"` // How often the age check runs
+}
+
+type WebSocketConfig struct {
+	Enabled              bool          `yaml:"enabled"`
+	URL                  string        `yaml:"url"`
+	MaxReconnectAttempts int           `yaml:"max_reconnect_attempts"`
+	BaseReconnectDelay   time.Duration `yaml:"base_reconnect_delay"`
+	MaxReconnectDelay    time.Duration `yaml:"max_reconnect_delay"`
+	PingInterval         time.Duration `yaml:"ping_interval"`
+}
+
+type PollerConfig struct {
+	Enabled bool `yaml:"enabled"`
+
+	// Networks to poll (network IDs from citybik.es), e.g. capital-bikeshare
+	Networks []string `yaml:"networks"`
+
+	// Share of the upstream budget used for scheduled polls; the rest is
+	// left for bootstrap and manual polls
+	RequestsPerHour int `yaml:"requests_per_hour"`
+
+	// Bounds on each network's polling interval. The budget is shared out
+	// in proportion to how often a network's stations and vehicles change.
+	MinInterval time.Duration `yaml:"min_interval"`
+	MaxInterval time.Duration `yaml:"max_interval"`
+
+	// The circuit breaker of a network opens after BreakerThreshold
+	// consecutive failed fetches; one probe is sent every BreakerCooldown
+	// until a fetch succeeds
+	BreakerThreshold int           `yaml:"breaker_threshold"`
+	BreakerCooldown  time.Duration `yaml:"breaker_cooldown"`
+
+	// Networks users are viewing get ViewerBoost times their usual share of
+	// the budget until ViewerTTL passes without another view
+	ViewerBoost float64       `yaml:"viewer_boost"`
+	ViewerTTL   time.Duration `yaml:"viewer_ttl"`
+
+	// Networks fetched in parallel; changing it requires a restart
+	Concurrency int `yaml:"concurrency"`
+
+	// Stored vehicles a network stops reporting are marked gone; gone
+	// vehicles are deleted after VehicleRetention (0 keeps them)
+	ExpireVehicles   bool          `yaml:"expire_vehicles"`
+	VehicleRetention time.Duration `yaml:"vehicle_retention"`
+
+	// HTTP client settings
+	BaseURL        string        `yaml:"base_url"`
+	RequestTimeout time.Duration `yaml:"request_timeout"`
+	UserAgent      string        `yaml:"user_agent"`
+	Origin         string        `yaml:"origin"`
+	Referer        string        `yaml:"referer"`
+}
+
+// ChangeDetectionConfig controls which mapped records are written. Records
+// whose availability, status and location are unchanged are skipped, but
+// every record is still rewritten once per Heartbeat to refresh fetched_at.
+type ChangeDetectionConfig struct {
+	Enabled   bool          `yaml:"enabled"`
+	Heartbeat time.Duration `yaml:"heartbeat"`
+}
+
+// CatalogueConfig controls the periodic re-sync of the network catalogue.
+// Networks that disappear from citybik.es are marked inactive.
+type CatalogueConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // 0 only syncs at startup
+}
+
+// StaleConfig controls the sweep for stations whose fetched_at fell behind.
+// Every RefreshInterval up to MaxNetworks stale networks are re-fetched
+// through the poller, and stations missing upstream are marked removed.
+type StaleConfig struct {
+	RefreshInterval time.Duration `yaml:"refresh_interval"` // 0 disables the sweep
+	Threshold       time.Duration `yaml:"threshold"`        // fetched_at older than this is stale
+	MaxNetworks     int           `yaml:"max_networks"`     // Networks re-fetched per sweep
+}
+
+// MappingConfig points at the per-network mapping overrides. The rules file
+// is re-read on every reload, including SIGHUP.
+type MappingConfig struct {
+	RulesFile string `yaml:"rules_file"` // Empty maps every network the same way
+}
+
+// ValidationConfig controls the checks mapped records pass before they are
+// written. Every failed check has a reason code whose default action, reject
+// or flag, can be overridden in Actions.
+type ValidationConfig struct {
+	Enabled      bool              `yaml:"enabled"`
+	MaxDistance  float64           `yaml:"max_distance_km"` // Furthest a record may be from its network's location
+	MaxClockSkew time.Duration     `yaml:"max_clock_skew"`  // How far timestamps may be ahead of our clock
+	Actions      map[string]string `yaml:"actions"`         // Reason code -> "reject" or "flag"
+}
+
+// HistoryConfig controls the station status history. A snapshot is appended
+// whenever a station's availability changes; every DownsampleInterval,
+// snapshots older than RawRetention are folded into Bucket-wide aggregates.
+type HistoryConfig struct {
+	Enabled            bool          `yaml:"enabled"`
+	RawRetention       time.Duration `yaml:"raw_retention"`
+	Bucket             time.Duration `yaml:"bucket"`
+	AggregateRetention time.Duration `yaml:"aggregate_retention"` // 0 keeps aggregates forever
+	DownsampleInterval time.Duration `yaml:"downsample_interval"`
+}
+
+// TripsConfig controls trip inference from the websocket diffs. A drop in a
+// station's bikes is a departure, a rise an arrival; an arrival is paired
+// with the pending departure whose elapsed time best fits riding the
+// distance at Speed.
+type TripsConfig struct {
+	Enabled       bool          `yaml:"enabled"`
+	MinDuration   time.Duration `yaml:"min_duration"`   // Shorter pairs are re-docks, not trips
+	MaxDuration   time.Duration `yaml:"max_duration"`   // Departures pending longer are dropped
+	Speed         float64       `yaml:"speed_kmh"`      // Typical riding speed
+	MaxDelta      int           `yaml:"max_delta"`      // Larger changes at once are rebalancing
+	FlushInterval time.Duration `yaml:"flush_interval"` // How often hourly flows are written
+}
+
+// RateLimitConfig is the budget every outgoing HTTP request to an upstream
+// host is drawn from. Each host gets its own token bucket.
+type RateLimitConfig struct {
+	RequestsPerHour int           `yaml:"requests_per_hour"` // Refill rate; with Burst at most 300, the citybik.es limit
+	Burst           int           `yaml:"burst"`             // Requests that may be made back to back
+	RetryAfter      time.Duration `yaml:"retry_after"`       // Back-off after a 429 without a Retry-After header
+}
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"context"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+// Watch re-runs Load with the original arguments whenever the process
+// receives SIGHUP or the config file's modification time changes, and hands
+// every configuration that validates to apply, along with the configuration
+// applied before it. Invalid configurations are passed to reject and the
+// running configuration stays in effect.
+func Watch(ctx context.Context, args []string, current *Config, apply func(current, next *Config), reject func(error)) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var tick <-chan time.Time
+	if current.path != "" && current.Reload.WatchInterval > 0 {
+		ticker := time.NewTicker(current.Reload.WatchInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	lastMod := modTime(current.path)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		case <-tick:
+			mod := modTime(current.path)
+			if mod.Equal(lastMod) {
+				continue
+			}
+			lastMod = mod
+		}
+
+		next, err := Load(args)
+		if err != nil {
+			reject(err)
+			continue
+		}
+		apply(current, next)
+		current = next
+	}
+}
+
+func modTime(path string) time.Time {
+	if path == "" {
+		return time.Time{}
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return info.ModTime()
+}
+
+// RestartRequired lists the config sections that differ between c and next
+// in ways that only take effect after a restart. The poller's network list,
+// scheduling and vehicle expiry settings, the upstream rate limit, change
+// detection, the mapping rules and validation are applied live and not
+// reported.
+func (c *Config) RestartRequired(next *Config) []string {
+	var sections []string
+	compare := func(name string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			sections = append(sections, name)
+		}
+	}
+
+	compare("http", c.HTTP, next.HTTP)
+	compare("supabase", c.Supabase, next.Supabase)
+	compare("logging", c.Logging, next.Logging)
+	compare("tracing", c.Tracing, next.Tracing)
+	compare("admin", c.Admin, next.Admin)
+	compare("queue", c.Queue, next.Queue)
+	compare("websocket", c.WebSocket, next.WebSocket)
+	compare("catalogue", c.Catalogue, next.Catalogue)
+	compare("stale", c.Stale, next.Stale)
+	compare("history", c.History, next.History)
+	compare("trips", c.Trips, next.Trips)
+	compare("reload", c.Reload, next.Reload)
+
+	current, updated := c.Poller, next.Poller
+	current.Networks, updated.Networks = nil, nil
+	current.RequestsPerHour, updated.RequestsPerHour = 0, 0
+	current.MinInterval, updated.MinInterval = 0, 0
+	current.MaxInterval, updated.MaxInterval = 0, 0
+	current.ViewerBoost, updated.ViewerBoost = 0, 0
+	current.ViewerTTL, updated.ViewerTTL = 0, 0
+	current.BreakerThreshold, updated.BreakerThreshold = 0, 0
+	current.BreakerCooldown, updated.BreakerCooldown = 0, 0
+	current.ExpireVehicles, updated.ExpireVehicles = false, false
+	current.VehicleRetention, updated.VehicleRetention = 0, 0
+	compare("poller", current, updated)
+
+	return sections
+}
@@ -0,0 +1,429 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Load builds the configuration from defaults, the YAML file given by
+// -config (or CONFIG_FILE), environment variables and flags, then validates
+// it. args are the command-line arguments without the program name.
+func Load(args []string) (*Config, error) {
+	cfg := Defaults()
+
+	fs := flag.NewFlagSet("gbfs-service", flag.ContinueOnError)
+	flags := registerFlags(fs)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	path := os.Getenv("CONFIG_FILE")
+	if *flags.configFile != "" {
+		path = *flags.configFile
+	}
+	if path != "" {
+		if err := loadFile(path, &cfg); err != nil {
+			return nil, err
+		}
+		cfg.path = path
+	}
+
+	if err := applyEnv(&cfg); err != nil {
+		return nil, err
+	}
+	flags.apply(fs, &cfg)
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// loadFile overlays a YAML (or JSON) file onto cfg; unknown keys are errors
+// so typos don't silently fall back to defaults
+func loadFile(path string, cfg *Config) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file: %v", err)
+	}
+
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return fmt.Errorf("failed to parse config file %s: %v", path, err)
+	}
+	return nil
+}
+
+// envReader accumulates parse errors so every bad variable is reported at once
+type envReader struct {
+	errs []error
+}
+
+func (r *envReader) string(name string, dst *string) {
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		*dst = v
+	}
+}
+
+func (r *envReader) int(name string, dst *int) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(v))
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: %q is not an integer", name, v))
+		return
+	}
+	*dst = n
+}
+
+func (r *envReader) bool(name string, dst *bool) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	b, err := strconv.ParseBool(strings.TrimSpace(v))
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: %q is not a boolean", name, v))
+		return
+	}
+	*dst = b
+}
+
+func (r *envReader) duration(name string, dst *time.Duration) {
+	v := os.Getenv(name)
+	if v == "" {
+		return
+	}
+	d, err := time.ParseDuration(strings.TrimSpace(v))
+	if err != nil {
+		r.errs = append(r.errs, fmt.Errorf("%s: %q is not a duration (e.g. 10s, 5m)", name, v))
+		return
+	}
+	*dst = d
+}
+
+func (r *envReader) list(name string, dst *[]string) {
+	if v := os.Getenv(name); v != "" {
+		*dst = splitList(v)
+	}
+}
+
+func applyEnv(cfg *Config) error {
+	r := &envReader{}
+
+	r.int("PORT", &cfg.HTTP.Port)
+
+	r.string("SUPABASE_URL", &cfg.Supabase.URL)
+	r.string("SUPABASE_KEY", &cfg.Supabase.Key)
+	r.string("SUPABASE_SCHEMA", &cfg.Supabase.Schema)
+
+	// VERBOSE=true keeps working as a shortcut for debug everywhere
+	var verbose bool
+	r.bool("VERBOSE", &verbose)
+	if verbose {
+		cfg.Logging.Level = "debug"
+	}
+	r.string("LOG_LEVEL", &cfg.Logging.Level)
+	if v := os.Getenv("LOG_LEVELS"); v != "" {
+		levels, err := parseLevels(v)
+		if err != nil {
+			r.errs = append(r.errs, fmt.Errorf("LOG_LEVELS: %v", err))
+		}
+		for component, level := range levels {
+			cfg.Logging.Levels[component] = level
+		}
+	}
+	r.string("LOG_FORMAT", &cfg.Logging.Format)
+
+	if os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" || os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") != "" {
+		cfg.Tracing.Enabled = true
+	}
+	r.string("OTEL_SERVICE_NAME", &cfg.Tracing.ServiceName)
+
+	r.string("ADMIN_TOKEN", &cfg.Admin.Token)
+
+	r.int("QUEUE_MAX_RECORDS", &cfg.Queue.MaxRecords)
+	r.duration("QUEUE_MAX_AGE", &cfg.Queue.MaxAge)
+	r.duration("QUEUE_FLUSH_INTERVAL", &cfg.Queue.FlushInterval)
+
+	r.bool("ENABLE_WEBSOCKET", &cfg.WebSocket.Enabled)
+	r.string("CITYBIKES_WS_URL", &cfg.WebSocket.URL)
+
+	r.bool("ENABLE_POLLER", &cfg.Poller.Enabled)
+	r.list("CITYBIKES_POLL_NETWORKS", &cfg.Poller.Networks)
+	r.int("CITYBIKES_REQUESTS_PER_HOUR", &cfg.Poller.RequestsPerHour)
+	r.int("CITYBIKES_POLL_CONCURRENCY", &cfg.Poller.Concurrency)
+	r.bool("EXPIRE_VEHICLES", &cfg.Poller.ExpireVehicles)
+	r.duration("VEHICLE_RETENTION", &cfg.Poller.VehicleRetention)
+
+	r.int("CITYBIKES_RATE_LIMIT_PER_HOUR", &cfg.RateLimit.RequestsPerHour)
+	r.int("CITYBIKES_RATE_LIMIT_BURST", &cfg.RateLimit.Burst)
+
+	r.bool("ENABLE_CHANGE_DETECTION", &cfg.ChangeDetection.Enabled)
+	r.duration("CHANGE_DETECTION_HEARTBEAT", &cfg.ChangeDetection.Heartbeat)
+
+	r.duration("CATALOGUE_REFRESH_INTERVAL", &cfg.Catalogue.RefreshInterval)
+
+	r.duration("STALE_REFRESH_INTERVAL", &cfg.Stale.RefreshInterval)
+	r.duration("STALE_THRESHOLD", &cfg.Stale.Threshold)
+	r.int("STALE_MAX_NETWORKS", &cfg.Stale.MaxNetworks)
+
+	r.string("MAPPING_RULES_FILE", &cfg.Mapping.RulesFile)
+
+	r.bool("ENABLE_VALIDATION", &cfg.Validation.Enabled)
+	r.duration("VALIDATION_MAX_CLOCK_SKEW", &cfg.Validation.MaxClockSkew)
+
+	r.bool("ENABLE_STATUS_HISTORY", &cfg.History.Enabled)
+	r.duration("HISTORY_RAW_RETENTION", &cfg.History.RawRetention)
+	r.duration("HISTORY_BUCKET", &cfg.History.Bucket)
+	r.duration("HISTORY_AGGREGATE_RETENTION", &cfg.History.AggregateRetention)
+
+	r.bool("ENABLE_TRIP_INFERENCE", &cfg.Trips.Enabled)
+
+	r.duration("CONFIG_WATCH_INTERVAL", &cfg.Reload.WatchInterval)
+
+	if len(r.errs) > 0 {
+		return fmt.Errorf("invalid environment: %w", errors.Join(r.errs...))
+	}
+	return nil
+}
+
+// cliFlags holds flag values; only flags that were actually passed are applied
+type cliFlags struct {
+	configFile         *string
+	port               *int
+	logLevel           *string
+	logFormat          *string
+	enableWebSocket    *bool
+	enablePoller       *bool
+	pollNetworks       *string
+	requestsPerHour    *int
+	queueMaxRecords    *int
+	queueMaxAge        *time.Duration
+	queueFlushInterval *time.Duration
+}
+
+func registerFlags(fs *flag.FlagSet) *cliFlags {
+	return &cliFlags{
+		configFile:         fs.String("config", "", "path to a YAML config file (default $CONFIG_FILE)"),
+		port:               fs.Int("port", 0, "HTTP port for health checks and the admin API"),
+		logLevel:           fs.String("log-level", "", "default log level (debug, info, warn, error)"),
+		logFormat:          fs.String("log-format", "", "log format (json or text)"),
+		enableWebSocket:    fs.Bool("enable-websocket", true, "consume the citybik.es websocket"),
+		enablePoller:       fs.Bool("enable-poller", true, "poll the citybik.es REST API"),
+		pollNetworks:       fs.String("poll-networks", "", "comma-separated citybik.es network IDs to poll"),
+		requestsPerHour:    fs.Int("requests-per-hour", 0, "citybik.es request budget per hour"),
+		queueMaxRecords:    fs.Int("queue-max-records", 0, "flush the station queue at this many records"),
+		queueMaxAge:        fs.Duration("queue-max-age", 0, "flush the station queue once it is this old"),
+		queueFlushInterval: fs.Duration("queue-flush-interval", 0, "how often the station queue age is checked"),
+	}
+}
+
+func (f *cliFlags) apply(fs *flag.FlagSet, cfg *Config) {
+	fs.Visit(func(fl *flag.Flag) {
+		switch fl.Name {
+		case "port":
+			cfg.HTTP.Port = *f.port
+		case "log-level":
+			cfg.Logging.Level = *f.logLevel
+		case "log-format":
+			cfg.Logging.Format = *f.logFormat
+		case "enable-websocket":
+			cfg.WebSocket.Enabled = *f.enableWebSocket
+		case "enable-poller":
+			cfg.Poller.Enabled = *f.enablePoller
+		case "poll-networks":
+			cfg.Poller.Networks = splitList(*f.pollNetworks)
+		case "requests-per-hour":
+			cfg.Poller.RequestsPerHour = *f.requestsPerHour
+		case "queue-max-records":
+			cfg.Queue.MaxRecords = *f.queueMaxRecords
+		case "queue-max-age":
+			cfg.Queue.MaxAge = *f.queueMaxAge
+		case "queue-flush-interval":
+			cfg.Queue.FlushInterval = *f.queueFlushInterval
+		}
+	})
+}
+
+// Validate checks every setting and reports all problems together
+func (c *Config) Validate() error {
+	v := &validator{}
+
+	v.check(c.HTTP.Port > 0 && c.HTTP.Port <= 65535, "http.port", "must be between 1 and 65535 (got %d)", c.HTTP.Port)
+
+	v.check(c.Supabase.URL != "", "supabase.url", "is required (SUPABASE_URL)")
+	if c.Supabase.URL != "" {
+		v.url("supabase.url", c.Supabase.URL, "http", "https")
+	}
+	v.check(c.Supabase.Key != "", "supabase.key", "is required (SUPABASE_KEY)")
+	v.check(c.Supabase.Schema != "", "supabase.schema", "must not be empty")
+
+	v.level("logging.level", c.Logging.Level)
+	for component, level := range c.Logging.Levels {
+		v.level("logging.levels."+component, level)
+	}
+	v.check(c.Logging.Format == "json" || c.Logging.Format == "text", "logging.format", "must be json or text (got %q)", c.Logging.Format)
+	v.check(c.Logging.SampleInitial >= 0, "logging.sample_initial", "must not be negative")
+	v.check(c.Logging.SampleThereafter >= 0, "logging.sample_thereafter", "must not be negative")
+	v.check(c.Logging.SampleTick > 0, "logging.sample_tick", "must be positive")
+
+	if c.Tracing.Enabled {
+		v.check(c.Tracing.ServiceName != "", "tracing.service_name", "must not be empty")
+	}
+
+	v.check(c.Queue.MaxRecords > 0, "queue.max_records", "must be positive (got %d)", c.Queue.MaxRecords)
+	v.check(c.Queue.MaxAge > 0, "queue.max_age", "must be positive")
+	v.check(c.Queue.FlushInterval > 0, "queue.flush_interval", "must be positive")
+
+	if c.WebSocket.Enabled {
+		v.url("websocket.url", c.WebSocket.URL, "ws", "wss")
+		v.check(c.WebSocket.MaxReconnectAttempts > 0, "websocket.max_reconnect_attempts", "must be positive")
+		v.check(c.WebSocket.BaseReconnectDelay > 0, "websocket.base_reconnect_delay", "must be positive")
+		v.check(c.WebSocket.MaxReconnectDelay >= c.WebSocket.BaseReconnectDelay, "websocket.max_reconnect_delay", "must not be below base_reconnect_delay")
+		v.check(c.WebSocket.PingInterval > 0, "websocket.ping_interval", "must be positive")
+	}
+
+	if c.Poller.Enabled {
+		v.check(len(c.Poller.Networks) > 0, "poller.networks", "must list at least one network when the poller is enabled")
+		seen := make(map[string]bool, len(c.Poller.Networks))
+		for _, id := range c.Poller.Networks {
+			v.check(id != "", "poller.networks", "must not contain empty IDs")
+			v.check(!seen[id], "poller.networks", "lists %q more than once", id)
+			seen[id] = true
+		}
+		v.check(c.Poller.RequestsPerHour > 0 && c.Poller.RequestsPerHour <= c.RateLimit.RequestsPerHour,
+			"poller.requests_per_hour", "must be between 1 and rate_limit.requests_per_hour (%d) (got %d)", c.RateLimit.RequestsPerHour, c.Poller.RequestsPerHour)
+		v.check(c.Poller.MinInterval > 0, "poller.min_interval", "must be positive")
+		v.check(c.Poller.MaxInterval >= c.Poller.MinInterval, "poller.max_interval", "must not be below min_interval")
+		if c.Poller.MaxInterval > 0 && c.Poller.RequestsPerHour > 0 {
+			v.check(float64(len(c.Poller.Networks))*time.Hour.Seconds()/c.Poller.MaxInterval.Seconds() <= float64(c.Poller.RequestsPerHour),
+				"poller.max_interval", "is too short to poll all %d networks within requests_per_hour", len(c.Poller.Networks))
+		}
+		v.check(c.Poller.ViewerBoost >= 1, "poller.viewer_boost", "must be at least 1 (got %g)", c.Poller.ViewerBoost)
+		v.check(c.Poller.ViewerTTL > 0, "poller.viewer_ttl", "must be positive")
+		v.check(c.Poller.BreakerThreshold > 0, "poller.breaker_threshold", "must be positive (got %d)", c.Poller.BreakerThreshold)
+		v.check(c.Poller.BreakerCooldown > 0, "poller.breaker_cooldown", "must be positive")
+		v.check(c.Poller.Concurrency > 0 && c.Poller.Concurrency <= maxPollConcurrency,
+			"poller.concurrency", "must be between 1 and %d (got %d)", maxPollConcurrency, c.Poller.Concurrency)
+		v.check(c.Poller.VehicleRetention >= 0, "poller.vehicle_retention", "must not be negative")
+		v.check(c.Poller.RequestTimeout > 0, "poller.request_timeout", "must be positive")
+		v.url("poller.base_url", c.Poller.BaseURL, "http", "https")
+	}
+
+	v.check(c.RateLimit.RequestsPerHour > 0 && c.RateLimit.RequestsPerHour <= maxRequestsPerHour,
+		"rate_limit.requests_per_hour", "must be between 1 and %d (got %d)", maxRequestsPerHour, c.RateLimit.RequestsPerHour)
+	v.check(c.RateLimit.Burst > 0, "rate_limit.burst", "must be positive (got %d)", c.RateLimit.Burst)
+	v.check(c.RateLimit.RequestsPerHour+c.RateLimit.Burst <= maxRequestsPerHour,
+		"rate_limit.burst", "plus requests_per_hour must not exceed %d, a full bucket is spent on top of the refill (got %d + %d)",
+		maxRequestsPerHour, c.RateLimit.RequestsPerHour, c.RateLimit.Burst)
+	v.check(c.RateLimit.RetryAfter > 0, "rate_limit.retry_after", "must be positive")
+
+	v.check(c.ChangeDetection.Heartbeat > 0, "change_detection.heartbeat", "must be positive")
+
+	v.check(c.Catalogue.RefreshInterval >= 0, "catalogue.refresh_interval", "must not be negative")
+
+	v.check(c.Stale.RefreshInterval >= 0, "stale.refresh_interval", "must not be negative")
+	// A record rewritten every heartbeat must never look stale
+	v.check(c.Stale.Threshold > c.ChangeDetection.Heartbeat, "stale.threshold", "must be longer than change_detection.heartbeat")
+	v.check(c.Stale.MaxNetworks > 0, "stale.max_networks", "must be positive (got %d)", c.Stale.MaxNetworks)
+	if c.Stale.RefreshInterval > 0 && c.Poller.Enabled {
+		sweeps := float64(c.Stale.MaxNetworks) * time.Hour.Seconds() / c.Stale.RefreshInterval.Seconds()
+		v.check(float64(c.Poller.RequestsPerHour)+sweeps <= float64(c.RateLimit.RequestsPerHour),
+			"stale.max_networks", "needs %.0f requests/hour, more than rate_limit.requests_per_hour leaves next to poller.requests_per_hour", sweeps)
+	}
+
+	v.check(c.Validation.MaxDistance > 0, "validation.max_distance_km", "must be positive (got %g)", c.Validation.MaxDistance)
+	v.check(c.Validation.MaxClockSkew >= 0, "validation.max_clock_skew", "must not be negative")
+	for reason, action := range c.Validation.Actions {
+		v.check(action == "reject" || action == "flag", "validation.actions", "%s must be reject or flag (got %q)", reason, action)
+	}
+
+	if c.History.Enabled {
+		v.check(c.History.RawRetention > 0, "history.raw_retention", "must be positive")
+		v.check(c.History.Bucket >= time.Minute && c.History.Bucket <= 24*time.Hour, "history.bucket", "must be between 1m and 24h (got %s)", c.History.Bucket)
+		v.check(c.History.Bucket <= c.History.RawRetention, "history.bucket", "must not be longer than raw_retention")
+		v.check(c.History.AggregateRetention == 0 || c.History.AggregateRetention > c.History.RawRetention,
+			"history.aggregate_retention", "must be 0 (keep forever) or longer than raw_retention")
+		v.check(c.History.DownsampleInterval > 0, "history.downsample_interval", "must be positive")
+	}
+
+	if c.Trips.Enabled {
+		v.check(c.Trips.MinDuration > 0, "trips.min_duration", "must be positive")
+		v.check(c.Trips.MaxDuration > c.Trips.MinDuration, "trips.max_duration", "must be longer than min_duration")
+		v.check(c.Trips.Speed > 0, "trips.speed_kmh", "must be positive")
+		v.check(c.Trips.MaxDelta >= 1, "trips.max_delta", "must be at least 1")
+		v.check(c.Trips.FlushInterval > 0, "trips.flush_interval", "must be positive")
+	}
+
+	v.check(c.Reload.WatchInterval >= 0, "reload.watch_interval", "must not be negative")
+
+	return v.err()
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) check(ok bool, field, format string, args ...any) {
+	if !ok {
+		v.problems = append(v.problems, field+": "+fmt.Sprintf(format, args...))
+	}
+}
+
+func (v *validator) level(field, level string) {
+	var l slog.Level
+	v.check(l.UnmarshalText([]byte(level)) == nil, field, "unknown log level %q (use debug, info, warn or error)", level)
+}
+
+func (v *validator) url(field, raw string, schemes ...string) {
+	u, err := url.Parse(raw)
+	v.check(err == nil && u.Host != "" && slices.Contains(schemes, u.Scheme),
+		field, "must be a %s URL (got %q)", strings.Join(schemes, "/"), raw)
+}
+
+func (v *validator) err() error {
+	if len(v.problems) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid configuration:\n  - %s", strings.Join(v.problems, "\n  - "))
+}
+
+// splitList parses comma-separated values, trimming whitespace and blanks
+func splitList(s string) []string {
+	var out []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			out = append(out, item)
+		}
+	}
+	return out
+}
+
+// parseLevels parses "component=level,component=level"
+func parseLevels(s string) (map[string]string, error) {
+	levels := map[string]string{}
+	for _, pair := range splitList(s) {
+		component, level, found := strings.Cut(pair, "=")
+		if !found || strings.TrimSpace(component) == "" {
+			return levels, fmt.Errorf("expected component=level, got %q", pair)
+		}
+		levels[strings.TrimSpace(component)] = strings.TrimSpace(level)
+	}
+	return levels, nil
+}
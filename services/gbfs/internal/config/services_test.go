@@ -0,0 +1,277 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// environment are the variables the tests set; each test starts with all of
+// them empty, which applyEnv treats as unset
+var environment = []string{
+	"CONFIG_FILE", "PORT", "SUPABASE_URL", "SUPABASE_KEY", "SUPABASE_SCHEMA",
+	"VERBOSE", "LOG_LEVEL", "LOG_LEVELS", "LOG_FORMAT",
+	"OTEL_EXPORTER_OTLP_ENDPOINT", "OTEL_EXPORTER_OTLP_TRACES_ENDPOINT",
+	"ENABLE_POLLER", "CITYBIKES_POLL_NETWORKS", "CITYBIKES_REQUESTS_PER_HOUR",
+	"QUEUE_MAX_RECORDS", "QUEUE_MAX_AGE",
+}
+
+// writeFile writes a config file and returns its path
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	const file = `
+supabase:
+  url: https://file.supabase.co
+  key: file-key
+http:
+  port: 9000
+logging:
+  level: warn
+  levels:
+    poller: debug
+    websocket: error
+poller:
+  enabled: false
+queue:
+  max_records: 50
+`
+
+	tests := []struct {
+		name  string
+		env   map[string]string
+		args  []string
+		check func(t *testing.T, cfg *Config)
+	}{
+		{
+			name: "file over defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Port != 9000 || cfg.Supabase.URL != "https://file.supabase.co" || cfg.Queue.MaxRecords != 50 {
+					t.Errorf("port %d, supabase %s, max records %d, want the file's", cfg.HTTP.Port, cfg.Supabase.URL, cfg.Queue.MaxRecords)
+				}
+				if cfg.Supabase.Schema != "bikeshare" || cfg.Queue.MaxAge != Defaults().Queue.MaxAge {
+					t.Errorf("schema %q, max age %s, want the defaults the file doesn't set", cfg.Supabase.Schema, cfg.Queue.MaxAge)
+				}
+			},
+		},
+		{
+			name: "environment over the file",
+			env:  map[string]string{"PORT": "9100", "SUPABASE_KEY": "env-key", "LOG_LEVELS": "websocket=info,batch-queue=warn"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Port != 9100 || cfg.Supabase.Key != "env-key" {
+					t.Errorf("port %d, key %s, want the environment's", cfg.HTTP.Port, cfg.Supabase.Key)
+				}
+				want := map[string]string{"poller": "debug", "websocket": "info", "batch-queue": "warn"}
+				for component, level := range want {
+					if cfg.Logging.Levels[component] != level {
+						t.Errorf("levels %v, want the file's merged with the environment's %v", cfg.Logging.Levels, want)
+						break
+					}
+				}
+			},
+		},
+		{
+			name: "flags over the environment",
+			env:  map[string]string{"PORT": "9100", "LOG_LEVEL": "error"},
+			args: []string{"-port", "9200", "-log-level", "debug"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.HTTP.Port != 9200 || cfg.Logging.Level != "debug" {
+					t.Errorf("port %d, log level %s, want the flags'", cfg.HTTP.Port, cfg.Logging.Level)
+				}
+			},
+		},
+		{
+			name: "flags left out don't override with their defaults",
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Poller.Enabled {
+					t.Error("poller enabled by the default of -enable-poller over the file's false")
+				}
+			},
+		},
+		{
+			name: "VERBOSE below LOG_LEVEL",
+			env:  map[string]string{"VERBOSE": "true", "LOG_LEVEL": "error"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Logging.Level != "error" {
+					t.Errorf("log level %s, want LOG_LEVEL's error", cfg.Logging.Level)
+				}
+			},
+		},
+		{
+			name: "VERBOSE over the file",
+			env:  map[string]string{"VERBOSE": "true"},
+			check: func(t *testing.T, cfg *Config) {
+				if cfg.Logging.Level != "debug" {
+					t.Errorf("log level %s, want debug", cfg.Logging.Level)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range environment {
+				t.Setenv(name, "")
+			}
+			t.Setenv("CONFIG_FILE", writeFile(t, "config.yaml", file))
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			cfg, err := Load(tt.args)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.check(t, cfg)
+		})
+	}
+}
+
+func TestLoadConfigFile(t *testing.T) {
+	for _, name := range environment {
+		t.Setenv(name, "")
+	}
+	fromEnv := writeFile(t, "env.yaml", "supabase: {url: https://env.supabase.co, key: k}\n")
+	fromFlag := writeFile(t, "flag.yaml", "supabase: {url: https://flag.supabase.co, key: k}\n")
+	t.Setenv("CONFIG_FILE", fromEnv)
+
+	cfg, err := Load([]string{"-config", fromFlag})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Supabase.URL != "https://flag.supabase.co" || cfg.path != fromFlag {
+		t.Errorf("loaded %s from %s, want -config over CONFIG_FILE", cfg.Supabase.URL, cfg.path)
+	}
+
+	// Typos are errors rather than silently ignored keys
+	misspelled := writeFile(t, "typo.yaml", "supabase: {url: https://x.supabase.co, key: k}\nhttp: {prot: 9000}\n")
+	if _, err := Load([]string{"-config", misspelled}); err == nil || !strings.Contains(err.Error(), "field prot not found") {
+		t.Errorf("error %v, want the unknown key reported", err)
+	}
+}
+
+func TestLoadEnvironmentErrors(t *testing.T) {
+	for _, name := range environment {
+		t.Setenv(name, "")
+	}
+	t.Setenv("SUPABASE_URL", "https://x.supabase.co")
+	t.Setenv("SUPABASE_KEY", "k")
+	t.Setenv("PORT", "eighty")
+	t.Setenv("QUEUE_MAX_AGE", "10")
+	t.Setenv("LOG_LEVELS", "poller")
+
+	_, err := Load(nil)
+	if err == nil {
+		t.Fatal("invalid environment accepted")
+	}
+	for _, problem := range []string{`PORT: "eighty" is not an integer`, `QUEUE_MAX_AGE: "10" is not a duration`, `LOG_LEVELS: expected component=level, got "poller"`} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("error %q doesn't report %s", err, problem)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		modify   func(*Config)
+		problems []string
+	}{
+		{
+			name:   "valid",
+			modify: func(*Config) {},
+		},
+		{
+			name: "missing Supabase settings",
+			modify: func(c *Config) {
+				c.Supabase.URL, c.Supabase.Key = "", ""
+			},
+			problems: []string{"supabase.url: is required (SUPABASE_URL)", "supabase.key: is required (SUPABASE_KEY)"},
+		},
+		{
+			name:     "port out of range",
+			modify:   func(c *Config) { c.HTTP.Port = 70000 },
+			problems: []string{"http.port: must be between 1 and 65535 (got 70000)"},
+		},
+		{
+			name:     "Supabase URL without a scheme",
+			modify:   func(c *Config) { c.Supabase.URL = "x.supabase.co" },
+			problems: []string{`supabase.url: must be a http/https URL (got "x.supabase.co")`},
+		},
+		{
+			name:     "unknown component log level",
+			modify:   func(c *Config) { c.Logging.Levels["poller"] = "loud" },
+			problems: []string{`logging.levels.poller: unknown log level "loud"`},
+		},
+		{
+			name:     "poller budget over the rate limit",
+			modify:   func(c *Config) { c.Poller.RequestsPerHour = 400 },
+			problems: []string{"poller.requests_per_hour: must be between 1 and rate_limit.requests_per_hour (295) (got 400)"},
+		},
+		{
+			name:     "network listed twice",
+			modify:   func(c *Config) { c.Poller.Networks = []string{"velib", "velib"} },
+			problems: []string{`poller.networks: lists "velib" more than once`},
+		},
+		{
+			name:     "poller checks skipped when it's off",
+			modify:   func(c *Config) { c.Poller.Enabled, c.Poller.Networks = false, nil },
+			problems: nil,
+		},
+		{
+			name:     "burst on top of the full rate",
+			modify:   func(c *Config) { c.RateLimit.RequestsPerHour = 300 },
+			problems: []string{"rate_limit.burst: plus requests_per_hour must not exceed 300"},
+		},
+		{
+			name:     "stale threshold within a heartbeat",
+			modify:   func(c *Config) { c.Stale.Threshold = c.ChangeDetection.Heartbeat },
+			problems: []string{"stale.threshold: must be longer than change_detection.heartbeat"},
+		},
+		{
+			name:     "unknown validation action",
+			modify:   func(c *Config) { c.Validation.Actions = map[string]string{"negative_count": "drop"} },
+			problems: []string{`validation.actions: negative_count must be reject or flag (got "drop")`},
+		},
+		{
+			name: "every problem reported at once",
+			modify: func(c *Config) {
+				c.Logging.Format = "xml"
+				c.Queue.MaxRecords = 0
+			},
+			problems: []string{`logging.format: must be json or text (got "xml")`, "queue.max_records: must be positive (got 0)"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := Defaults()
+			cfg.Supabase.URL, cfg.Supabase.Key = "https://x.supabase.co", "k"
+			tt.modify(&cfg)
+
+			err := cfg.Validate()
+			if len(tt.problems) == 0 {
+				if err != nil {
+					t.Errorf("got %v, want no problems", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("no problems reported, want %q", tt.problems)
+			}
+			for _, problem := range tt.problems {
+				if !strings.Contains(err.Error(), "\n  - "+problem) {
+					t.Errorf("%q doesn't report %q", err, problem)
+				}
+			}
+		})
+	}
+}
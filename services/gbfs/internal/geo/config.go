@@ -0,0 +1,4 @@
+package geo
+
+// earthRadius is the mean radius of the Earth in kilometres
+const earthRadius = 6371.0
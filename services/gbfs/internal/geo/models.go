@@ -0,0 +1,6 @@
+package geo
+
+// Point is a location in degrees
+type Point struct {
+	Latitude, Longitude float64
+}
@@ -0,0 +1,14 @@
+package geo
+
+import "math"
+
+// Distance returns the great-circle distance between a and b in kilometres
+func Distance(a, b Point) float64 {
+	const radians = math.Pi / 180
+	latA, latB := a.Latitude*radians, b.Latitude*radians
+	dLat := latB - latA
+	dLon := (b.Longitude - a.Longitude) * radians
+
+	h := math.Pow(math.Sin(dLat/2), 2) + math.Cos(latA)*math.Cos(latB)*math.Pow(math.Sin(dLon/2), 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(min(h, 1)))
+}
@@ -0,0 +1,32 @@
+package geo
+
+import (
+	"math"
+	"testing"
+)
+
+func TestDistance(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b Point
+		want float64
+	}{
+		{"same point", Point{Latitude: 48.8566, Longitude: 2.3522}, Point{Latitude: 48.8566, Longitude: 2.3522}, 0},
+		{"Paris to London", Point{Latitude: 48.8566, Longitude: 2.3522}, Point{Latitude: 51.5074, Longitude: -0.1278}, 343.556},
+		{"a hundredth of a degree of latitude", Point{Latitude: 48.80, Longitude: 2.35}, Point{Latitude: 48.81, Longitude: 2.35}, 1.112},
+		{"across the antimeridian", Point{Latitude: 10, Longitude: 179.9}, Point{Latitude: 10, Longitude: -179.9}, 21.901},
+		{"a quarter of the equator", Point{}, Point{Longitude: 90}, 10007.543},
+		{"antipodes", Point{}, Point{Longitude: 180}, math.Pi * earthRadius},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Distance(tt.a, tt.b); math.Abs(got-tt.want) > 0.001 {
+				t.Errorf("Distance = %.3f km, want %.3f", got, tt.want)
+			}
+			if there, back := Distance(tt.a, tt.b), Distance(tt.b, tt.a); there != back {
+				t.Errorf("%.3f km there but %.3f back", there, back)
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package logging
+
+import (
+	"log/slog"
+	"time"
+)
+
+// settings are replaced by Setup; until then loggers use these defaults
+type settings struct {
+	level           slog.Level
+	componentLevels map[string]slog.Level
+	format          string
+
+	sampleInitial    int
+	sampleThereafter int
+	sampleTick       time.Duration
+}
+
+var current = settings{
+	level:            slog.LevelInfo,
+	componentLevels:  map[string]slog.Level{},
+	format:           "json",
+	sampleInitial:    10,
+	sampleThereafter: 100,
+	sampleTick:       time.Second,
+}
+
+// parseLevel accepts debug, info, warn and error (case-insensitive)
+func parseLevel(s string) (slog.Level, bool) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, false
+	}
+	return level, true
+}
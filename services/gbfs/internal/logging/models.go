@@ -0,0 +1,34 @@
+package logging
+
+import "log/slog"
+
+// Attribute keys shared by every component so logs can be filtered consistently
+const (
+	KeyComponent = "component"
+	KeyNetworkID = "network_id"
+	KeyStationID = "station_id"
+	KeyVehicleID = "vehicle_id"
+	KeyError     = "error"
+	KeyTraceID   = "trace_id"
+	KeySpanID    = "span_id"
+)
+
+// NetworkID returns the network_id attribute
+func NetworkID(id string) slog.Attr {
+	return slog.String(KeyNetworkID, id)
+}
+
+// StationID returns the station_id attribute
+func StationID(id string) slog.Attr {
+	return slog.String(KeyStationID, id)
+}
+
+// VehicleID returns the vehicle_id attribute
+func VehicleID(id string) slog.Attr {
+	return slog.String(KeyVehicleID, id)
+}
+
+// Err returns the error attribute
+func Err(err error) slog.Attr {
+	return slog.Any(KeyError, err)
+}
@@ -0,0 +1,251 @@
+package logging
+
+import (
+	"context"
+	"gbfs-service/internal/config"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+)
+
+// rootHandler is the sink every component logger writes to. Loggers are
+// usually created from package-level vars before Setup runs, so they resolve
+// the root lazily on every record.
+type rootHandler struct {
+	handler slog.Handler
+}
+
+var (
+	root atomic.Pointer[rootHandler]
+
+	// levelsMu guards levels and current
+	levelsMu sync.Mutex
+	levels   = map[string]*slog.LevelVar{}
+
+	sampling atomic.Pointer[sampler]
+)
+
+func init() {
+	root.Store(&rootHandler{handler: newHandler(os.Stderr, current.format)})
+	sampling.Store(newSampler(current.sampleInitial, current.sampleThereafter, current.sampleTick))
+}
+
+// Setup applies the logging configuration, installs the handler on stdout
+// and routes the standard library logger (and slog.Default) through the
+// "gbfs-service" component. Levels of loggers created earlier are updated.
+func Setup(cfg config.LoggingConfig) {
+	next := settings{
+		level:            slog.LevelInfo,
+		componentLevels:  make(map[string]slog.Level, len(cfg.Levels)),
+		format:           cfg.Format,
+		sampleInitial:    cfg.SampleInitial,
+		sampleThereafter: cfg.SampleThereafter,
+		sampleTick:       cfg.SampleTick,
+	}
+	if level, ok := parseLevel(cfg.Level); ok {
+		next.level = level
+	}
+	for component, levelStr := range cfg.Levels {
+		if level, ok := parseLevel(levelStr); ok {
+			next.componentLevels[component] = level
+		}
+	}
+
+	levelsMu.Lock()
+	current = next
+	for component, level := range levels {
+		level.Set(levelOf(component))
+	}
+	levelsMu.Unlock()
+
+	sampling.Store(newSampler(next.sampleInitial, next.sampleThereafter, next.sampleTick))
+	root.Store(&rootHandler{handler: newHandler(os.Stdout, next.format)})
+	slog.SetDefault(For("gbfs-service"))
+}
+
+// For returns a logger tagged with the component attribute whose level can be
+// overridden per component
+func For(component string) *slog.Logger {
+	return slog.New(&componentHandler{
+		component: component,
+		level:     levelFor(component),
+	})
+}
+
+// SetLevel changes the level of a component at runtime
+func SetLevel(component string, level slog.Level) {
+	levelFor(component).Set(level)
+}
+
+// Levels returns the current level of every component that has a logger
+func Levels() map[string]slog.Level {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	out := make(map[string]slog.Level, len(levels))
+	for component, level := range levels {
+		out[component] = level.Level()
+	}
+	return out
+}
+
+func levelFor(component string) *slog.LevelVar {
+	levelsMu.Lock()
+	defer levelsMu.Unlock()
+
+	if level, ok := levels[component]; ok {
+		return level
+	}
+
+	level := new(slog.LevelVar)
+	level.Set(levelOf(component))
+	levels[component] = level
+	return level
+}
+
+// levelOf resolves a component's configured level; callers hold levelsMu
+func levelOf(component string) slog.Level {
+	if override, ok := current.componentLevels[component]; ok {
+		return override
+	}
+	return current.level
+}
+
+func newHandler(w io.Writer, format string) slog.Handler {
+	// Level filtering happens in componentHandler, so let everything through here
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	if format == "text" {
+		return slog.NewTextHandler(w, opts)
+	}
+	return slog.NewJSONHandler(w, opts)
+}
+
+// componentHandler applies the component level and debug sampling, then
+// forwards to the current root handler
+type componentHandler struct {
+	component string
+	level     *slog.LevelVar
+
+	// Deferred WithAttrs/WithGroup calls, replayed onto the root handler
+	wrap []func(slog.Handler) slog.Handler
+
+	cache atomic.Pointer[builtHandler]
+}
+
+type builtHandler struct {
+	root    *rootHandler
+	handler slog.Handler
+}
+
+func (h *componentHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= h.level.Level()
+}
+
+func (h *componentHandler) Handle(ctx context.Context, r slog.Record) error {
+	if r.Level < slog.LevelInfo && !sampling.Load().allow(h.component, r.Message, r.Time) {
+		return nil
+	}
+
+	// Correlate with the active span so a log line can be found from a trace
+	if spanCtx := trace.SpanContextFromContext(ctx); spanCtx.IsValid() {
+		r = r.Clone()
+		r.AddAttrs(
+			slog.String(KeyTraceID, spanCtx.TraceID().String()),
+			slog.String(KeySpanID, spanCtx.SpanID().String()),
+		)
+	}
+	return h.resolve().Handle(ctx, r)
+}
+
+func (h *componentHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithAttrs(attrs) })
+}
+
+func (h *componentHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(next slog.Handler) slog.Handler { return next.WithGroup(name) })
+}
+
+func (h *componentHandler) with(fn func(slog.Handler) slog.Handler) slog.Handler {
+	wrap := make([]func(slog.Handler) slog.Handler, len(h.wrap), len(h.wrap)+1)
+	copy(wrap, h.wrap)
+	return &componentHandler{
+		component: h.component,
+		level:     h.level,
+		wrap:      append(wrap, fn),
+	}
+}
+
+// resolve builds (and caches) the handler chain for the current root
+func (h *componentHandler) resolve() slog.Handler {
+	current := root.Load()
+	if built := h.cache.Load(); built != nil && built.root == current {
+		return built.handler
+	}
+
+	handler := current.handler.WithAttrs([]slog.Attr{slog.String(KeyComponent, h.component)})
+	for _, fn := range h.wrap {
+		handler = fn(handler)
+	}
+	h.cache.Store(&builtHandler{root: current, handler: handler})
+	return handler
+}
+
+// sampler limits how many identical debug records a component emits per tick
+type sampler struct {
+	initial    int
+	thereafter int
+	tick       time.Duration
+
+	mu      sync.Mutex
+	windows map[string]*sampleWindow
+}
+
+type sampleWindow struct {
+	start time.Time
+	count int
+}
+
+func newSampler(initial, thereafter int, tick time.Duration) *sampler {
+	return &sampler{
+		initial:    initial,
+		thereafter: thereafter,
+		tick:       tick,
+		windows:    make(map[string]*sampleWindow),
+	}
+}
+
+func (s *sampler) allow(component, message string, now time.Time) bool {
+	if s.initial <= 0 {
+		return true
+	}
+
+	key := component + "\x00" + message
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	window, ok := s.windows[key]
+	if !ok || now.Sub(window.start) >= s.tick {
+		// Drop expired windows occasionally so the map doesn't grow forever
+		if len(s.windows) > 1024 {
+			for k, w := range s.windows {
+				if now.Sub(w.start) >= s.tick {
+					delete(s.windows, k)
+				}
+			}
+		}
+		window = &sampleWindow{start: now}
+		s.windows[key] = window
+	}
+
+	window.count++
+	if window.count <= s.initial {
+		return true
+	}
+	return s.thereafter > 0 && (window.count-s.initial)%s.thereafter == 0
+}
@@ -0,0 +1,9 @@
+package mappingerrors
+
+type mappingErrorsConfig struct {
+	capacity int // How many recent errors are kept in memory
+}
+
+var Config = mappingErrorsConfig{
+	capacity: 200,
+}
@@ -0,0 +1,27 @@
+package mappingerrors
+
+import "time"
+
+// Source identifies which ingest path produced a record
+type Source string
+
+const (
+	SourcePoller    Source = "poller"
+	SourceWebSocket Source = "websocket"
+)
+
+// MappingError is a record that could not be mapped to the database format
+type MappingError struct {
+	Time      time.Time `json:"time"`
+	Source    Source    `json:"source"`
+	Entity    string    `json:"entity"` // "station" or "vehicle"
+	NetworkID string    `json:"network_id"`
+	RecordID  string    `json:"record_id,omitempty"` // Upstream ID, if it could be read
+	Error     string    `json:"error"`
+	Reasons   []string  `json:"reasons,omitempty"` // Reason codes of a record rejected by validation
+}
+
+// coded is an error that carries machine-readable reason codes
+type coded interface {
+	ReasonCodes() []string
+}
@@ -0,0 +1,67 @@
+package mappingerrors
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// recent is a fixed-size ring buffer of the latest mapping errors
+var recent = struct {
+	sync.Mutex
+	entries []MappingError
+	next    int
+	full    bool
+}{
+	entries: make([]MappingError, Config.capacity),
+}
+
+// Record remembers a mapping failure; recordID is the upstream ID, if any
+func Record(source Source, entity, networkID, recordID string, err error) {
+	if err == nil {
+		return
+	}
+
+	entry := MappingError{
+		Time:      time.Now().UTC(),
+		Source:    source,
+		Entity:    entity,
+		NetworkID: networkID,
+		RecordID:  recordID,
+		Error:     err.Error(),
+	}
+	var reasons coded
+	if errors.As(err, &reasons) {
+		entry.Reasons = reasons.ReasonCodes()
+	}
+
+	recent.Lock()
+	defer recent.Unlock()
+
+	recent.entries[recent.next] = entry
+	recent.next = (recent.next + 1) % len(recent.entries)
+	if recent.next == 0 {
+		recent.full = true
+	}
+}
+
+// Recent returns up to n of the latest mapping errors, newest first
+func Recent(n int) []MappingError {
+	recent.Lock()
+	defer recent.Unlock()
+
+	size := recent.next
+	if recent.full {
+		size = len(recent.entries)
+	}
+	if n <= 0 || n > size {
+		n = size
+	}
+
+	out := make([]MappingError, 0, n)
+	for i := 1; i <= n; i++ {
+		idx := (recent.next - i + len(recent.entries)) % len(recent.entries)
+		out = append(out, recent.entries[idx])
+	}
+	return out
+}
@@ -0,0 +1,5 @@
+package mappingrules
+
+import "gbfs-service/internal/logging"
+
+var logger = logging.For("mapping-rules")
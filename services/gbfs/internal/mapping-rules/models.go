@@ -0,0 +1,72 @@
+package mappingrules
+
+// File is the layout of the rules file. Rules of a class apply to every
+// network it lists; a network's own rules are laid over its class's.
+type File struct {
+	Classes  map[string]ClassRule `yaml:"classes" json:"classes"`
+	Networks map[string]Rule      `yaml:"networks" json:"networks"`
+}
+
+// ClassRule is the rule shared by the networks of one citybik.es scraper
+// class. The API doesn't say which class a network uses, so its members are
+// listed.
+type ClassRule struct {
+	Networks []string `yaml:"networks" json:"networks"`
+	Rule     `yaml:",inline"`
+}
+
+// Rule overrides how the stations and vehicles of a network are mapped
+type Rule struct {
+	Station StationRule `yaml:"station" json:"station"`
+	Vehicle VehicleRule `yaml:"vehicle" json:"vehicle"`
+}
+
+// StationRule overrides the station heuristics
+type StationRule struct {
+	// Fields are copied from a source to a target before mapping, keyed by
+	// target: {"extra.ebikes": "extra.num_ebikes"}. Paths are a top-level
+	// field or extra.<field>.
+	Fields map[string]string `yaml:"fields" json:"fields,omitempty"`
+
+	// Virtual forces every station virtual (true) or physical (false)
+	Virtual *bool `yaml:"virtual" json:"virtual,omitempty"`
+
+	// Capacity picks where capacity comes from instead of guessing
+	Capacity Capacity `yaml:"capacity" json:"capacity,omitempty"`
+
+	// Status maps extra.status values (case-insensitive) to whether the
+	// station is in service. They replace the built-in closed/offline/
+	// maintenance/open/active handling; explicit flags still win.
+	Status map[string]bool `yaml:"status" json:"status,omitempty"`
+}
+
+// VehicleRule overrides the vehicle heuristics
+type VehicleRule struct {
+	// Fields are copied like StationRule.Fields
+	Fields map[string]string `yaml:"fields" json:"fields,omitempty"`
+
+	// VehicleType forces the type of every vehicle
+	VehicleType string `yaml:"vehicle_type" json:"vehicle_type,omitempty"`
+}
+
+// Capacity is a source of station capacity
+type Capacity string
+
+const (
+	CapacityAuto  Capacity = ""      // Built-in heuristics
+	CapacityDocks Capacity = "docks" // free_bikes + empty_slots
+	CapacitySlots Capacity = "slots" // extra.slots, heuristics if missing
+	CapacityBikes Capacity = "bikes" // free_bikes
+)
+
+// ruleSet is a loaded rules file resolved per network
+type ruleSet struct {
+	path     string
+	networks map[string]Rule
+}
+
+// Loaded describes the rules in effect
+type Loaded struct {
+	Path     string          `json:"path"`
+	Networks map[string]Rule `json:"networks"` // Resolved, class rules included
+}
@@ -0,0 +1,279 @@
+package mappingrules
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"io"
+	"maps"
+	"os"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"gopkg.in/yaml.v3"
+)
+
+var rules atomic.Pointer[ruleSet]
+
+func init() {
+	rules.Store(&ruleSet{networks: map[string]Rule{}})
+}
+
+// Configure loads the rules file named by cfg, replacing the rules in
+// effect. If the file can't be loaded the current rules are kept.
+func Configure(cfg config.MappingConfig) error {
+	next := &ruleSet{path: cfg.RulesFile, networks: map[string]Rule{}}
+	if cfg.RulesFile != "" {
+		file, err := load(cfg.RulesFile)
+		if err != nil {
+			return err
+		}
+		next.networks = file.resolve()
+	}
+
+	rules.Store(next)
+	logger.Info("mapping rules loaded", "path", cfg.RulesFile, "networks", len(next.networks))
+	return nil
+}
+
+// For returns the rule of a network; networks without one get the zero
+// Rule, which changes nothing
+func For(networkID string) Rule {
+	return rules.Load().networks[networkID]
+}
+
+// Rules returns the rules in effect
+func Rules() Loaded {
+	set := rules.Load()
+	return Loaded{Path: set.path, Networks: maps.Clone(set.networks)}
+}
+
+// load reads and validates a rules file; unknown keys are errors so typos
+// don't silently do nothing
+func load(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mapping rules: %v", err)
+	}
+
+	var file File
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("failed to parse mapping rules %s: %v", path, err)
+	}
+
+	if err := file.validate(); err != nil {
+		return nil, fmt.Errorf("invalid mapping rules %s: %v", path, err)
+	}
+	return &file, nil
+}
+
+// validate reports every problem of the file together
+func (f *File) validate() error {
+	var problems []string
+	check := func(ok bool, format string, args ...any) {
+		if !ok {
+			problems = append(problems, fmt.Sprintf(format, args...))
+		}
+	}
+
+	classOf := make(map[string]string)
+	for name, class := range f.Classes {
+		check(len(class.Networks) > 0, "classes.%s.networks: must list at least one network", name)
+		for _, id := range class.Networks {
+			check(id != "", "classes.%s.networks: must not contain empty IDs", name)
+			if other, ok := classOf[id]; ok {
+				check(false, "classes.%s.networks: %q already belongs to class %s", name, id, other)
+			}
+			classOf[id] = name
+		}
+		class.Rule.validate("classes."+name, check)
+	}
+	for id, rule := range f.Networks {
+		rule.validate("networks."+id, check)
+	}
+
+	if len(problems) == 0 {
+		return nil
+	}
+	sort.Strings(problems)
+	return fmt.Errorf("\n  - %s", strings.Join(problems, "\n  - "))
+}
+
+func (r Rule) validate(prefix string, check func(bool, string, ...any)) {
+	for target, source := range r.Station.Fields {
+		check(validPath(target) && target != "id", "%s.station.fields: %q is not a field that can be set", prefix, target)
+		check(validPath(source), "%s.station.fields: %q is not a field", prefix, source)
+	}
+	switch r.Station.Capacity {
+	case CapacityAuto, CapacityDocks, CapacitySlots, CapacityBikes:
+	default:
+		check(false, "%s.station.capacity: must be docks, slots or bikes (got %q)", prefix, r.Station.Capacity)
+	}
+	statuses := make(map[string]bool, len(r.Station.Status))
+	for status, inService := range r.Station.Status {
+		key := strings.ToLower(status)
+		previous, seen := statuses[key]
+		check(!seen || previous == inService, "%s.station.status: %q is mapped both ways", prefix, status)
+		statuses[key] = inService
+	}
+
+	for target, source := range r.Vehicle.Fields {
+		check(validPath(target) && target != "id", "%s.vehicle.fields: %q is not a field that can be set", prefix, target)
+		check(validPath(source), "%s.vehicle.fields: %q is not a field", prefix, source)
+	}
+}
+
+// validPath accepts "field" and "extra.field"
+func validPath(path string) bool {
+	parent, field, nested := strings.Cut(path, ".")
+	if !nested {
+		return path != "" && path != "extra"
+	}
+	return parent == "extra" && field != "" && !strings.Contains(field, ".")
+}
+
+// resolve lays every network's rule over its class's
+func (f *File) resolve() map[string]Rule {
+	resolved := make(map[string]Rule)
+	for _, class := range f.Classes {
+		for _, id := range class.Networks {
+			resolved[id] = class.Rule.normalized()
+		}
+	}
+	for id, rule := range f.Networks {
+		resolved[id] = resolved[id].with(rule.normalized())
+	}
+	return resolved
+}
+
+// normalized lower-cases status keys so lookups are case-insensitive
+func (r Rule) normalized() Rule {
+	if r.Station.Status != nil {
+		statuses := make(map[string]bool, len(r.Station.Status))
+		for status, inService := range r.Station.Status {
+			statuses[strings.ToLower(status)] = inService
+		}
+		r.Station.Status = statuses
+	}
+	return r
+}
+
+// with returns r with everything over sets replacing it
+func (r Rule) with(over Rule) Rule {
+	r.Station.Fields = merged(r.Station.Fields, over.Station.Fields)
+	if over.Station.Virtual != nil {
+		r.Station.Virtual = over.Station.Virtual
+	}
+	if over.Station.Capacity != CapacityAuto {
+		r.Station.Capacity = over.Station.Capacity
+	}
+	r.Station.Status = merged(r.Station.Status, over.Station.Status)
+
+	r.Vehicle.Fields = merged(r.Vehicle.Fields, over.Vehicle.Fields)
+	if over.Vehicle.VehicleType != "" {
+		r.Vehicle.VehicleType = over.Vehicle.VehicleType
+	}
+	return r
+}
+
+func merged[V any](base, over map[string]V) map[string]V {
+	if len(over) == 0 {
+		return base
+	}
+	out := maps.Clone(base)
+	if out == nil {
+		out = make(map[string]V, len(over))
+	}
+	maps.Copy(out, over)
+	return out
+}
+
+// InService looks a station status up in the rule; ok is false if the
+// rule doesn't map it
+func (r StationRule) InService(status string) (inService, ok bool) {
+	if status == "" || len(r.Status) == 0 {
+		return false, false
+	}
+	inService, ok = r.Status[strings.ToLower(status)]
+	return inService, ok
+}
+
+// Remap applies the field copies to a station. Its raw data stays as
+// received.
+func (r StationRule) Remap(station citybikes.Station) citybikes.Station {
+	remapped := station
+	if remap(station.Raw, r.Fields, &remapped) {
+		remapped.Raw = station.Raw
+	}
+	return remapped
+}
+
+// Remap applies the field copies to a vehicle. Its raw data stays as
+// received.
+func (r VehicleRule) Remap(vehicle citybikes.Vehicle) citybikes.Vehicle {
+	remapped := vehicle
+	if remap(vehicle.Raw, r.Fields, &remapped) {
+		remapped.Raw = vehicle.Raw
+	}
+	return remapped
+}
+
+// remap copies fields within a raw record and decodes the result into v.
+// Every source is read before any target is written, so fields can be
+// swapped; sources that are missing are skipped.
+func remap[T any](raw json.RawMessage, fields map[string]string, v *T) bool {
+	if len(fields) == 0 {
+		return false
+	}
+
+	var record, extra map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &record); err != nil || record == nil {
+		return false
+	}
+	json.Unmarshal(record["extra"], &extra)
+
+	values := make(map[string]json.RawMessage, len(fields))
+	for target, source := range fields {
+		from := record
+		if field, nested := strings.CutPrefix(source, "extra."); nested {
+			from, source = extra, field
+		}
+		if value, ok := from[source]; ok {
+			values[target] = value
+		}
+	}
+	if len(values) == 0 {
+		return false
+	}
+
+	for target, value := range values {
+		if field, nested := strings.CutPrefix(target, "extra."); nested {
+			if extra == nil {
+				extra = make(map[string]json.RawMessage)
+			}
+			extra[field] = value
+			continue
+		}
+		record[target] = value
+	}
+	if extra != nil {
+		record["extra"], _ = json.Marshal(extra)
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return false
+	}
+	var remapped T
+	if err := json.Unmarshal(data, &remapped); err != nil {
+		return false
+	}
+	*v = remapped
+	return true
+}
@@ -0,0 +1,223 @@
+package mappingrules
+
+import (
+	"encoding/json"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// configure loads rules from a temporary file and restores the previous
+// rules when the test ends
+func configure(t *testing.T, rulesFile string) error {
+	t.Helper()
+
+	previous := rules.Load()
+	t.Cleanup(func() { rules.Store(previous) })
+
+	path := filepath.Join(t.TempDir(), "rules.yaml")
+	if err := os.WriteFile(path, []byte(rulesFile), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Configure(config.MappingConfig{RulesFile: path})
+}
+
+func TestNetworkRulesOverClassRules(t *testing.T) {
+	err := configure(t, `
+classes:
+  smoove:
+    networks: [velib, velo-toulouse]
+    station:
+      capacity: slots
+      virtual: false
+      fields:
+        extra.ebikes: extra.num_ebikes
+      status:
+        CLOSED: false
+    vehicle:
+      vehicle_type: bicycle
+networks:
+  velib:
+    station:
+      capacity: docks
+      fields:
+        extra.slots: extra.capacity
+      status:
+        Works: true
+  nextbike-berlin:
+    vehicle:
+      vehicle_type: scooter
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	velib := For("velib")
+	if velib.Station.Capacity != CapacityDocks {
+		t.Errorf("velib capacity %q, want the network's docks over the class's slots", velib.Station.Capacity)
+	}
+	if velib.Station.Virtual == nil || *velib.Station.Virtual {
+		t.Errorf("velib virtual %v, want the class's false", velib.Station.Virtual)
+	}
+	if len(velib.Station.Fields) != 2 || velib.Station.Fields["extra.ebikes"] != "extra.num_ebikes" || velib.Station.Fields["extra.slots"] != "extra.capacity" {
+		t.Errorf("velib fields %v, want the class's and the network's", velib.Station.Fields)
+	}
+	if velib.Vehicle.VehicleType != "bicycle" {
+		t.Errorf("velib vehicle type %q, want the class's bicycle", velib.Vehicle.VehicleType)
+	}
+
+	// Status keys match whatever the case on either side
+	for status, want := range map[string]bool{"closed": false, "Closed": false, "WORKS": true, "works": true} {
+		if inService, ok := velib.Station.InService(status); !ok || inService != want {
+			t.Errorf("velib status %q: in service %v (mapped %v), want %v", status, inService, ok, want)
+		}
+	}
+	if _, ok := velib.Station.InService("maintenance"); ok {
+		t.Error("velib maps a status no rule lists")
+	}
+
+	// The class applies unchanged to its other networks
+	toulouse := For("velo-toulouse")
+	if toulouse.Station.Capacity != CapacitySlots || len(toulouse.Station.Fields) != 1 {
+		t.Errorf("velo-toulouse rule %+v, want the class's", toulouse.Station)
+	}
+	if _, ok := toulouse.Station.InService("works"); ok {
+		t.Error("velib's own status mapping leaked into its class")
+	}
+
+	if For("nextbike-berlin").Vehicle.VehicleType != "scooter" {
+		t.Error("network without a class lost its rule")
+	}
+	if rule := For("unknown"); rule.Station.Capacity != CapacityAuto || rule.Station.Fields != nil {
+		t.Errorf("network without rules got %+v, want the zero rule", rule)
+	}
+}
+
+func TestInvalidRules(t *testing.T) {
+	tests := []struct {
+		name      string
+		rulesFile string
+		problem   string
+	}{
+		{
+			name:      "misspelled key",
+			rulesFile: "networks:\n  velib:\n    station:\n      capacty: docks\n",
+			problem:   "field capacty not found",
+		},
+		{
+			name:      "unknown capacity",
+			rulesFile: "networks:\n  velib:\n    station:\n      capacity: racks\n",
+			problem:   `networks.velib.station.capacity: must be docks, slots or bikes (got "racks")`,
+		},
+		{
+			name:      "status mapped both ways in different cases",
+			rulesFile: "networks:\n  velib:\n    station:\n      status:\n        closed: false\n        CLOSED: true\n",
+			problem:   "is mapped both ways",
+		},
+		{
+			name:      "network in two classes",
+			rulesFile: "classes:\n  a:\n    networks: [velib]\n  b:\n    networks: [velib]\n",
+			problem:   `"velib" already belongs to class`,
+		},
+		{
+			name:      "class without networks",
+			rulesFile: "classes:\n  a:\n    station:\n      capacity: docks\n",
+			problem:   "classes.a.networks: must list at least one network",
+		},
+		{
+			name:      "field path too deep",
+			rulesFile: "networks:\n  velib:\n    vehicle:\n      fields:\n        extra.a.b: lat\n",
+			problem:   `networks.velib.vehicle.fields: "extra.a.b" is not a field that can be set`,
+		},
+		{
+			name:      "ID as a target",
+			rulesFile: "networks:\n  velib:\n    station:\n      fields:\n        id: extra.uid\n",
+			problem:   `"id" is not a field that can be set`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := configure(t, `networks: {velib: {station: {capacity: docks}}}`); err != nil {
+				t.Fatal(err)
+			}
+
+			err := configure(t, tt.rulesFile)
+			if err == nil || !strings.Contains(err.Error(), tt.problem) {
+				t.Fatalf("error %v, want one mentioning %q", err, tt.problem)
+			}
+			if For("velib").Station.Capacity != CapacityDocks {
+				t.Error("the rules in effect were replaced by invalid ones")
+			}
+		})
+	}
+}
+
+func TestRemap(t *testing.T) {
+	const raw = `{"id": "s1", "name": "Gare", "free_bikes": 3, "empty_slots": 7, "latitude": 48.8, "longitude": 2.3,
+		"extra": {"num_ebikes": 2, "slots": 12, "lat": 2.3, "lng": 48.8}}`
+
+	tests := []struct {
+		name   string
+		fields map[string]string
+		check  func(t *testing.T, s citybikes.Station)
+	}{
+		{
+			name:   "extra field into another extra field",
+			fields: map[string]string{"extra.ebikes": "extra.num_ebikes"},
+			check: func(t *testing.T, s citybikes.Station) {
+				if s.Extra.Ebikes == nil || *s.Extra.Ebikes != 2 {
+					t.Errorf("ebikes %v, want 2", s.Extra.Ebikes)
+				}
+			},
+		},
+		{
+			name:   "top-level fields swapped",
+			fields: map[string]string{"free_bikes": "empty_slots", "empty_slots": "free_bikes"},
+			check: func(t *testing.T, s citybikes.Station) {
+				if s.FreeBikes != 7 || s.EmptySlots != 3 {
+					t.Errorf("free bikes %d, empty slots %d, want them swapped to 7 and 3", s.FreeBikes, s.EmptySlots)
+				}
+			},
+		},
+		{
+			name:   "coordinates swapped from extra",
+			fields: map[string]string{"latitude": "extra.lng", "longitude": "extra.lat"},
+			check: func(t *testing.T, s citybikes.Station) {
+				if s.Latitude != 48.8 || s.Longitude != 2.3 {
+					t.Errorf("coordinates %g, %g, want 48.8, 2.3", s.Latitude, s.Longitude)
+				}
+			},
+		},
+		{
+			name:   "missing sources are skipped",
+			fields: map[string]string{"free_bikes": "extra.missing", "extra.ebikes": "extra.num_ebikes"},
+			check: func(t *testing.T, s citybikes.Station) {
+				if s.FreeBikes != 3 || s.Extra.Ebikes == nil || *s.Extra.Ebikes != 2 {
+					t.Errorf("free bikes %d, ebikes %v, want 3 kept and 2 copied", s.FreeBikes, s.Extra.Ebikes)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var station citybikes.Station
+			if err := json.Unmarshal([]byte(raw), &station); err != nil {
+				t.Fatal(err)
+			}
+
+			remapped := StationRule{Fields: tt.fields}.Remap(station)
+			tt.check(t, remapped)
+			if string(remapped.Raw) != string(station.Raw) {
+				t.Error("the raw data was changed")
+			}
+			if station.FreeBikes != 3 {
+				t.Error("the original station was changed")
+			}
+		})
+	}
+}
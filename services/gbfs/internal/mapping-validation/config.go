@@ -0,0 +1,9 @@
+package mappingvalidation
+
+import "gbfs-service/internal/logging"
+
+var logger = logging.For("mapping-validation")
+
+// maxValue bounds counts and battery levels. No station holds a million
+// bikes, and below it sums can't overflow the integer columns.
+const maxValue = 1_000_000
@@ -0,0 +1,83 @@
+package mappingvalidation
+
+import (
+	"gbfs-service/internal/config"
+	"strings"
+)
+
+// Reason is the machine-readable code of a failed check
+type Reason string
+
+const (
+	ReasonMissingCoordinates   Reason = "missing_coordinates"    // At 0,0, where feeds put records without a location
+	ReasonInvalidCoordinates   Reason = "invalid_coordinates"    // Latitude or longitude out of range
+	ReasonOutsideNetworkArea   Reason = "outside_network_area"   // Further from the network than max_distance_km
+	ReasonNegativeCount        Reason = "negative_count"         // A count the mapper clamped to 0
+	ReasonValueOutOfRange      Reason = "value_out_of_range"     // A count or battery level beyond any real one
+	ReasonCountsExceedCapacity Reason = "counts_exceed_capacity" // Bikes + ebikes + docks > capacity
+	ReasonInvalidBattery       Reason = "invalid_battery"        // Battery level outside 0-100
+	ReasonInvalidTimestamp     Reason = "invalid_timestamp"      // A timestamp the mapper couldn't parse
+	ReasonFutureTimestamp      Reason = "future_timestamp"       // Ahead of our clock by more than max_clock_skew
+)
+
+// Action is what happens to a record that fails a check
+type Action string
+
+const (
+	ActionReject Action = "reject" // The record is not written
+	ActionFlag   Action = "flag"   // The record is written and the failure counted
+)
+
+// defaultActions of every reason; reasons missing here don't exist
+var defaultActions = map[Reason]Action{
+	ReasonMissingCoordinates:   ActionReject,
+	ReasonInvalidCoordinates:   ActionReject,
+	ReasonOutsideNetworkArea:   ActionReject,
+	ReasonNegativeCount:        ActionFlag,
+	ReasonValueOutOfRange:      ActionReject,
+	ReasonCountsExceedCapacity: ActionFlag,
+	ReasonInvalidBattery:       ActionFlag,
+	ReasonInvalidTimestamp:     ActionFlag,
+	ReasonFutureTimestamp:      ActionReject,
+}
+
+// Rejection is the error returned for a record that failed a rejecting
+// check. Reasons lists every check it failed, flags included.
+type Rejection struct {
+	Reasons []Reason
+}
+
+func (r *Rejection) Error() string {
+	return "rejected by validation: " + strings.Join(r.ReasonCodes(), ", ")
+}
+
+// ReasonCodes returns the reasons as strings, which is how mapping errors
+// pick them up
+func (r *Rejection) ReasonCodes() []string {
+	codes := make([]string, len(r.Reasons))
+	for i, reason := range r.Reasons {
+		codes[i] = string(reason)
+	}
+	return codes
+}
+
+// Stats counts the records of one entity that failed one check
+type Stats struct {
+	Entity   string            `json:"entity"` // "station" or "vehicle"
+	Reason   Reason            `json:"reason"`
+	Action   Action            `json:"action"` // The action currently taken
+	Count    uint64            `json:"count"`
+	Networks map[string]uint64 `json:"networks"` // Count per citybik.es network ID
+}
+
+// counterKey identifies a Stats entry
+type counterKey struct {
+	entity string
+	reason Reason
+}
+
+// checks are the settings in effect, with the actions resolved
+type checks struct {
+	config.ValidationConfig
+	actions map[Reason]Action
+}
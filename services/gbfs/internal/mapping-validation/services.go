@@ -0,0 +1,250 @@
+package mappingvalidation
+
+import (
+	"cmp"
+	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/geo"
+	"gbfs-service/internal/logging"
+	mappingrules "gbfs-service/internal/mapping-rules"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"gbfs-service/internal/timestamps"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"maps"
+	"math"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	settings atomic.Pointer[checks]
+
+	// counters of failed checks since startup, per network
+	counters = struct {
+		sync.Mutex
+		stats map[counterKey]map[string]uint64
+	}{
+		stats: make(map[counterKey]map[string]uint64),
+	}
+
+	// areas are the network locations records are checked against, keyed
+	// by citybik.es network ID
+	areas = struct {
+		sync.RWMutex
+		centers map[string]geo.Point
+	}{
+		centers: make(map[string]geo.Point),
+	}
+)
+
+func init() {
+	defaults, _ := resolve(config.Defaults().Validation)
+	settings.Store(defaults)
+}
+
+// Configure applies the validation settings. Unknown reason codes are an
+// error and leave the current settings in effect.
+func Configure(cfg config.ValidationConfig) error {
+	next, err := resolve(cfg)
+	if err != nil {
+		return err
+	}
+
+	settings.Store(next)
+	logger.Info("validation configured",
+		"enabled", cfg.Enabled,
+		"max_distance_km", cfg.MaxDistance,
+		"max_clock_skew", cfg.MaxClockSkew,
+	)
+	return nil
+}
+
+func resolve(cfg config.ValidationConfig) (*checks, error) {
+	actions := maps.Clone(defaultActions)
+	for code, action := range cfg.Actions {
+		if _, ok := actions[Reason(code)]; !ok {
+			return nil, fmt.Errorf("validation.actions: unknown reason code %q", code)
+		}
+		actions[Reason(code)] = Action(action)
+	}
+	return &checks{ValidationConfig: cfg, actions: actions}, nil
+}
+
+// SetArea records where a network is. Networks without a location are not
+// checked for records outside their area.
+func SetArea(networkID string, location *citybikes.Location) {
+	if location == nil || (location.Latitude == 0 && location.Longitude == 0) {
+		return
+	}
+
+	areas.Lock()
+	defer areas.Unlock()
+	areas.centers[networkID] = geo.Point{Latitude: location.Latitude, Longitude: location.Longitude}
+}
+
+// Station checks a mapped station against the station it was mapped from.
+// It returns a *Rejection if a rejecting check failed; failures that are
+// only flagged are counted and the station passes.
+func Station(station citybikes.Station, record *stationMapper.StationRecord, networkID string) error {
+	cfg := settings.Load()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	// Check the fields the mapper actually read
+	station = mappingrules.For(networkID).Station.Remap(station)
+
+	reasons := checkCoordinates(cfg, networkID, station.Latitude, station.Longitude)
+
+	counts := []int{station.FreeBikes, station.EmptySlots}
+	if extra := station.Extra; extra != nil {
+		for _, count := range []*int{extra.Slots, extra.Ebikes, extra.NormalBikes} {
+			if count != nil {
+				counts = append(counts, *count)
+			}
+		}
+	}
+	if slices.ContainsFunc(counts, func(count int) bool { return count < -maxValue || count > maxValue }) {
+		reasons = append(reasons, ReasonValueOutOfRange)
+	}
+	if slices.ContainsFunc(counts, func(count int) bool { return count < 0 }) {
+		reasons = append(reasons, ReasonNegativeCount)
+	}
+
+	if record.NumBikesAvailable+record.NumEbikesAvailable+record.NumDocksAvailable > record.Capacity {
+		reasons = append(reasons, ReasonCountsExceedCapacity)
+	}
+
+	if station.Timestamp != "" && record.LastReportedSynthesized {
+		reasons = append(reasons, ReasonInvalidTimestamp)
+	}
+	reasons = append(reasons, checkTimestamp(cfg, &record.LastReported)...)
+
+	return conclude(cfg, "station", networkID, reasons)
+}
+
+// Vehicle checks a mapped vehicle against the vehicle it was mapped from,
+// like Station
+func Vehicle(vehicle citybikes.Vehicle, record *vehicleMapper.VehicleRecord, networkID string) error {
+	cfg := settings.Load()
+	if !cfg.Enabled {
+		return nil
+	}
+
+	vehicle = mappingrules.For(networkID).Vehicle.Remap(vehicle)
+
+	var reasons []Reason
+	if latitude, longitude, ok := vehicle.Coordinates(); ok {
+		reasons = checkCoordinates(cfg, networkID, latitude, longitude)
+	}
+
+	if level := record.BatteryLevel; level != nil {
+		switch {
+		case *level < -maxValue || *level > maxValue:
+			reasons = append(reasons, ReasonValueOutOfRange)
+		case *level < 0 || *level > 100:
+			reasons = append(reasons, ReasonInvalidBattery)
+		}
+	}
+
+	if vehicle.Timestamp != "" && record.LastReported == nil {
+		reasons = append(reasons, ReasonInvalidTimestamp)
+	}
+	reasons = append(reasons, checkTimestamp(cfg, record.LastReported)...)
+
+	return conclude(cfg, "vehicle", networkID, reasons)
+}
+
+// checkCoordinates checks a location is real and near its network
+func checkCoordinates(cfg *checks, networkID string, latitude, longitude float64) []Reason {
+	switch {
+	case latitude == 0 && longitude == 0:
+		return []Reason{ReasonMissingCoordinates}
+	case math.Abs(latitude) > 90 || math.Abs(longitude) > 180:
+		return []Reason{ReasonInvalidCoordinates}
+	}
+
+	areas.RLock()
+	center, known := areas.centers[networkID]
+	areas.RUnlock()
+
+	if known && geo.Distance(center, geo.Point{Latitude: latitude, Longitude: longitude}) > cfg.MaxDistance {
+		return []Reason{ReasonOutsideNetworkArea}
+	}
+	return nil
+}
+
+// checkTimestamp checks a normalized timestamp isn't ahead of our clock
+func checkTimestamp(cfg *checks, timestamp *string) []Reason {
+	if timestamp == nil {
+		return nil
+	}
+	parsed, err := timestamps.Parse(*timestamp)
+	if err == nil && parsed.After(time.Now().Add(cfg.MaxClockSkew)) {
+		return []Reason{ReasonFutureTimestamp}
+	}
+	return nil
+}
+
+// conclude counts the failed checks and decides whether the record passes
+func conclude(cfg *checks, entity, networkID string, reasons []Reason) error {
+	if len(reasons) == 0 {
+		return nil
+	}
+
+	counters.Lock()
+	for _, reason := range reasons {
+		key := counterKey{entity, reason}
+		if counters.stats[key] == nil {
+			counters.stats[key] = make(map[string]uint64)
+		}
+		counters.stats[key][networkID]++
+	}
+	counters.Unlock()
+
+	for _, reason := range reasons {
+		if cfg.actions[reason] == ActionReject {
+			return &Rejection{Reasons: reasons}
+		}
+	}
+
+	logger.Debug("record flagged by validation", logging.NetworkID(networkID), "entity", entity, "reasons", reasons)
+	return nil
+}
+
+// AllStats returns the failed checks counted since startup, limited to one
+// network unless networkID is empty
+func AllStats(networkID string) []Stats {
+	actions := settings.Load().actions
+
+	counters.Lock()
+	defer counters.Unlock()
+
+	out := make([]Stats, 0, len(counters.stats))
+	for key, networks := range counters.stats {
+		stats := Stats{
+			Entity:   key.entity,
+			Reason:   key.reason,
+			Action:   actions[key.reason],
+			Networks: make(map[string]uint64),
+		}
+		for id, count := range networks {
+			if networkID != "" && id != networkID {
+				continue
+			}
+			stats.Count += count
+			stats.Networks[id] = count
+		}
+		if stats.Count > 0 {
+			out = append(out, stats)
+		}
+	}
+
+	slices.SortFunc(out, func(a, b Stats) int {
+		return cmp.Or(cmp.Compare(a.Entity, b.Entity), cmp.Compare(a.Reason, b.Reason))
+	})
+	return out
+}
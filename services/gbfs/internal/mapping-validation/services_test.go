@@ -0,0 +1,337 @@
+package mappingvalidation
+
+import (
+	"errors"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/geo"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"gbfs-service/internal/timestamps"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"maps"
+	"slices"
+	"testing"
+	"time"
+)
+
+// configure applies the default settings with actions on top, clears the
+// counters and network areas, and restores the settings when the test ends
+func configure(t *testing.T, actions map[string]string) {
+	t.Helper()
+
+	previous := settings.Load()
+	t.Cleanup(func() { settings.Store(previous) })
+
+	cfg := config.Defaults().Validation
+	cfg.Actions = actions
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	counters.Lock()
+	counters.stats = make(map[counterKey]map[string]uint64)
+	counters.Unlock()
+	areas.Lock()
+	areas.centers = make(map[string]geo.Point)
+	areas.Unlock()
+}
+
+// validStation is a station in central Paris and the record mapped from it
+func validStation() (citybikes.Station, *stationMapper.StationRecord) {
+	now := timestamps.Format(time.Now())
+	station := citybikes.Station{ID: "1", Latitude: 48.86, Longitude: 2.34, FreeBikes: 3, EmptySlots: 7, Timestamp: citybikes.Timestamp(now)}
+	record := &stationMapper.StationRecord{Capacity: 10, NumBikesAvailable: 3, NumDocksAvailable: 7, LastReported: now}
+	return station, record
+}
+
+// validVehicle is a vehicle in central Paris and the record mapped from it
+func validVehicle() (citybikes.Vehicle, *vehicleMapper.VehicleRecord) {
+	now := timestamps.Format(time.Now())
+	latitude, longitude, battery := 48.86, 2.34, 80
+	vehicle := citybikes.Vehicle{ID: "1", Latitude: &latitude, Longitude: &longitude, Timestamp: citybikes.Timestamp(now)}
+	record := &vehicleMapper.VehicleRecord{BatteryLevel: &battery, LastReported: &now}
+	return vehicle, record
+}
+
+// reasonsOf returns the reasons a record failed, as counted by AllStats
+func reasonsOf(networkID string) []Reason {
+	var reasons []Reason
+	for _, stats := range AllStats(networkID) {
+		reasons = append(reasons, stats.Reason)
+	}
+	return reasons
+}
+
+func TestStation(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*citybikes.Station, *stationMapper.StationRecord)
+		want   []Reason
+		reject bool
+	}{
+		{
+			name:   "valid",
+			modify: func(*citybikes.Station, *stationMapper.StationRecord) {},
+		},
+		{
+			name:   "at 0,0",
+			modify: func(s *citybikes.Station, _ *stationMapper.StationRecord) { s.Latitude, s.Longitude = 0, 0 },
+			want:   []Reason{ReasonMissingCoordinates},
+			reject: true,
+		},
+		{
+			name:   "latitude beyond the pole",
+			modify: func(s *citybikes.Station, _ *stationMapper.StationRecord) { s.Latitude = 91 },
+			want:   []Reason{ReasonInvalidCoordinates},
+			reject: true,
+		},
+		{
+			name:   "in Lyon",
+			modify: func(s *citybikes.Station, _ *stationMapper.StationRecord) { s.Latitude, s.Longitude = 45.76, 4.83 },
+			want:   []Reason{ReasonOutsideNetworkArea},
+			reject: true,
+		},
+		{
+			name:   "negative count",
+			modify: func(s *citybikes.Station, _ *stationMapper.StationRecord) { s.FreeBikes = -1 },
+			want:   []Reason{ReasonNegativeCount},
+		},
+		{
+			name: "count beyond any real one",
+			modify: func(s *citybikes.Station, _ *stationMapper.StationRecord) {
+				slots := 2_000_000
+				s.Extra = &citybikes.StationExtra{Slots: &slots}
+			},
+			want:   []Reason{ReasonValueOutOfRange},
+			reject: true,
+		},
+		{
+			name:   "counts over capacity",
+			modify: func(_ *citybikes.Station, r *stationMapper.StationRecord) { r.NumEbikesAvailable = 1 },
+			want:   []Reason{ReasonCountsExceedCapacity},
+		},
+		{
+			name: "unparseable timestamp",
+			modify: func(s *citybikes.Station, r *stationMapper.StationRecord) {
+				s.Timestamp = "yesterday"
+				r.LastReportedSynthesized = true
+			},
+			want: []Reason{ReasonInvalidTimestamp},
+		},
+		{
+			name: "timestamp from the future",
+			modify: func(_ *citybikes.Station, r *stationMapper.StationRecord) {
+				r.LastReported = timestamps.Format(time.Now().Add(time.Hour))
+			},
+			want:   []Reason{ReasonFutureTimestamp},
+			reject: true,
+		},
+		{
+			name: "flagged and rejected at once",
+			modify: func(s *citybikes.Station, r *stationMapper.StationRecord) {
+				s.Latitude = 91
+				r.NumEbikesAvailable = 1
+			},
+			want:   []Reason{ReasonCountsExceedCapacity, ReasonInvalidCoordinates},
+			reject: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configure(t, nil)
+			SetArea("velib", &citybikes.Location{Latitude: 48.85, Longitude: 2.35})
+
+			station, record := validStation()
+			tt.modify(&station, record)
+			err := Station(station, record, "velib")
+
+			checkOutcome(t, err, tt.reject)
+			if got := reasonsOf("velib"); !slices.Equal(got, tt.want) {
+				t.Errorf("counted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVehicle(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(*citybikes.Vehicle, *vehicleMapper.VehicleRecord)
+		want   []Reason
+		reject bool
+	}{
+		{
+			name:   "valid",
+			modify: func(*citybikes.Vehicle, *vehicleMapper.VehicleRecord) {},
+		},
+		{
+			name:   "without coordinates",
+			modify: func(v *citybikes.Vehicle, _ *vehicleMapper.VehicleRecord) { v.Latitude, v.Longitude = nil, nil },
+		},
+		{
+			name: "at 0,0",
+			modify: func(v *citybikes.Vehicle, _ *vehicleMapper.VehicleRecord) {
+				*v.Latitude, *v.Longitude = 0, 0
+			},
+			want:   []Reason{ReasonMissingCoordinates},
+			reject: true,
+		},
+		{
+			name:   "battery over 100%",
+			modify: func(_ *citybikes.Vehicle, r *vehicleMapper.VehicleRecord) { *r.BatteryLevel = 150 },
+			want:   []Reason{ReasonInvalidBattery},
+		},
+		{
+			name:   "battery beyond any real level",
+			modify: func(_ *citybikes.Vehicle, r *vehicleMapper.VehicleRecord) { *r.BatteryLevel = -2_000_000 },
+			want:   []Reason{ReasonValueOutOfRange},
+			reject: true,
+		},
+		{
+			name:   "unparseable timestamp",
+			modify: func(_ *citybikes.Vehicle, r *vehicleMapper.VehicleRecord) { r.LastReported = nil },
+			want:   []Reason{ReasonInvalidTimestamp},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configure(t, nil)
+
+			vehicle, record := validVehicle()
+			tt.modify(&vehicle, record)
+			err := Vehicle(vehicle, record, "velib")
+
+			checkOutcome(t, err, tt.reject)
+			if got := reasonsOf("velib"); !slices.Equal(got, tt.want) {
+				t.Errorf("counted %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func checkOutcome(t *testing.T, err error, reject bool) {
+	t.Helper()
+
+	var rejection *Rejection
+	switch {
+	case reject && !errors.As(err, &rejection):
+		t.Errorf("error %v, want a rejection", err)
+	case !reject && err != nil:
+		t.Errorf("rejected with %v, want the record to pass", err)
+	}
+}
+
+func TestActions(t *testing.T) {
+	configure(t, map[string]string{
+		string(ReasonNegativeCount):      string(ActionReject),
+		string(ReasonInvalidCoordinates): string(ActionFlag),
+	})
+
+	station, record := validStation()
+	station.FreeBikes = -1
+	if err := Station(station, record, "velib"); err == nil {
+		t.Error("a negative count passed after its action was set to reject")
+	}
+
+	station, record = validStation()
+	station.Latitude = 91
+	if err := Station(station, record, "velib"); err != nil {
+		t.Errorf("invalid coordinates rejected with %v after their action was set to flag", err)
+	}
+
+	for _, stats := range AllStats("") {
+		want := defaultActions[stats.Reason]
+		if stats.Reason == ReasonNegativeCount {
+			want = ActionReject
+		} else if stats.Reason == ReasonInvalidCoordinates {
+			want = ActionFlag
+		}
+		if stats.Action != want {
+			t.Errorf("%s reported with action %s, want %s", stats.Reason, stats.Action, want)
+		}
+	}
+
+	// An unknown reason code leaves the actions in effect
+	cfg := config.Defaults().Validation
+	cfg.Actions = map[string]string{"negative_counts": string(ActionFlag)}
+	if err := Configure(cfg); err == nil {
+		t.Error("unknown reason code accepted")
+	}
+	station, record = validStation()
+	station.FreeBikes = -1
+	if err := Station(station, record, "velib"); err == nil {
+		t.Error("the actions in effect were replaced by invalid ones")
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	configure(t, nil)
+	cfg := config.Defaults().Validation
+	cfg.Enabled = false
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	station, record := validStation()
+	station.Latitude = 91
+	if err := Station(station, record, "velib"); err != nil {
+		t.Errorf("rejected with %v while disabled", err)
+	}
+	if stats := AllStats(""); len(stats) != 0 {
+		t.Errorf("counted %v while disabled", stats)
+	}
+}
+
+func TestAllStats(t *testing.T) {
+	configure(t, nil)
+
+	fail := func(networkID string, times int, modify func(*citybikes.Station)) {
+		for range times {
+			station, record := validStation()
+			modify(&station)
+			_ = Station(station, record, networkID)
+		}
+	}
+	negative := func(s *citybikes.Station) { s.FreeBikes = -1 }
+	fail("velib", 2, negative)
+	fail("bicing", 3, negative)
+	fail("bicing", 1, func(s *citybikes.Station) { s.Latitude = 91 })
+	vehicle, record := validVehicle()
+	*record.BatteryLevel = 150
+	_ = Vehicle(vehicle, record, "velib")
+
+	tests := []struct {
+		networkID string
+		want      []Stats
+	}{
+		{
+			networkID: "",
+			want: []Stats{
+				{Entity: "station", Reason: ReasonInvalidCoordinates, Action: ActionReject, Count: 1, Networks: map[string]uint64{"bicing": 1}},
+				{Entity: "station", Reason: ReasonNegativeCount, Action: ActionFlag, Count: 5, Networks: map[string]uint64{"velib": 2, "bicing": 3}},
+				{Entity: "vehicle", Reason: ReasonInvalidBattery, Action: ActionFlag, Count: 1, Networks: map[string]uint64{"velib": 1}},
+			},
+		},
+		{
+			networkID: "velib",
+			want: []Stats{
+				{Entity: "station", Reason: ReasonNegativeCount, Action: ActionFlag, Count: 2, Networks: map[string]uint64{"velib": 2}},
+				{Entity: "vehicle", Reason: ReasonInvalidBattery, Action: ActionFlag, Count: 1, Networks: map[string]uint64{"velib": 1}},
+			},
+		},
+		{
+			networkID: "unknown",
+		},
+	}
+
+	for _, tt := range tests {
+		got := AllStats(tt.networkID)
+		if !slices.EqualFunc(got, tt.want, func(a, b Stats) bool {
+			return a.Entity == b.Entity && a.Reason == b.Reason && a.Action == b.Action && a.Count == b.Count && maps.Equal(a.Networks, b.Networks)
+		}) {
+			t.Errorf("AllStats(%q) = %+v, want %+v", tt.networkID, got, tt.want)
+		}
+	}
+}
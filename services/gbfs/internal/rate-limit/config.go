@@ -0,0 +1,5 @@
+package ratelimit
+
+import "gbfs-service/internal/logging"
+
+var logger = logging.For("rate-limit")
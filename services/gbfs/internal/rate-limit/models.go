@@ -0,0 +1,41 @@
+package ratelimit
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Limiter is a token bucket for one upstream host. Tokens refill at the
+// configured hourly rate up to the burst size; a 429 or Retry-After from the
+// host empties the bucket and blocks it until the server says to retry.
+type Limiter struct {
+	host string
+
+	mu           sync.Mutex
+	perSecond    float64
+	burst        float64
+	retryAfter   time.Duration
+	tokens       float64
+	last         time.Time
+	blockedUntil time.Time
+	requests     int
+	throttled    int
+}
+
+// Budget is a point-in-time view of a host's limiter
+type Budget struct {
+	Host            string     `json:"host"`
+	Remaining       int        `json:"remaining"` // Requests that can be made right now
+	Burst           int        `json:"burst"`
+	RequestsPerHour int        `json:"requests_per_hour"`
+	Requests        int        `json:"requests"`  // Requests let through since startup
+	Throttled       int        `json:"throttled"` // 429 responses seen since startup
+	BlockedUntil    *time.Time `json:"blocked_until,omitempty"`
+}
+
+// Transport is an http.RoundTripper that waits for the request host's
+// budget before sending and feeds throttling responses back into it
+type Transport struct {
+	Base http.RoundTripper
+}
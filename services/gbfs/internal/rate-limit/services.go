@@ -0,0 +1,264 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"gbfs-service/internal/config"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// ErrBudgetExhausted is returned when a request could not get a token
+// before its context deadline
+var ErrBudgetExhausted = errors.New("upstream request budget exhausted")
+
+// acquiredKey marks a request context whose caller already waited for a token
+type acquiredKey struct{}
+
+// WithAcquired marks ctx as already holding a token for the host it is used
+// with, so the Transport doesn't wait a second time. Callers use it to take
+// a token before starting a request timeout.
+func WithAcquired(ctx context.Context) context.Context {
+	return context.WithValue(ctx, acquiredKey{}, true)
+}
+
+// registry holds the process-wide limiter of every host seen so far
+var registry = struct {
+	sync.Mutex
+	cfg      config.RateLimitConfig
+	limiters map[string]*Limiter
+}{
+	cfg:      config.Defaults().RateLimit,
+	limiters: make(map[string]*Limiter),
+}
+
+// Configure sets the budget for every host, including limiters that already
+// exist. It can be called again on config reload.
+func Configure(cfg config.RateLimitConfig) {
+	registry.Lock()
+	defer registry.Unlock()
+
+	registry.cfg = cfg
+	for _, l := range registry.limiters {
+		l.configure(cfg)
+	}
+	logger.Info("upstream rate limit configured",
+		"requests_per_hour", cfg.RequestsPerHour,
+		"burst", cfg.Burst,
+	)
+}
+
+// For returns the limiter for host, creating it with a full bucket
+func For(host string) *Limiter {
+	host = strings.ToLower(host)
+
+	registry.Lock()
+	defer registry.Unlock()
+
+	if l, ok := registry.limiters[host]; ok {
+		return l
+	}
+	l := &Limiter{host: host, last: time.Now()}
+	l.configure(registry.cfg)
+	l.tokens = l.burst
+	registry.limiters[host] = l
+	return l
+}
+
+// Budgets returns the current budget of every host, sorted by host
+func Budgets() []Budget {
+	registry.Lock()
+	limiters := make([]*Limiter, 0, len(registry.limiters))
+	for _, l := range registry.limiters {
+		limiters = append(limiters, l)
+	}
+	registry.Unlock()
+
+	budgets := make([]Budget, 0, len(limiters))
+	for _, l := range limiters {
+		budgets = append(budgets, l.Budget())
+	}
+	slices.SortFunc(budgets, func(a, b Budget) int { return strings.Compare(a.Host, b.Host) })
+	return budgets
+}
+
+// NewClient returns an HTTP client whose requests all draw from the shared
+// per-host budgets
+func NewClient(timeout time.Duration) *http.Client {
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: &Transport{Base: http.DefaultTransport},
+	}
+}
+
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	limiter := For(req.URL.Host)
+	if acquired, _ := req.Context().Value(acquiredKey{}).(bool); !acquired {
+		if err := limiter.Wait(req.Context()); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.Base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	limiter.Observe(resp)
+	return resp, nil
+}
+
+func (l *Limiter) configure(cfg config.RateLimitConfig) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(time.Now())
+	l.perSecond = float64(cfg.RequestsPerHour) / 3600
+	l.burst = float64(cfg.Burst)
+	l.retryAfter = cfg.RetryAfter
+	l.tokens = min(l.tokens, l.burst)
+}
+
+// refill adds the tokens earned since the last call; callers hold mu.
+// Nothing accrues while the host has told us to back off.
+func (l *Limiter) refill(now time.Time) {
+	from := l.last
+	if from.Before(l.blockedUntil) {
+		from = l.blockedUntil
+	}
+	if elapsed := now.Sub(from).Seconds(); elapsed > 0 {
+		l.tokens = min(l.burst, l.tokens+elapsed*l.perSecond)
+		l.last = now
+	}
+}
+
+// reserve takes a token if one is available, otherwise reports how long
+// until one will be
+func (l *Limiter) reserve(now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill(now)
+	if now.Before(l.blockedUntil) {
+		return l.blockedUntil.Sub(now)
+	}
+	if l.tokens >= 1 {
+		l.tokens--
+		l.requests++
+		return 0
+	}
+	return time.Duration(math.Ceil((1 - l.tokens) / l.perSecond * float64(time.Second)))
+}
+
+// Wait blocks until a request to the host may be made. It fails straight
+// away if the wait would outlast ctx's deadline.
+func (l *Limiter) Wait(ctx context.Context) error {
+	var waited time.Duration
+	for {
+		now := time.Now()
+		delay := l.reserve(now)
+		if delay == 0 {
+			if waited > 0 {
+				trace.SpanFromContext(ctx).AddEvent("rate_limit.wait", trace.WithAttributes(
+					attribute.String("server.address", l.host),
+					attribute.Int64("wait_ms", waited.Milliseconds()),
+				))
+			}
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && now.Add(delay).After(deadline) {
+			return fmt.Errorf("%w for %s: next request allowed in %s", ErrBudgetExhausted, l.host, delay.Round(time.Second))
+		}
+
+		logger.DebugContext(ctx, "waiting for upstream budget", "host", l.host, "delay", delay)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+		waited += delay
+	}
+}
+
+// Observe applies a response's throttling signals: a 429 empties the bucket
+// and blocks the host for Retry-After (or the configured back-off), and a
+// Retry-After on any other response blocks it without counting as throttling
+func (l *Limiter) Observe(resp *http.Response) {
+	now := time.Now()
+	retryAfter, hasRetryAfter := parseRetryAfter(resp.Header.Get("Retry-After"), now)
+	if resp.StatusCode != http.StatusTooManyRequests && !hasRetryAfter {
+		return
+	}
+
+	l.mu.Lock()
+	if !hasRetryAfter {
+		retryAfter = l.retryAfter
+	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		l.throttled++
+		l.tokens = 0
+	}
+	if until := now.Add(retryAfter); until.After(l.blockedUntil) {
+		l.blockedUntil = until
+	}
+	blockedUntil := l.blockedUntil
+	l.mu.Unlock()
+
+	logger.Warn("upstream asked us to back off",
+		"host", l.host,
+		"status", resp.StatusCode,
+		"retry_after", retryAfter,
+		"blocked_until", blockedUntil,
+	)
+}
+
+// Budget returns the limiter's current state
+func (l *Limiter) Budget() Budget {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	l.refill(now)
+
+	budget := Budget{
+		Host:            l.host,
+		Remaining:       int(l.tokens),
+		Burst:           int(l.burst),
+		RequestsPerHour: int(math.Round(l.perSecond * 3600)),
+		Requests:        l.requests,
+		Throttled:       l.throttled,
+	}
+	if now.Before(l.blockedUntil) {
+		until := l.blockedUntil
+		budget.Remaining = 0
+		budget.BlockedUntil = &until
+	}
+	return budget
+}
+
+// parseRetryAfter reads a Retry-After header given either as seconds or as
+// an HTTP date
+func parseRetryAfter(value string, now time.Time) (time.Duration, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(date.Sub(now), 0), true
+	}
+	return 0, false
+}
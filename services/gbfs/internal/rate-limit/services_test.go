@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"gbfs-service/internal/config"
+	"testing"
+	"time"
+)
+
+// TestDefaultBudgetWithinHourlyLimit drains a fresh bucket as fast as it
+// allows for a simulated hour and more; no rolling hour may see more than
+// citybik.es' 300 requests, a full bucket included.
+func TestDefaultBudgetWithinHourlyLimit(t *testing.T) {
+	const limit = 300
+	start := time.Date(2026, 1, 1, 8, 0, 0, 0, time.UTC)
+
+	l := &Limiter{host: "api.citybik.es"}
+	l.configure(config.Defaults().RateLimit)
+	l.tokens, l.last = l.burst, start // For starts every bucket full
+
+	var granted []time.Time
+	for now := start; now.Before(start.Add(3 * time.Hour)); now = now.Add(time.Second) {
+		for l.reserve(now) == 0 {
+			granted = append(granted, now)
+		}
+	}
+
+	// Every window starting at a request is the busiest one ending there
+	for i, from := range granted {
+		in := 0
+		for _, at := range granted[i:] {
+			if at.Sub(from) >= time.Hour {
+				break
+			}
+			in++
+		}
+		if in > limit {
+			t.Fatalf("%d requests in the hour from %s, limit is %d", in, from.Format(time.TimeOnly), limit)
+		}
+	}
+
+	// The budget is still used, not just kept under the limit
+	firstHour := 0
+	for _, at := range granted {
+		if at.Sub(start) < time.Hour {
+			firstHour++
+		}
+	}
+	if firstHour < limit-10 {
+		t.Errorf("only %d requests in the first hour, want close to %d", firstHour, limit)
+	}
+}
+
+func TestValidateRejectsBurstOverLimit(t *testing.T) {
+	cfg := config.Defaults()
+	cfg.Supabase.URL, cfg.Supabase.Key = "https://example.supabase.co", "key"
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("defaults don't validate: %v", err)
+	}
+
+	cfg.RateLimit.RequestsPerHour = 300
+	cfg.RateLimit.Burst = 5
+	if err := cfg.Validate(); err == nil {
+		t.Error("requests_per_hour 300 with burst 5 validated, it allows 305 requests in an hour")
+	}
+}
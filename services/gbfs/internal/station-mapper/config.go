@@ -1,13 +1,11 @@
 package stationMapper
 
 import (
-	"gbfs-service/internal/envkeys"
+	"gbfs-service/internal/logging"
+	"time"
 )
 
-type stationMapperConfig struct {
-	verbose               bool
-}
+var logger = logging.For("station-mapper")
 
-var Config = stationMapperConfig{
-	verbose:               envkeys.Environment.Verbose,
-}
+// now is the clock synthesized timestamps are taken from; tests pin it
+var now = time.Now
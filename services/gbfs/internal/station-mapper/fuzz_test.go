@@ -0,0 +1,102 @@
+package stationMapper_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"gbfs-service/internal/timestamps"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FuzzMapStationData maps arbitrary stations. Mapping may fail, but it must
+// not panic, and a station that maps and passes validation must be one the
+// database accepts.
+func FuzzMapStationData(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".golden.json") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, "bicing")
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, network string) {
+		var station citybikes.Station
+		if err := json.Unmarshal(data, &station); err != nil {
+			return
+		}
+
+		record, err := stationMapper.MapStationData(station, network)
+		if err != nil {
+			return
+		}
+		if err := mappingvalidation.Station(station, record, network); err != nil {
+			return
+		}
+
+		checkStation(t, record)
+	})
+}
+
+// checkStation fails unless the record satisfies the station table
+func checkStation(t *testing.T, record *stationMapper.StationRecord) {
+	t.Helper()
+
+	if _, err := uuid.Parse(record.ID); err != nil {
+		t.Errorf("id %q: %v", record.ID, err)
+	}
+	if _, err := uuid.Parse(record.NetworkID); err != nil {
+		t.Errorf("network_id %q: %v", record.NetworkID, err)
+	}
+
+	var longitude, latitude float64
+	if _, err := fmt.Sscanf(record.Location, "POINT(%f %f)", &longitude, &latitude); err != nil {
+		t.Errorf("location %q: %v", record.Location, err)
+	}
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 || (latitude == 0 && longitude == 0) {
+		t.Errorf("location %q is not a real place", record.Location)
+	}
+
+	for name, count := range map[string]int{
+		"capacity":             record.Capacity,
+		"num_docks_available":  record.NumDocksAvailable,
+		"num_ebikes_available": record.NumEbikesAvailable,
+		"num_bikes_available":  record.NumBikesAvailable,
+	} {
+		if count < 0 || count > math.MaxInt32 {
+			t.Errorf("%s doesn't fit the column (%d)", name, count)
+		}
+	}
+
+	reported, err := timestamps.Parse(record.LastReported)
+	if err != nil {
+		t.Errorf("last_reported: %v", err)
+	}
+	if limit := time.Now().Add(config.Defaults().Validation.MaxClockSkew + time.Minute); reported.After(limit) {
+		t.Errorf("last_reported %s is in the future", record.LastReported)
+	}
+
+	if _, err := json.Marshal(record); err != nil {
+		t.Errorf("record doesn't encode: %v", err)
+	}
+	if !json.Valid(record.RawData) {
+		t.Errorf("raw_data is not JSON: %s", record.RawData)
+	}
+}
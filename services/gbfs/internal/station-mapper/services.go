@@ -1,10 +1,13 @@
 package stationMapper
 
 import (
+	"encoding/json"
 	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/logging"
+	mappingrules "gbfs-service/internal/mapping-rules"
+	"gbfs-service/internal/timestamps"
 	"gbfs-service/internal/uuidfy"
-	"log"
-	"time"
 )
 
 // old station record
@@ -57,12 +60,14 @@ import (
 // ]);
 
 type StationRecord struct {
-	ID                 string  `json:"id"`                   // UUID (stored as 15-char string from uuidfy)
+	ID                 string  `json:"id"`                   // UUIDv5 from uuidfy.Station
 	CreatedAt          *string `json:"created_at,omitempty"` // timestamptz, auto-populated
 	FetchedAt          *string `json:"fetched_at,omitempty"` // timestamptz, auto-populated
 	NetworkID          string  `json:"network_id"`           // UUID, references bikeshare.network
 	Name               string  `json:"name"`                 // NOT NULL
 	Location           string  `json:"location"`             // gis.geography as WKT: "POINT(lon lat)"
+	Latitude           float64 `json:"-"`                    // The coordinates in Location
+	Longitude          float64 `json:"-"`
 	Address            *string `json:"address"`              // nullable - must be included even if nil
 	Capacity           int     `json:"capacity"`             // NOT NULL
 	NumDocksAvailable  int     `json:"num_docks_available"`  // NOT NULL
@@ -72,49 +77,80 @@ type StationRecord struct {
 	IsRenting          *bool   `json:"is_renting"`           // nullable - must be included even if nil
 	IsReturning        *bool   `json:"is_returning"`         // nullable - must be included even if nil
 	IsVirtual          *bool   `json:"is_virtual"`           // nullable - must be included even if nil, virtual/floating station?
-	LastReported       string  `json:"last_reported"`        // timestamptz NOT NULL
+	LastReported       string  `json:"last_reported"`        // timestamptz NOT NULL, UTC
+	// LastReportedSynthesized is set when the source sent no usable timestamp
+	// and last_reported is when the station was fetched
+	LastReportedSynthesized bool    `json:"last_reported_synthesized"` // NOT NULL
+	RemovedAt               *string `json:"removed_at"`                // nullable - always written as nil so a station seen again is restored
 	// VehicleTypesAvailable     map[string]interface{} `json:"vehicle_types_available"`     // jsonb NOT NULL
-	RawData map[string]interface{} `json:"raw_data"` // jsonb NOT NULL
+	RawData json.RawMessage `json:"raw_data"` // jsonb NOT NULL, the station as received
 }
 
-// extractLastReported parses the timestamp from station data and formats it as RFC3339
-// Returns current time if timestamp is missing or invalid
-func extractLastReported(stationData map[string]any) string {
-	timestamp, _ := stationData["timestamp"].(string)
+// RecordID is the mapped station ID
+func (s *StationRecord) RecordID() string {
+	return s.ID
+}
 
-	if timestamp != "" {
-		if parsed, err := time.Parse(time.RFC3339, timestamp); err == nil {
-			return parsed.Format(time.RFC3339)
-		}
+// Compared returns the fields change detection compares. Everything else
+// (raw_data, last_reported, fetched_at) is only rewritten on the heartbeat.
+func (s *StationRecord) Compared() any {
+	return []any{
+		s.Name, s.Location, s.Address, s.Capacity,
+		s.NumBikesAvailable, s.NumEbikesAvailable, s.NumDocksAvailable,
+		s.IsOperational, s.IsRenting, s.IsReturning, s.IsVirtual,
 	}
+}
 
-	// If parsing fails or no timestamp provided, use current time
-	return time.Now().Format(time.RFC3339)
+// SetFetchedAt stamps when the station was fetched
+func (s *StationRecord) SetFetchedAt(fetchedAt string) {
+	s.FetchedAt = &fetchedAt
+}
+
+// extractLastReported normalizes the timestamp from station data to UTC
+// Uses the current time, marked synthesized, if the timestamp is missing or invalid
+func extractLastReported(station citybikes.Station) timestamps.Timestamp {
+	return timestamps.Resolve(string(station.Timestamp), now())
 }
 
 // extractAddress retrieves the optional address field from extra data
-func extractAddress(extra map[string]any) *string {
-	if extra == nil {
+func extractAddress(extra *citybikes.StationExtra) *string {
+	if extra == nil || extra.Address == "" {
 		return nil
 	}
 
-	if addr, ok := extra["address"].(string); ok && addr != "" {
-		return &addr
-	}
-
-	return nil
+	address := extra.Address
+	return &address
 }
 
 // extractLocation formats latitude and longitude as PostGIS geography WKT format
-func extractLocation(stationData map[string]any) string {
-	latitude, _ := stationData["latitude"].(float64)
-	longitude, _ := stationData["longitude"].(float64)
-	return fmt.Sprintf("POINT(%f %f)", longitude, latitude)
+func extractLocation(station citybikes.Station) string {
+	return fmt.Sprintf("POINT(%f %f)", station.Longitude, station.Latitude)
+}
+
+// Built-in meanings of extra.status, used unless the network's rule maps
+// statuses itself
+var (
+	operationalStatuses = map[string]bool{"closed": false, "offline": false}
+	flagStatuses        = map[string]bool{
+		"closed": false, "offline": false, "maintenance": false,
+		"open": true, "active": true,
+	}
+)
+
+// statusInService reads extra.status through the rule's status mapping, or
+// the built-in one if the rule has none. ok is false if the status decides
+// nothing.
+func statusInService(status string, rule mappingrules.StationRule, builtin map[string]bool) (inService, ok bool) {
+	if len(rule.Status) > 0 {
+		return rule.InService(status)
+	}
+	inService, ok = builtin[status]
+	return inService, ok
 }
 
 // extractIsOperational determines if the station is operational
 // A station is operational if it has capacity and is not explicitly marked as non-operational
-func extractIsOperational(capacity int, freeBikes float64, extra map[string]any) bool {
+func extractIsOperational(capacity int, freeBikes int, extra *citybikes.StationExtra, rule mappingrules.StationRule) bool {
 	// If there's no capacity, it's not operational
 	if capacity == 0 {
 		return false
@@ -123,28 +159,18 @@ func extractIsOperational(capacity int, freeBikes float64, extra map[string]any)
 	// Check explicit operational status in extra data
 	if extra != nil {
 		// Check for explicit operational field
-		if operational, ok := extra["operational"].(bool); ok {
-			return operational
-		}
-		// Handle numeric boolean
-		if operational, ok := extra["operational"].(float64); ok {
-			return operational != 0
+		if extra.Operational != nil {
+			return bool(*extra.Operational)
 		}
 
 		// Check for online field
-		if online, ok := extra["online"].(bool); ok {
-			return online
-		}
-		// Handle numeric boolean
-		if online, ok := extra["online"].(float64); ok {
-			return online != 0
+		if extra.Online != nil {
+			return bool(*extra.Online)
 		}
 
 		// Check status field
-		if status, ok := extra["status"].(string); ok {
-			if status == "closed" || status == "offline" {
-				return false
-			}
+		if inService, ok := statusInService(extra.Status, rule, operationalStatuses); ok && !inService {
+			return false
 		}
 	}
 
@@ -154,10 +180,33 @@ func extractIsOperational(capacity int, freeBikes float64, extra map[string]any)
 	return capacity > 0 && freeBikes >= 0
 }
 
+// extractFlag resolves an explicit renting or returning flag, falling back
+// to the status and online fields. Returns nil if none of them decides it.
+func extractFlag(flag *citybikes.Flag, extra *citybikes.StationExtra, rule mappingrules.StationRule) *bool {
+	// Check for the explicit field
+	if flag != nil {
+		value := bool(*flag)
+		return &value
+	}
+
+	// Check for status field that might indicate the capability
+	if inService, ok := statusInService(extra.Status, rule, flagStatuses); ok {
+		return &inService
+	}
+
+	// Check for online field as an indicator
+	if extra.Online != nil && !*extra.Online {
+		falseVal := false
+		return &falseVal
+	}
+
+	return nil
+}
+
 // extractIsRenting retrieves the renting status from extra data
 // Returns nil if not explicitly set, allowing database default
 // Infers from operational status and bike availability if not explicit
-func extractIsRenting(extra map[string]any, isOperational bool, freeBikes float64) *bool {
+func extractIsRenting(extra *citybikes.StationExtra, isOperational bool, freeBikes int, rule mappingrules.StationRule) *bool {
 	// If not operational, explicitly set to false
 	if !isOperational {
 		falseVal := false
@@ -165,68 +214,30 @@ func extractIsRenting(extra map[string]any, isOperational bool, freeBikes float6
 	}
 
 	if extra != nil {
-		// Check for explicit renting field
-		if renting, ok := extra["renting"].(bool); ok {
-			return &renting
-		}
-
-		// Handle numeric boolean (1/0)
-		if renting, ok := extra["renting"].(float64); ok {
-			val := renting != 0
-			return &val
-		}
-
-		// Check for status field that might indicate renting capability
-		if status, ok := extra["status"].(string); ok {
-			if status == "closed" || status == "offline" || status == "maintenance" {
-				falseVal := false
-				return &falseVal
-			}
-			if status == "open" || status == "active" {
-				trueVal := true
-				return &trueVal
-			}
-		}
-
-		// Check for online field as an indicator
-		if online, ok := extra["online"].(bool); ok {
-			if !online {
-				falseVal := false
-				return &falseVal
-			}
-		}
-		if online, ok := extra["online"].(float64); ok {
-			if online == 0 {
-				falseVal := false
-				return &falseVal
-			}
+		if renting := extractFlag(extra.Renting, extra, rule); renting != nil {
+			return renting
 		}
 	}
 
 	// Infer from bike availability: if operational and has bikes, likely allows renting
-	if isOperational && freeBikes > 0 {
+	if freeBikes > 0 {
 		trueVal := true
 		return &trueVal
 	}
 
-	if isOperational && freeBikes == 0 {
+	if freeBikes == 0 {
 		falseVal := false
 		return &falseVal
 	}
 
 	// If operational but no bikes, we can't definitively say - return nil for database default
-	if isOperational {
-		return nil
-	}
-
-	falseVal := false
-	return &falseVal
+	return nil
 }
 
 // extractIsReturning retrieves the returning status from extra data
 // Returns nil if not explicitly set, allowing database default
 // Infers from operational status and dock availability if not explicit
-func extractIsReturning(extra map[string]any, isOperational bool, emptySlots float64, isVirtual *bool) *bool {
+func extractIsReturning(extra *citybikes.StationExtra, isOperational bool, emptySlots int, isVirtual *bool, rule mappingrules.StationRule) *bool {
 	// If not operational, explicitly set to false
 	if !isOperational {
 		falseVal := false
@@ -234,84 +245,47 @@ func extractIsReturning(extra map[string]any, isOperational bool, emptySlots flo
 	}
 
 	if extra != nil {
-		// Check for explicit returning field
-		if returning, ok := extra["returning"].(bool); ok {
-			return &returning
-		}
-
-		// Handle numeric boolean (1/0)
-		if returning, ok := extra["returning"].(float64); ok {
-			val := returning != 0
-			return &val
-		}
-
-		// Check for status field that might indicate returning capability
-		if status, ok := extra["status"].(string); ok {
-			if status == "closed" || status == "offline" || status == "maintenance" {
-				falseVal := false
-				return &falseVal
-			}
-			if status == "open" || status == "active" {
-				trueVal := true
-				return &trueVal
-			}
-		}
-
-		// Check for online field as an indicator
-		if online, ok := extra["online"].(bool); ok {
-			if !online {
-				falseVal := false
-				return &falseVal
-			}
-		}
-		if online, ok := extra["online"].(float64); ok {
-			if online == 0 {
-				falseVal := false
-				return &falseVal
-			}
+		if returning := extractFlag(extra.Returning, extra, rule); returning != nil {
+			return returning
 		}
 	}
 
 	// Virtual stations typically allow returns
-	if isVirtual != nil && *isVirtual && isOperational {
+	if isVirtual != nil && *isVirtual {
 		trueVal := true
 		return &trueVal
 	}
 
 	// Infer from dock availability: if operational and has empty slots, likely allows returning
-	if isOperational && emptySlots > 0 {
+	if emptySlots > 0 {
 		trueVal := true
 		return &trueVal
 	}
 
 	// If operational but no empty slots, we can't definitively say - return nil for database default
-	if isOperational {
-		return nil
-	}
-
-	falseVal := false
-	return &falseVal
+	return nil
 }
 
 // extractIsVirtual determines if the station is virtual/floating
-func extractIsVirtual(extra map[string]any) *bool {
+func extractIsVirtual(extra *citybikes.StationExtra, rule mappingrules.StationRule) *bool {
+	// The network's rule overrides whatever the station says
+	if rule.Virtual != nil {
+		virtual := *rule.Virtual
+		return &virtual
+	}
+
 	if extra == nil {
 		return nil
 	}
 
 	// Check for explicit virtual field
-	if virtual, ok := extra["virtual"].(bool); ok {
+	if extra.Virtual != nil {
+		virtual := bool(*extra.Virtual)
 		return &virtual
 	}
 
-	// Handle numeric boolean
-	if virtual, ok := extra["virtual"].(float64); ok {
-		val := virtual != 0
-		return &val
-	}
-
 	// Check alternative field names
-	if uid, ok := extra["uid"].(string); ok && uid == "virtual" {
+	if extra.UID == "virtual" {
 		trueVal := true
 		return &trueVal
 	}
@@ -320,179 +294,163 @@ func extractIsVirtual(extra map[string]any) *bool {
 	return &falseVal
 }
 
+// extractSlots returns extra.slots if it is positive
+func extractSlots(extra *citybikes.StationExtra) (int, bool) {
+	if extra != nil && extra.Slots != nil && *extra.Slots > 0 {
+		return *extra.Slots, true
+	}
+	return 0, false
+}
+
 // extractCapacity calculates station capacity from available data
 // For virtual stations (null empty_slots), uses slots from extra or just free bikes
-func extractCapacity(freeBikes, emptySlots float64, extra map[string]any, isVirtual *bool) int {
+func extractCapacity(freeBikes, emptySlots int, extra *citybikes.StationExtra, isVirtual *bool, source mappingrules.Capacity) int {
+	// A source picked by the network's rule comes first
+	switch source {
+	case mappingrules.CapacityDocks:
+		return max(freeBikes, 0) + max(emptySlots, 0)
+	case mappingrules.CapacityBikes:
+		return max(freeBikes, 0)
+	case mappingrules.CapacitySlots:
+		if slots, ok := extractSlots(extra); ok {
+			return slots
+		}
+	}
+
 	// Check if this is a virtual station with null empty_slots
 	virtualStation := isVirtual != nil && *isVirtual
 
 	// For virtual stations, emptySlots might be intentionally 0/null
 	if virtualStation {
 		// Try to get from extra.slots first
-		if extra != nil {
-			if slots, ok := extra["slots"].(float64); ok && slots > 0 {
-				return int(slots)
-			}
+		if slots, ok := extractSlots(extra); ok {
+			return slots
 		}
 		// For virtual stations, capacity equals free bikes
 		if freeBikes > 0 {
-			return int(freeBikes)
+			return freeBikes
 		}
 		return 0
 	}
 
 	// For regular stations, calculate from available data
 	if emptySlots >= 0 && freeBikes >= 0 {
-		return int(emptySlots + freeBikes)
+		return emptySlots + freeBikes
 	}
 
 	// Try to get from extra.slots
-	if extra != nil {
-		if slots, ok := extra["slots"].(float64); ok && slots > 0 {
-			return int(slots)
-		}
+	if slots, ok := extractSlots(extra); ok {
+		return slots
 	}
 
 	// If still 0, set to at least the number of available bikes
 	if freeBikes > 0 {
-		return int(freeBikes)
+		return freeBikes
 	}
 
 	return 0
 }
 
 // extractNumEbikesAvailable counts the number of available e-bikes
-func extractNumEbikesAvailable(extra map[string]any) int {
-	if extra == nil {
-		return 0
-	}
-
-	if ebikes, ok := extra["ebikes"].(float64); ok && ebikes >= 0 {
-		return int(ebikes)
+func extractNumEbikesAvailable(extra *citybikes.StationExtra) int {
+	if extra != nil && extra.Ebikes != nil && *extra.Ebikes >= 0 {
+		return *extra.Ebikes
 	}
 
 	return 0
 }
 
 // extractNumRegularBikesAvailable counts the number of available regular bikes
-func extractNumRegularBikesAvailable(freeBikes float64, extra map[string]any) int {
-	// Start with total free bikes
-	totalBikes := int(freeBikes)
-
+func extractNumRegularBikesAvailable(freeBikes int, extra *citybikes.StationExtra) int {
 	if extra == nil {
 		// No extra data, all bikes are regular bikes
-		return totalBikes
+		return freeBikes
 	}
 
 	// If we have explicit normal_bikes count, use it
-	if normalBikes, ok := extra["normal_bikes"].(float64); ok && normalBikes >= 0 {
-		return int(normalBikes)
-	}
-
-	// Otherwise, calculate as total bikes minus e-bikes
-	numEbikes := extractNumEbikesAvailable(extra)
-	regularBikes := totalBikes - numEbikes
-
-	// Ensure we don't return negative values
-	if regularBikes < 0 {
-		return 0
+	if extra.NormalBikes != nil && *extra.NormalBikes >= 0 {
+		return *extra.NormalBikes
 	}
 
-	return regularBikes
+	// Otherwise, calculate as total bikes minus e-bikes, never negative
+	return max(freeBikes-extractNumEbikesAvailable(extra), 0)
 }
 
-// MapStationData transforms WebSocket station data to Supabase bikeshare.station format
-func MapStationData(stationData map[string]any, networkName string) (map[string]any, error) {
-	// Generate station ID using uuidfy (converts to 15-char string that will be used as UUID)
-	stationId, ok := stationData["id"].(string)
-	if !ok {
+// MapStationData transforms citybik.es station data to Supabase bikeshare.station format
+func MapStationData(station citybikes.Station, networkName string) (*StationRecord, error) {
+	// Generate station ID, scoped by its network
+	if station.ID == "" {
 		return nil, fmt.Errorf("station id not found or not a string")
 	}
 
-	mappedStationId, err := uuidfy.UUIDfy(stationId)
+	// Apply the network's field remapping before reading anything else
+	rule := mappingrules.For(networkName).Station
+	station = rule.Remap(station)
+
+	mappedStationId, err := uuidfy.Station(networkName, station.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate station ID: %v", err)
 	}
 
 	// Generate network ID using uuidfy
-	networkId, err := uuidfy.UUIDfy(networkName)
+	networkId, err := uuidfy.Network(networkName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate network ID: %v", err)
 	}
 
-	// Extract basic station info
-	name, _ := stationData["name"].(string)
-	freeBikes, _ := stationData["free_bikes"].(float64)
-	emptySlots, _ := stationData["empty_slots"].(float64)
-
-	// Extract extra data
-	extra, _ := stationData["extra"].(map[string]any)
+	freeBikes := station.FreeBikes
+	emptySlots := station.EmptySlots
+	extra := station.Extra
 
 	// Extract virtual status first as it affects capacity calculation
-	isVirtual := extractIsVirtual(extra)
-
-	// Use helper functions to extract processed fields
-	location := extractLocation(stationData)
-	lastReported := extractLastReported(stationData)
-	address := extractAddress(extra)
+	isVirtual := extractIsVirtual(extra, rule)
 
 	// Calculate capacity (depends on isVirtual)
-	capacity := extractCapacity(freeBikes, emptySlots, extra, isVirtual)
+	capacity := extractCapacity(freeBikes, emptySlots, extra, isVirtual, rule.Capacity)
 
 	// Determine operational status (depends on capacity)
-	isOperational := extractIsOperational(capacity, freeBikes, extra)
-
-	// Extract renting/returning status (depends on isOperational)
-	isRenting := extractIsRenting(extra, isOperational, freeBikes)
-	isReturning := extractIsReturning(extra, isOperational, emptySlots, isVirtual)
-
-	numEbikesAvailable := extractNumEbikesAvailable(extra)
-	numRegularBikesAvailable := extractNumRegularBikesAvailable(freeBikes, extra)
+	isOperational := extractIsOperational(capacity, freeBikes, extra, rule)
 
 	// Ensure num_docks_available has a value - REQUIRED field (NOT NULL)
 	// For virtual stations, this might be 0
-	numDocksAvailable := 0
-	if emptySlots >= 0 {
-		numDocksAvailable = int(emptySlots)
-	}
+	numDocksAvailable := max(emptySlots, 0)
+
+	lastReported := extractLastReported(station)
 
 	// Build the mapped station record for Supabase bikeshare.station table
 	// Using gis.geography format for location (PostGIS WKT)
 	// IMPORTANT: All fields must be present for batch upsert (PostgREST requirement)
-	// Nullable fields should be set to nil, not omitted
-	mappedStation := map[string]any{
-		"id":                   mappedStationId,
-		"network_id":           networkId,
-		"name":                 name,
-		"location":             location,
-		"address":              address,
-		"capacity":             capacity,
-		"num_docks_available":  numDocksAvailable,
-		"num_ebikes_available": numEbikesAvailable,
-		"num_bikes_available":  numRegularBikesAvailable,
-		"is_operational":       isOperational,
-		"is_renting":           isRenting,
-		"is_returning":         isReturning,
-		"is_virtual":           isVirtual,
-		"last_reported":        lastReported,
-		"raw_data":             stationData,
-	}
-
-	// Debug logging to see what's being created
-	if Config.verbose {
-		log.Printf("🔍 DEBUG: Mapped station keys: %v", getKeys(mappedStation))
-		log.Printf("🔍 DEBUG: Station %s - virtual: %v, capacity: %d, operational: %v, renting: %v, returning: %v",
-			mappedStationId, isVirtual, capacity, isOperational, isRenting, isReturning)
-	}
+	// Nullable fields are written as null, not omitted
+	mappedStation := &StationRecord{
+		ID:                      mappedStationId,
+		NetworkID:               networkId,
+		Name:                    station.Name,
+		Location:                extractLocation(station),
+		Latitude:                station.Latitude,
+		Longitude:               station.Longitude,
+		Address:                 extractAddress(extra),
+		Capacity:                capacity,
+		NumDocksAvailable:       numDocksAvailable,
+		NumEbikesAvailable:      extractNumEbikesAvailable(extra),
+		NumBikesAvailable:       extractNumRegularBikesAvailable(freeBikes, extra),
+		IsOperational:           isOperational,
+		IsRenting:               extractIsRenting(extra, isOperational, freeBikes, rule),
+		IsReturning:             extractIsReturning(extra, isOperational, emptySlots, isVirtual, rule),
+		IsVirtual:               isVirtual,
+		LastReported:            lastReported.String(),
+		LastReportedSynthesized: lastReported.Synthesized,
+		RawData:                 station.Raw,
+	}
+
+	logger.Debug("mapped station",
+		logging.NetworkID(networkName),
+		logging.StationID(mappedStationId),
+		"is_virtual", isVirtual,
+		"capacity", capacity,
+		"is_operational", isOperational,
+		"is_renting", mappedStation.IsRenting,
+		"is_returning", mappedStation.IsReturning,
+	)
 
 	return mappedStation, nil
 }
-
-// Helper function to get map keys
-func getKeys(m map[string]any) []string {
-	keys := make([]string, 0, len(m))
-	for k := range m {
-		keys = append(keys, k)
-	}
-	return keys
-}
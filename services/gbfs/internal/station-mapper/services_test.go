@@ -0,0 +1,95 @@
+package stationMapper
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"gbfs-service/internal/citybikes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// golden is what a testdata/<case>.golden.json file holds
+type golden struct {
+	Record *StationRecord `json:"record,omitempty"`
+	Error  string         `json:"error,omitempty"`
+}
+
+// TestMapStationDataGolden maps every testdata/<case>.json and compares the
+// result with testdata/<case>.golden.json. Run with -update to rewrite the
+// golden files after an intended change and review the diff.
+func TestMapStationDataGolden(t *testing.T) {
+	fetched := time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC)
+	now = func() time.Time { return fetched }
+	t.Cleanup(func() { now = time.Now })
+
+	cases := []struct {
+		name    string
+		network string
+	}{
+		{"docked", "bicing"},
+		{"virtual", "nextbike-berlin"},
+		{"virtual_slots", "nextbike-berlin"},
+		{"status_closed", "velib"},
+		{"status_maintenance", "velib"},
+		{"status_uppercase", "velib"},
+		{"flags_numeric", "citi-bike-nyc"},
+		{"offline", "bicing"},
+		{"missing_fields", "bicing"},
+		{"negative_counts", "mvg-rad"},
+		{"missing_id", "bicing"},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			input, err := os.ReadFile(filepath.Join("testdata", tc.name+".json"))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var station citybikes.Station
+			if err := json.Unmarshal(input, &station); err != nil {
+				t.Fatalf("failed to decode input: %v", err)
+			}
+
+			var got golden
+			got.Record, err = MapStationData(station, tc.network)
+			if err != nil {
+				got.Error = err.Error()
+			}
+
+			checkGolden(t, filepath.Join("testdata", tc.name+".golden.json"), got)
+		})
+	}
+}
+
+// checkGolden compares v, encoded as indented JSON, with the golden file,
+// or rewrites the file if -update is set
+func checkGolden(t *testing.T, path string, v any) {
+	t.Helper()
+
+	got, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		t.Fatalf("failed to encode result: %v", err)
+	}
+	got = append(got, '\n')
+
+	if *update {
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("mapped station differs from %s (run with -update to accept)\ngot:\n%s\nwant:\n%s", path, got, want)
+	}
+}
@@ -0,0 +1,185 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/logging"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// recentCollisionsCapacity is how many collisions are kept for operators
+const recentCollisionsCapacity = 100
+
+// StationCollision is an incoming station whose ID already belongs to a
+// station of another network
+type StationCollision struct {
+	Time              time.Time `json:"time"`
+	StationID         string    `json:"station_id"`
+	Name              string    `json:"name"`
+	StoredNetworkID   string    `json:"stored_network_id"`
+	IncomingNetworkID string    `json:"incoming_network_id"`
+}
+
+// CollisionStats summarises the station writes refused because of an ID
+// collision
+type CollisionStats struct {
+	Total     int64              `json:"total"`
+	ByNetwork map[string]int64   `json:"by_network"` // Keyed by the incoming network
+	Recent    []StationCollision `json:"recent"`     // Newest first
+}
+
+// stationOwners caches the network each station ID was stored under, and
+// the IDs the database didn't have, so it is asked about each ID only once
+var stationOwners = struct {
+	sync.Mutex
+	networks map[string]string
+	absent   map[string]bool // Not stored when looked up or written since
+	stats    CollisionStats
+}{
+	networks: make(map[string]string),
+	absent:   make(map[string]bool),
+	stats:    CollisionStats{ByNetwork: make(map[string]int64)},
+}
+
+// StationCollisions returns the collision statistics
+func StationCollisions() CollisionStats {
+	stationOwners.Lock()
+	defer stationOwners.Unlock()
+
+	stats := stationOwners.stats
+	stats.ByNetwork = make(map[string]int64, len(stationOwners.stats.ByNetwork))
+	for network, count := range stationOwners.stats.ByNetwork {
+		stats.ByNetwork[network] = count
+	}
+	stats.Recent = append([]StationCollision{}, stationOwners.stats.Recent...)
+	return stats
+}
+
+// rejectCollisions drops stations whose ID is already stored under another
+// network, so merge-duplicates can't move a station between networks. If
+// the owners can't be looked up the stations are let through as before.
+func rejectCollisions(ctx context.Context, stations []*stationMapper.StationRecord) []*stationMapper.StationRecord {
+	stationOwners.Lock()
+	var unknown []string
+	queued := make(map[string]bool)
+	for _, station := range stations {
+		if _, ok := stationOwners.networks[station.ID]; !ok && !stationOwners.absent[station.ID] && !queued[station.ID] {
+			queued[station.ID] = true
+			unknown = append(unknown, station.ID)
+		}
+	}
+	stationOwners.Unlock()
+
+	var owners map[string]string
+	if len(unknown) > 0 {
+		var err error
+		if owners, err = fetchStationOwners(unknown); err != nil {
+			logger.WarnContext(ctx, "failed to look up station owners, skipping collision check", logging.Err(err))
+			return stations
+		}
+	}
+
+	stationOwners.Lock()
+	defer stationOwners.Unlock()
+
+	for _, id := range unknown {
+		if network, ok := owners[id]; ok {
+			stationOwners.networks[id] = network
+		} else {
+			stationOwners.absent[id] = true
+		}
+	}
+
+	// Within a batch the first network to claim an ID keeps it
+	claimed := make(map[string]string, len(stations))
+	accepted := make([]*stationMapper.StationRecord, 0, len(stations))
+	for _, station := range stations {
+		owner, ok := stationOwners.networks[station.ID]
+		if !ok {
+			owner, ok = claimed[station.ID]
+		}
+		if ok && owner != station.NetworkID {
+			recordCollision(ctx, station, owner)
+			continue
+		}
+		claimed[station.ID] = station.NetworkID
+		accepted = append(accepted, station)
+	}
+	return accepted
+}
+
+// rememberOwners records the network of stations that were written
+func rememberOwners(stations []*stationMapper.StationRecord) {
+	stationOwners.Lock()
+	defer stationOwners.Unlock()
+
+	for _, station := range stations {
+		stationOwners.networks[station.ID] = station.NetworkID
+		delete(stationOwners.absent, station.ID)
+	}
+}
+
+// recordCollision counts a refused station; callers hold stationOwners
+func recordCollision(ctx context.Context, station *stationMapper.StationRecord, owner string) {
+	collision := StationCollision{
+		Time:              time.Now().UTC(),
+		StationID:         station.ID,
+		Name:              station.Name,
+		StoredNetworkID:   owner,
+		IncomingNetworkID: station.NetworkID,
+	}
+
+	stats := &stationOwners.stats
+	stats.Total++
+	stats.ByNetwork[station.NetworkID]++
+	stats.Recent = append([]StationCollision{collision}, stats.Recent...)
+	if len(stats.Recent) > recentCollisionsCapacity {
+		stats.Recent = stats.Recent[:recentCollisionsCapacity]
+	}
+
+	trace.SpanFromContext(ctx).AddEvent("station.collision", trace.WithAttributes(
+		attribute.String("station.id", station.ID),
+		attribute.String("network.stored", owner),
+		attribute.String("network.incoming", station.NetworkID),
+	))
+	logger.WarnContext(ctx, "station ID belongs to another network, not overwriting",
+		logging.StationID(station.ID),
+		"name", station.Name,
+		"stored_network_id", owner,
+		"incoming_network_id", station.NetworkID,
+	)
+}
+
+// fetchStationOwners returns the network_id stored for each of the IDs
+// that exist
+func fetchStationOwners(ids []string) (map[string]string, error) {
+	owners := make(map[string]string, len(ids))
+	for i := 0; i < len(ids); i += 100 {
+		end := min(i+100, len(ids))
+		data, _, err := Config.Client.From("station").
+			Select("id,network_id", "", false).
+			In("id", ids[i:end]).
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch station owners: %v", err)
+		}
+
+		var rows []struct {
+			ID        string `json:"id"`
+			NetworkID string `json:"network_id"`
+		}
+		if err := json.Unmarshal(data, &rows); err != nil {
+			return nil, fmt.Errorf("failed to parse station owners: %v", err)
+		}
+		for _, row := range rows {
+			owners[row.ID] = row.NetworkID
+		}
+	}
+	return owners, nil
+}
@@ -0,0 +1,41 @@
+package supabase
+
+import (
+	"context"
+	"gbfs-service/internal/config"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestBatchUpsertStationsLeavesOutCollisions(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/rest/v1/station" && r.Method == http.MethodGet {
+			w.Write([]byte(`[{"id": "taken", "network_id": "owner"}]`))
+			return
+		}
+		w.Write([]byte("[]"))
+	}))
+	defer server.Close()
+
+	previous := Config
+	t.Cleanup(func() { Config = previous })
+	if err := InitSupabase(config.SupabaseConfig{URL: server.URL, Key: "key", Schema: "bikeshare"}); err != nil {
+		t.Fatal(err)
+	}
+
+	taken := &stationMapper.StationRecord{ID: "taken", NetworkID: "incoming"}
+	free := &stationMapper.StationRecord{ID: "free", NetworkID: "incoming"}
+	written, err := BatchUpsertStations(context.Background(), []*stationMapper.StationRecord{taken, free})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 1 || written[0] != free {
+		t.Errorf("written %v, want only the station without a collision", written)
+	}
+
+	if err := UpsertStation(context.Background(), taken); err == nil {
+		t.Error("UpsertStation refused a station without reporting it")
+	}
+}
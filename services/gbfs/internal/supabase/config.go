@@ -1,8 +1,10 @@
 package supabase
 
 import (
-	"log"
-	"os"
+	"fmt"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
 
 	supa "github.com/supabase-community/supabase-go"
 )
@@ -15,28 +17,29 @@ type SupabaseConfig struct {
 
 var Config *SupabaseConfig
 
-// InitSupabase initializes the Supabase client
-func InitSupabase() error {
-	url := os.Getenv("SUPABASE_URL")
-	apiKey := os.Getenv("SUPABASE_KEY")
+var logger = logging.For("supabase")
+
+var tracer = tracing.Tracer("supabase")
 
-	if url == "" || apiKey == "" {
-		log.Fatal("SUPABASE_URL and SUPABASE_KEY environment variables are required")
+// InitSupabase initializes the Supabase client
+func InitSupabase(cfg config.SupabaseConfig) error {
+	if cfg.URL == "" || cfg.Key == "" {
+		return fmt.Errorf("supabase URL and key are required")
 	}
 
-	client, err := supa.NewClient(url, apiKey, &supa.ClientOptions{
-		Schema: "bikeshare", // Set default schema to 'bikeshare'
+	client, err := supa.NewClient(cfg.URL, cfg.Key, &supa.ClientOptions{
+		Schema: cfg.Schema, // 'bikeshare' unless overridden
 	})
 	if err != nil {
 		return err
 	}
 
 	Config = &SupabaseConfig{
-		URL:    url,
-		APIKey: apiKey,
+		URL:    cfg.URL,
+		APIKey: cfg.Key,
 		Client: client,
 	}
 
-	log.Println("✅ Supabase client initialized successfully")
+	logger.Info("supabase client initialized", "schema", cfg.Schema)
 	return nil
 }
@@ -0,0 +1,503 @@
+package supabase
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/logging"
+	stationMapper "gbfs-service/internal/station-mapper"
+	"gbfs-service/internal/timestamps"
+	"gbfs-service/internal/tracing"
+	"math"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// StationStatus is a row of bikeshare.station_status, a station's
+// availability from recorded_at until its next row
+type StationStatus struct {
+	StationID          string `json:"station_id"`
+	RecordedAt         string `json:"recorded_at"`
+	NumBikesAvailable  int    `json:"num_bikes_available"`
+	NumEbikesAvailable int    `json:"num_ebikes_available"`
+	NumDocksAvailable  int    `json:"num_docks_available"`
+	IsOperational      bool   `json:"is_operational"`
+	IsRenting          *bool  `json:"is_renting"`
+	IsReturning        *bool  `json:"is_returning"`
+}
+
+// availability is what a station_status row records, comparable so a
+// snapshot is only appended when it changed
+type availability struct {
+	bikes, ebikes, docks int
+	operational          bool
+	renting, returning   int8 // 0 unknown, 1 false, 2 true
+}
+
+// statusHistory holds the last availability written per station. It starts
+// empty, so every station gets one snapshot after a restart.
+var statusHistory = struct {
+	sync.Mutex
+	enabled bool
+	last    map[string]availability
+}{
+	last: make(map[string]availability),
+}
+
+// ConfigureStatusHistory turns recording of station availability on or off
+func ConfigureStatusHistory(cfg config.HistoryConfig) {
+	statusHistory.Lock()
+	defer statusHistory.Unlock()
+
+	statusHistory.enabled = cfg.Enabled
+	if !cfg.Enabled {
+		statusHistory.last = make(map[string]availability)
+	}
+}
+
+// tristate encodes a nullable flag for availability
+func tristate(flag *bool) int8 {
+	switch {
+	case flag == nil:
+		return 0
+	case *flag:
+		return 2
+	default:
+		return 1
+	}
+}
+
+// availabilityOf returns what the history records of a station
+func availabilityOf(station *stationMapper.StationRecord) availability {
+	return availability{
+		bikes:       station.NumBikesAvailable,
+		ebikes:      station.NumEbikesAvailable,
+		docks:       station.NumDocksAvailable,
+		operational: station.IsOperational,
+		renting:     tristate(station.IsRenting),
+		returning:   tristate(station.IsReturning),
+	}
+}
+
+// recordStatusHistory appends a station_status row for each station whose
+// availability changed since it was last recorded. Failures are logged and
+// retried with the station's next write; they never fail the upsert.
+func recordStatusHistory(ctx context.Context, stations []*stationMapper.StationRecord) {
+	ctx, span := tracer.Start(ctx, "supabase.record_status_history")
+	defer span.End()
+
+	statusHistory.Lock()
+	enabled := statusHistory.enabled
+	changed := make(map[string]availability)
+	var rows []StationStatus
+	index := make(map[string]int) // Row of each station, a batch may repeat one
+	if enabled {
+		now := timestamps.Format(time.Now())
+		for _, station := range stations {
+			current := availabilityOf(station)
+			if last, ok := statusHistory.last[station.ID]; ok && last == current {
+				continue
+			}
+
+			recordedAt := now
+			if station.FetchedAt != nil {
+				recordedAt = *station.FetchedAt
+			}
+			row := StationStatus{
+				StationID:          station.ID,
+				RecordedAt:         recordedAt,
+				NumBikesAvailable:  station.NumBikesAvailable,
+				NumEbikesAvailable: station.NumEbikesAvailable,
+				NumDocksAvailable:  station.NumDocksAvailable,
+				IsOperational:      station.IsOperational,
+				IsRenting:          station.IsRenting,
+				IsReturning:        station.IsReturning,
+			}
+			if i, ok := index[station.ID]; ok {
+				rows[i] = row
+			} else {
+				index[station.ID] = len(rows)
+				rows = append(rows, row)
+			}
+			changed[station.ID] = current
+		}
+	}
+	statusHistory.Unlock()
+
+	if len(rows) == 0 {
+		return
+	}
+	span.SetAttributes(tracing.Count(len(rows)))
+
+	_, _, err := Config.Client.From("station_status").
+		Upsert(rows, "station_id,recorded_at", "minimal", "merge-duplicates").
+		Execute()
+	if err != nil {
+		tracing.RecordError(span, err)
+		logger.WarnContext(ctx, "failed to record station status history", "count", len(rows), logging.Err(err))
+		return
+	}
+
+	statusHistory.Lock()
+	for id, current := range changed {
+		statusHistory.last[id] = current
+	}
+	statusHistory.Unlock()
+
+	logger.DebugContext(ctx, "recorded station status history", "count", len(rows))
+}
+
+// StatusAggregate is a row of bikeshare.station_status_aggregate: a
+// station's availability over one bucket, every value weighted by how long
+// it lasted. ObservedSeconds is less than the bucket when the history
+// starts or the station was removed within it.
+type StatusAggregate struct {
+	StationID          string  `json:"station_id"`
+	BucketStart        string  `json:"bucket_start"`
+	BucketWidth        string  `json:"bucket_width"`
+	ObservedSeconds    int     `json:"observed_seconds"`
+	AvgBikesAvailable  float64 `json:"avg_bikes_available"`
+	MinBikesAvailable  int     `json:"min_bikes_available"`
+	MaxBikesAvailable  int     `json:"max_bikes_available"`
+	AvgEbikesAvailable float64 `json:"avg_ebikes_available"`
+	AvgDocksAvailable  float64 `json:"avg_docks_available"`
+	MinDocksAvailable  int     `json:"min_docks_available"`
+	MaxDocksAvailable  int     `json:"max_docks_available"`
+	OperationalRatio   float64 `json:"operational_ratio"`
+}
+
+// DownsampleResult is what one downsampling pass did
+type DownsampleResult struct {
+	Folded     int `json:"folded"`     // Snapshots folded and deleted
+	Aggregated int `json:"aggregated"` // Buckets written
+	Carried    int `json:"carried"`    // Stations whose state was kept at the cutoff
+	Purged     int `json:"purged"`     // Aggregates past their retention deleted
+}
+
+// historyPageSize stays under PostgREST's default row limit
+const historyPageSize = 1000
+
+// snapshot is a station_status row with its time parsed
+type snapshot struct {
+	StationStatus
+	at time.Time
+}
+
+// bucket accumulates the availability of a station over one bucket
+type bucket struct {
+	start                                  time.Time
+	seconds                                float64
+	bikes, ebikes, docks, operational      float64 // Value × seconds
+	minBikes, maxBikes, minDocks, maxDocks int
+}
+
+// add weighs a snapshot's availability by the seconds it lasted in the bucket
+func (b *bucket) add(s StationStatus, seconds float64) {
+	if b.seconds == 0 {
+		b.minBikes, b.maxBikes = s.NumBikesAvailable, s.NumBikesAvailable
+		b.minDocks, b.maxDocks = s.NumDocksAvailable, s.NumDocksAvailable
+	}
+	b.seconds += seconds
+	b.bikes += float64(s.NumBikesAvailable) * seconds
+	b.ebikes += float64(s.NumEbikesAvailable) * seconds
+	b.docks += float64(s.NumDocksAvailable) * seconds
+	if s.IsOperational {
+		b.operational += seconds
+	}
+	b.minBikes, b.maxBikes = min(b.minBikes, s.NumBikesAvailable), max(b.maxBikes, s.NumBikesAvailable)
+	b.minDocks, b.maxDocks = min(b.minDocks, s.NumDocksAvailable), max(b.maxDocks, s.NumDocksAvailable)
+}
+
+// downsample folds the snapshots recorded before cutoff into buckets of
+// width. Each snapshot holds until the station's next one, the cutoff or
+// the station's removal, and is spread over every bucket it spans, so a
+// bucket without a change still gets a row. It also returns each remaining
+// station's state at the cutoff, which the next pass starts from.
+func downsample(rows []StationStatus, removed map[string]time.Time, cutoff time.Time, width time.Duration) ([]StatusAggregate, []StationStatus, error) {
+	snapshots := make([]snapshot, 0, len(rows))
+	for _, row := range rows {
+		at, err := timestamps.Parse(row.RecordedAt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("station %s: invalid recorded_at %q: %v", row.StationID, row.RecordedAt, err)
+		}
+		if at.Before(cutoff) {
+			snapshots = append(snapshots, snapshot{StationStatus: row, at: at})
+		}
+	}
+	slices.SortFunc(snapshots, func(a, b snapshot) int {
+		return cmp.Or(cmp.Compare(a.StationID, b.StationID), a.at.Compare(b.at))
+	})
+
+	var aggregates []StatusAggregate
+	var carried []StationStatus
+	for i := 0; i < len(snapshots); {
+		station := snapshots[i].StationID
+		end := cutoff
+		if removedAt, ok := removed[station]; ok && removedAt.Before(end) {
+			end = removedAt
+		}
+
+		var buckets []*bucket // In time order
+		for ; i < len(snapshots) && snapshots[i].StationID == station; i++ {
+			current := snapshots[i]
+			until := end
+			if i+1 < len(snapshots) && snapshots[i+1].StationID == station && snapshots[i+1].at.Before(until) {
+				until = snapshots[i+1].at
+			}
+
+			for start := current.at.Truncate(width); start.Before(until); start = start.Add(width) {
+				from, to := maxTime(current.at, start), minTime(until, start.Add(width))
+				if !to.After(from) {
+					continue
+				}
+				if len(buckets) == 0 || !buckets[len(buckets)-1].start.Equal(start) {
+					buckets = append(buckets, &bucket{start: start})
+				}
+				buckets[len(buckets)-1].add(current.StationStatus, to.Sub(from).Seconds())
+			}
+
+			// The last snapshot is the state at the cutoff, unless the station is gone
+			if (i+1 == len(snapshots) || snapshots[i+1].StationID != station) && end.Equal(cutoff) {
+				state := current.StationStatus
+				state.RecordedAt = timestamps.Format(cutoff)
+				carried = append(carried, state)
+			}
+		}
+
+		for _, b := range buckets {
+			aggregates = append(aggregates, StatusAggregate{
+				StationID:          station,
+				BucketStart:        timestamps.Format(b.start),
+				BucketWidth:        pgInterval(width),
+				ObservedSeconds:    int(math.Round(b.seconds)),
+				AvgBikesAvailable:  b.bikes / b.seconds,
+				MinBikesAvailable:  b.minBikes,
+				MaxBikesAvailable:  b.maxBikes,
+				AvgEbikesAvailable: b.ebikes / b.seconds,
+				AvgDocksAvailable:  b.docks / b.seconds,
+				MinDocksAvailable:  b.minDocks,
+				MaxDocksAvailable:  b.maxDocks,
+				OperationalRatio:   b.operational / b.seconds,
+			})
+		}
+	}
+	return aggregates, carried, nil
+}
+
+// minTime returns the earlier of a and b
+func minTime(a, b time.Time) time.Time {
+	if a.Before(b) {
+		return a
+	}
+	return b
+}
+
+// maxTime returns the later of a and b
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// pgInterval formats a duration as a Postgres interval
+func pgInterval(d time.Duration) string {
+	return fmt.Sprintf("%d seconds", int64(d/time.Second))
+}
+
+// DownsampleStatusHistory folds the station_status rows older than the raw
+// retention into bucket aggregates, keeps each station's state at the
+// cutoff as a snapshot and purges expired aggregates. The cutoff is aligned
+// to a bucket, so every bucket is written whole by one pass; a pass that
+// fails part way is simply repeated by the next one.
+func DownsampleStatusHistory(ctx context.Context, cfg config.HistoryConfig, now time.Time) (DownsampleResult, error) {
+	ctx, span := tracer.Start(ctx, "supabase.downsample_status_history")
+	defer span.End()
+
+	if Config == nil || Config.Client == nil {
+		return DownsampleResult{}, fmt.Errorf("supabase client not initialized")
+	}
+
+	var result DownsampleResult
+	cutoff := now.Add(-cfg.RawRetention).Truncate(cfg.Bucket)
+	cutoffAt := timestamps.Format(cutoff)
+
+	rows, err := fetchStatusHistory(cutoffAt)
+	if err != nil {
+		return result, tracing.RecordError(span, err)
+	}
+
+	if len(rows) > 0 {
+		removed, err := fetchRemovedStations(rows)
+		if err != nil {
+			return result, tracing.RecordError(span, err)
+		}
+
+		aggregates, carried, err := downsample(rows, removed, cutoff, cfg.Bucket)
+		if err != nil {
+			return result, tracing.RecordError(span, fmt.Errorf("failed to downsample station status: %v", err))
+		}
+
+		// A snapshot taken right at the cutoff already is the state there
+		if carried, err = withoutSnapshotsAt(carried, cutoffAt); err != nil {
+			return result, tracing.RecordError(span, err)
+		}
+
+		for i := 0; i < len(aggregates); i += historyPageSize {
+			_, _, err := Config.Client.From("station_status_aggregate").
+				Upsert(aggregates[i:min(i+historyPageSize, len(aggregates))], "station_id,bucket_start", "minimal", "").
+				Execute()
+			if err != nil {
+				return result, tracing.RecordError(span, fmt.Errorf("failed to write station status aggregates: %v", err))
+			}
+		}
+		for i := 0; i < len(carried); i += historyPageSize {
+			_, _, err := Config.Client.From("station_status").
+				Upsert(carried[i:min(i+historyPageSize, len(carried))], "station_id,recorded_at", "minimal", "").
+				Execute()
+			if err != nil {
+				return result, tracing.RecordError(span, fmt.Errorf("failed to carry station status over the cutoff: %v", err))
+			}
+		}
+
+		if _, _, err := Config.Client.From("station_status").Delete("minimal", "").Lt("recorded_at", cutoffAt).Execute(); err != nil {
+			return result, tracing.RecordError(span, fmt.Errorf("failed to delete folded station status: %v", err))
+		}
+		result.Folded, result.Aggregated, result.Carried = len(rows), len(aggregates), len(carried)
+	}
+
+	if cfg.AggregateRetention > 0 {
+		expiry := timestamps.Format(now.Add(-cfg.AggregateRetention))
+		_, purged, err := Config.Client.From("station_status_aggregate").Delete("minimal", "exact").Lt("bucket_start", expiry).Execute()
+		if err != nil {
+			return result, tracing.RecordError(span, fmt.Errorf("failed to purge station status aggregates: %v", err))
+		}
+		result.Purged = int(purged)
+	}
+
+	span.SetAttributes(tracing.Count(result.Aggregated))
+	logger.InfoContext(ctx, "downsampled station status history",
+		"folded", result.Folded,
+		"aggregated", result.Aggregated,
+		"carried", result.Carried,
+		"purged", result.Purged,
+		"cutoff", cutoffAt,
+	)
+	return result, nil
+}
+
+// fetchStatusHistory returns the station_status rows recorded before cutoff
+func fetchStatusHistory(cutoff string) ([]StationStatus, error) {
+	var rows []StationStatus
+	for from := 0; ; from += historyPageSize {
+		data, _, err := Config.Client.From("station_status").
+			Select("*", "", false).
+			Lt("recorded_at", cutoff).
+			Order("station_id", &postgrest.OrderOpts{Ascending: true}).
+			Order("recorded_at", &postgrest.OrderOpts{Ascending: true}).
+			Range(from, from+historyPageSize-1, "").
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch station status history: %v", err)
+		}
+
+		var page []StationStatus
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse station status history: %v", err)
+		}
+		rows = append(rows, page...)
+		if len(page) < historyPageSize {
+			return rows, nil
+		}
+	}
+}
+
+// fetchRemovedStations returns when each removed station of rows was removed
+func fetchRemovedStations(rows []StationStatus) (map[string]time.Time, error) {
+	seen := make(map[string]bool)
+	var ids []string
+	for _, row := range rows {
+		if !seen[row.StationID] {
+			seen[row.StationID] = true
+			ids = append(ids, row.StationID)
+		}
+	}
+
+	removed := make(map[string]time.Time)
+	for i := 0; i < len(ids); i += 100 {
+		data, _, err := Config.Client.From("station").
+			Select("id,removed_at", "", false).
+			In("id", ids[i:min(i+100, len(ids))]).
+			Not("removed_at", "is", "null").
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch removed stations: %v", err)
+		}
+
+		var stations []struct {
+			ID        string `json:"id"`
+			RemovedAt string `json:"removed_at"`
+		}
+		if err := json.Unmarshal(data, &stations); err != nil {
+			return nil, fmt.Errorf("failed to parse removed stations: %v", err)
+		}
+		for _, station := range stations {
+			if at, err := timestamps.Parse(station.RemovedAt); err == nil {
+				removed[station.ID] = at
+			}
+		}
+	}
+	return removed, nil
+}
+
+// withoutSnapshotsAt drops the states of stations that have a snapshot at
+// exactly recordedAt, which an upsert would otherwise overwrite
+func withoutSnapshotsAt(states []StationStatus, recordedAt string) ([]StationStatus, error) {
+	data, _, err := Config.Client.From("station_status").
+		Select("station_id", "", false).
+		Eq("recorded_at", recordedAt).
+		Execute()
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch station status at the cutoff: %v", err)
+	}
+
+	var rows []struct {
+		StationID string `json:"station_id"`
+	}
+	if err := json.Unmarshal(data, &rows); err != nil {
+		return nil, fmt.Errorf("failed to parse station status at the cutoff: %v", err)
+	}
+	if len(rows) == 0 {
+		return states, nil
+	}
+
+	taken := make(map[string]bool, len(rows))
+	for _, row := range rows {
+		taken[row.StationID] = true
+	}
+	return slices.DeleteFunc(states, func(s StationStatus) bool { return taken[s.StationID] }), nil
+}
+
+// DownsampleStatusHistoryEvery downsamples the station status history every
+// cfg.DownsampleInterval until ctx is done
+func DownsampleStatusHistoryEvery(ctx context.Context, cfg config.HistoryConfig) {
+	ticker := time.NewTicker(cfg.DownsampleInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := DownsampleStatusHistory(ctx, cfg, time.Now()); err != nil {
+			logger.WarnContext(ctx, "station status downsampling failed", logging.Err(err))
+		}
+	}
+}
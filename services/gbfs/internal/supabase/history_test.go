@@ -0,0 +1,166 @@
+package supabase
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestDownsample(t *testing.T) {
+	day := time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)
+	at := func(clock string) time.Time {
+		parsed, err := time.Parse("15:04:05", clock)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return day.Add(parsed.Sub(parsed.Truncate(24 * time.Hour)))
+	}
+	status := func(station, clock string, bikes, docks int, operational bool) StationStatus {
+		return StationStatus{
+			StationID:         station,
+			RecordedAt:        at(clock).Format(time.RFC3339),
+			NumBikesAvailable: bikes,
+			NumDocksAvailable: docks,
+			IsOperational:     operational,
+		}
+	}
+
+	// aggregate is the part of a StatusAggregate the cases check
+	type aggregate struct {
+		start              string
+		seconds            int
+		avgBikes           float64
+		minBikes, maxBikes int
+		avgDocks           float64
+		operational        float64
+	}
+
+	tests := []struct {
+		name    string
+		rows    []StationStatus
+		removed map[string]time.Time
+		cutoff  string
+		want    []aggregate
+		carried []StationStatus // RecordedAt is the cutoff
+	}{
+		{
+			name: "gap without changes",
+			rows: []StationStatus{
+				status("a", "08:00:00", 0, 10, true),
+				status("a", "08:50:00", 5, 5, true),
+			},
+			cutoff: "09:15:00",
+			want: []aggregate{
+				{"08:00:00", 900, 0, 0, 0, 10, 1},
+				{"08:15:00", 900, 0, 0, 0, 10, 1},
+				{"08:30:00", 900, 0, 0, 0, 10, 1},
+				{"08:45:00", 900, 5 * 600 / 900.0, 0, 5, (10*300 + 5*600) / 900.0, 1},
+				{"09:00:00", 900, 5, 5, 5, 5, 1},
+			},
+			carried: []StationStatus{status("a", "09:15:00", 5, 5, true)},
+		},
+		{
+			name: "values weighted by how long they lasted",
+			rows: []StationStatus{
+				status("a", "08:00:00", 2, 8, true),
+				status("a", "08:14:00", 8, 2, false),
+				status("a", "08:14:10", 2, 8, true),
+			},
+			cutoff: "08:15:00",
+			want: []aggregate{
+				{"08:00:00", 900, (2*840 + 8*10 + 2*50) / 900.0, 2, 8, (8*840 + 2*10 + 8*50) / 900.0, 890 / 900.0},
+			},
+			carried: []StationStatus{status("a", "08:15:00", 2, 8, true)},
+		},
+		{
+			name: "history starting within a bucket",
+			rows: []StationStatus{
+				status("a", "08:10:00", 4, 6, true),
+			},
+			cutoff: "08:30:00",
+			want: []aggregate{
+				{"08:00:00", 300, 4, 4, 4, 6, 1},
+				{"08:15:00", 900, 4, 4, 4, 6, 1},
+			},
+			carried: []StationStatus{status("a", "08:30:00", 4, 6, true)},
+		},
+		{
+			name: "removed station is neither extended nor carried",
+			rows: []StationStatus{
+				status("a", "08:00:00", 3, 7, true),
+			},
+			removed: map[string]time.Time{"a": at("08:20:00")},
+			cutoff:  "08:45:00",
+			want: []aggregate{
+				{"08:00:00", 900, 3, 3, 3, 7, 1},
+				{"08:15:00", 300, 3, 3, 3, 7, 1},
+			},
+		},
+		{
+			name: "snapshots after the cutoff are left for later",
+			rows: []StationStatus{
+				status("a", "08:00:00", 1, 9, false),
+				status("a", "08:20:00", 6, 4, true),
+			},
+			cutoff: "08:15:00",
+			want: []aggregate{
+				{"08:00:00", 900, 1, 1, 1, 9, 0},
+			},
+			carried: []StationStatus{status("a", "08:15:00", 1, 9, false)},
+		},
+		{
+			name: "stations are kept apart",
+			rows: []StationStatus{
+				status("b", "08:00:00", 2, 2, true),
+				status("a", "08:05:00", 1, 1, true),
+			},
+			cutoff: "08:15:00",
+			want: []aggregate{
+				{"08:00:00", 600, 1, 1, 1, 1, 1},
+				{"08:00:00", 900, 2, 2, 2, 2, 1},
+			},
+			carried: []StationStatus{
+				status("a", "08:15:00", 1, 1, true),
+				status("b", "08:15:00", 2, 2, true),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggregates, carried, err := downsample(tt.rows, tt.removed, at(tt.cutoff), 15*time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(aggregates) != len(tt.want) {
+				t.Fatalf("got %d aggregates, want %d: %+v", len(aggregates), len(tt.want), aggregates)
+			}
+			for i, want := range tt.want {
+				got := aggregates[i]
+				if got.BucketStart != at(want.start).Format(time.RFC3339) || got.BucketWidth != "900 seconds" ||
+					got.ObservedSeconds != want.seconds ||
+					!near(got.AvgBikesAvailable, want.avgBikes) ||
+					got.MinBikesAvailable != want.minBikes || got.MaxBikesAvailable != want.maxBikes ||
+					!near(got.AvgDocksAvailable, want.avgDocks) ||
+					!near(got.OperationalRatio, want.operational) {
+					t.Errorf("aggregate %d = %+v, want %+v", i, got, want)
+				}
+			}
+
+			if len(carried) != len(tt.carried) {
+				t.Fatalf("carried %+v, want %+v", carried, tt.carried)
+			}
+			for i, want := range tt.carried {
+				if carried[i] != want {
+					t.Errorf("carried %+v, want %+v", carried[i], want)
+				}
+			}
+		})
+	}
+}
+
+// near reports whether two averages are equal up to rounding
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
@@ -0,0 +1,40 @@
+package supabase
+
+import (
+	"context"
+	"fmt"
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
+)
+
+// IngestStatusRecord is a network's polling health in bikeshare.network_ingest_status
+type IngestStatusRecord struct {
+	NetworkID           string  `json:"network_id"`
+	Status              string  `json:"status"`  // healthy, degraded or unavailable
+	Breaker             string  `json:"breaker"` // closed, open or half_open
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+	LastSuccessAt       *string `json:"last_success_at"`
+	LastErrorAt         *string `json:"last_error_at"`
+	LastError           *string `json:"last_error"`
+	UpdatedAt           string  `json:"updated_at"`
+}
+
+// UpsertIngestStatus stores a network's polling health
+func UpsertIngestStatus(ctx context.Context, record IngestStatusRecord) error {
+	ctx, span := tracer.Start(ctx, "supabase.upsert_ingest_status")
+	defer span.End()
+
+	if Config == nil || Config.Client == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	_, _, err := Config.Client.From("network_ingest_status").
+		Upsert(record, "network_id", "*", "merge-duplicates").
+		Execute()
+	if err != nil {
+		return tracing.RecordError(span, fmt.Errorf("failed to upsert ingest status: %v", err))
+	}
+
+	logger.DebugContext(ctx, "upserted ingest status", logging.NetworkID(record.NetworkID), "status", record.Status)
+	return nil
+}
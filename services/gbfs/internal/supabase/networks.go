@@ -1,28 +1,41 @@
 package supabase
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/logging"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	ratelimit "gbfs-service/internal/rate-limit"
+	"gbfs-service/internal/tracing"
 	"gbfs-service/internal/uuidfy"
-	"log"
-	"net/http"
+	"math"
+	"slices"
 	"strings"
 	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 // NetworkRecord represents a bikeshare network record for Supabase
 // Note: We don't use omitempty because PostgREST requires all keys to match in batch upserts
 type NetworkRecord struct {
-	ID                    string         `json:"id"`
-	Name                  string         `json:"name"`
-	Company               *string        `json:"company"`
-	Location              *string        `json:"location"`
-	City                  *string        `json:"city"`
-	Country               *string        `json:"country"`
-	StationStatusURL      *string        `json:"station_status_url"`
-	StationInformationURL *string        `json:"station_information_url"`
-	VehicleStatusURL      *string        `json:"vehicle_status_url"`
-	RawData               map[string]any `json:"raw_data"`
+	ID                    string          `json:"id"`
+	Name                  string          `json:"name"`
+	Company               *string         `json:"company"`
+	Location              *string         `json:"location"`
+	City                  *string         `json:"city"`
+	Country               *string         `json:"country"`
+	StationStatusURL      *string         `json:"station_status_url"`
+	StationInformationURL *string         `json:"station_information_url"`
+	VehicleStatusURL      *string         `json:"vehicle_status_url"`
+	Active                bool            `json:"active"`
+	RemovedAt             *string         `json:"removed_at"`
+	RawData               json.RawMessage `json:"raw_data"`
+
+	source citybikes.Network // What the record was mapped from
 }
 
 // APISource represents an API source record from Supabase
@@ -33,12 +46,96 @@ type APISource struct {
 	Active       bool   `json:"active"`
 }
 
+// storedNetwork is the part of a network row the catalogue diff compares
+type storedNetwork struct {
+	ID      string            `json:"id"`
+	Name    string            `json:"name"`
+	Company *string           `json:"company"`
+	City    *string           `json:"city"`
+	Country *string           `json:"country"`
+	Active  bool              `json:"active"`
+	RawData citybikes.Network `json:"raw_data"`
+}
+
+// CatalogueDiff summarises what a catalogue refresh changed. Networks are
+// identified by their citybik.es ID.
+type CatalogueDiff struct {
+	Added    []string        `json:"added"`
+	Removed  []string        `json:"removed"` // Marked inactive
+	Changed  []NetworkChange `json:"changed"`
+	Restored []string        `json:"restored"` // Inactive networks that reappeared
+	Synced   int             `json:"synced"`
+}
+
+// NetworkChange lists the fields of a network that changed upstream
+type NetworkChange struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+}
+
+// Empty reports whether the refresh changed nothing
+func (d *CatalogueDiff) Empty() bool {
+	return len(d.Added) == 0 && len(d.Removed) == 0 && len(d.Changed) == 0 && len(d.Restored) == 0
+}
+
+// catalogueEvent is a row of bikeshare.network_catalogue_event
+type catalogueEvent struct {
+	Added    int           `json:"added"`
+	Removed  int           `json:"removed"`
+	Changed  int           `json:"changed"`
+	Restored int           `json:"restored"`
+	Diff     CatalogueDiff `json:"diff"`
+}
+
+// storedNetworksPageSize stays under PostgREST's default row limit
+const storedNetworksPageSize = 1000
+
+// A refresh removing more than maxRemovedShare of the active networks, and
+// more than minImplausibleRemovals of them, is more likely a truncated
+// listing than a real shutdown wave, so nothing is removed
+const (
+	maxRemovedShare        = 0.1
+	minImplausibleRemovals = 5
+)
+
 // BootstrapNetworks fetches and syncs all networks from API sources at startup
-func BootstrapNetworks() error {
-	log.Println("🌐 Bootstrapping networks from API sources...")
+func BootstrapNetworks(ctx context.Context) error {
+	_, err := RefreshNetworks(ctx)
+	return err
+}
+
+// RefreshNetworksEvery re-syncs the network catalogue every interval until
+// ctx is done
+func RefreshNetworksEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if _, err := RefreshNetworks(ctx); err != nil {
+			logger.WarnContext(ctx, "network catalogue refresh failed", logging.Err(err))
+		}
+	}
+}
+
+// RefreshNetworks syncs the network catalogue with every active API source.
+// New, renamed and relocated networks are upserted; networks no longer
+// listed are marked inactive rather than deleted so stations keep their
+// foreign keys. Removals are only detected when every source listed
+// networks, and refused when implausibly many would go at once.
+func RefreshNetworks(ctx context.Context) (*CatalogueDiff, error) {
+	ctx, span := tracer.Start(ctx, "supabase.refresh_networks")
+	defer span.End()
+
+	logger.InfoContext(ctx, "refreshing network catalogue from API sources")
 
 	if Config == nil || Config.Client == nil {
-		return fmt.Errorf("supabase client not initialized")
+		return nil, tracing.RecordError(span, fmt.Errorf("supabase client not initialized"))
 	}
 
 	// 1. Fetch all active API sources
@@ -47,117 +144,302 @@ func BootstrapNetworks() error {
 		Eq("active", "true").
 		Execute()
 	if err != nil {
-		return fmt.Errorf("failed to fetch API sources: %v", err)
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to fetch API sources: %v", err))
 	}
 
 	var apiSources []APISource
 	if err := json.Unmarshal(data, &apiSources); err != nil {
-		return fmt.Errorf("failed to parse API sources: %v", err)
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to parse API sources: %v", err))
 	}
 
 	if len(apiSources) == 0 {
-		log.Println("⚠️  No active API sources found for networks")
-		return nil
+		logger.Warn("no active API sources found for networks")
+		return &CatalogueDiff{}, nil
 	}
 
-	log.Printf("📡 Found %d active API source(s)", len(apiSources))
+	logger.Info("found active API sources", "count", len(apiSources))
+
+	// 2. Load what is stored so the refresh can be diffed against it
+	stored, err := fetchStoredNetworks()
+	if err != nil {
+		return nil, tracing.RecordError(span, err)
+	}
 
-	// 2. Process each API source
-	totalNetworks := 0
+	// 3. Process each API source
+	diff := &CatalogueDiff{}
+	listed := make(map[string]bool)
+	complete := true
 	for _, source := range apiSources {
 		if source.IsGBFS {
-			log.Printf("⏭️  Skipping GBFS source: %s (GBFS discovery not yet implemented)", source.Name)
+			logger.Info("skipping GBFS source, discovery not yet implemented", "source", source.Name)
 			continue
 		}
 
-		log.Printf("📥 Fetching networks from: %s (%s)", source.Name, source.DiscoveryURL)
+		logger.Info("fetching networks", "source", source.Name, "url", source.DiscoveryURL)
 
-		networks, err := fetchNetworksFromSource(source.DiscoveryURL)
+		networks, skipped, err := fetchNetworksFromSource(ctx, source.DiscoveryURL)
 		if err != nil {
-			log.Printf("⚠️  Failed to fetch networks from %s: %v", source.Name, err)
+			logger.Warn("failed to fetch networks", "source", source.Name, logging.Err(err))
+			complete = false
 			continue
 		}
 
-		log.Printf("📊 Found %d networks in %s", len(networks), source.Name)
+		logger.Info("found networks", "source", source.Name, "count", len(networks), "skipped", len(skipped))
 
-		// 3. Upsert networks in batches
-		if err := upsertNetworks(networks); err != nil {
-			log.Printf("⚠️  Failed to upsert networks from %s: %v", source.Name, err)
+		if len(networks)+len(skipped) == 0 {
+			logger.Warn("source listed no networks", "source", source.Name)
+			complete = false
 			continue
 		}
 
-		totalNetworks += len(networks)
+		// Networks that failed to map are still listed, only not updated
+		for _, id := range skipped {
+			listed[id] = true
+		}
+
+		for _, network := range networks {
+			listed[network.ID] = true
+			mappingvalidation.SetArea(network.source.ID, network.source.Location)
+		}
+
+		// 4. Upsert networks in batches; the diff only reports what was written
+		written, err := upsertNetworks(networks)
+		if err != nil {
+			logger.Warn("failed to upsert networks", "source", source.Name, "written", written, logging.Err(err))
+			tracing.RecordError(span, err)
+		}
+		for _, network := range networks[:written] {
+			diff.compare(stored[network.ID], network)
+		}
+		diff.Synced += written
 	}
 
-	log.Printf("✅ Network bootstrap complete! Synced %d networks", totalNetworks)
-	return nil
-}
+	// 5. Mark networks that are no longer listed as inactive
+	if complete {
+		var removed, removedIDs []string
+		active := 0
+		for id, network := range stored {
+			if !network.Active {
+				continue
+			}
+			active++
+			if !listed[id] {
+				removed = append(removed, id)
+				removedIDs = append(removedIDs, network.citybikesID())
+			}
+		}
 
-// fetchNetworksFromSource fetches network data from a discovery URL
-func fetchNetworksFromSource(discoveryURL string) ([]NetworkRecord, error) {
-	client := &http.Client{
-		Timeout: 30 * time.Second,
+		if implausibleRemoval(len(removed), active) {
+			logger.Warn("refusing to remove implausibly many networks, the listing may be truncated",
+				"removed", len(removed),
+				"active", active,
+			)
+		} else {
+			if err := deactivateNetworks(removed); err != nil {
+				return diff, tracing.RecordError(span, err)
+			}
+			slices.Sort(removedIDs)
+			diff.Removed = removedIDs
+		}
+	} else {
+		logger.Warn("not every API source listed networks, skipping removal detection")
 	}
 
-	resp, err := client.Get(discoveryURL)
-	if err != nil {
-		return nil, fmt.Errorf("HTTP request failed: %v", err)
+	diff.report(ctx)
+	return diff, nil
+}
+
+// implausibleRemoval reports whether removing removed of active networks at
+// once looks like a broken listing rather than networks shutting down
+func implausibleRemoval(removed, active int) bool {
+	return removed > minImplausibleRemovals && float64(removed) > maxRemovedShare*float64(active)
+}
+
+// fetchStoredNetworks loads every stored network keyed by record ID
+func fetchStoredNetworks() (map[string]storedNetwork, error) {
+	stored := make(map[string]storedNetwork)
+	for from := 0; ; from += storedNetworksPageSize {
+		data, _, err := Config.Client.From("network").
+			Select("id,name,company,city,country,active,raw_data", "", false).
+			Order("id", nil).
+			Range(from, from+storedNetworksPageSize-1, "").
+			Execute()
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch stored networks: %v", err)
+		}
+
+		var page []storedNetwork
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, fmt.Errorf("failed to parse stored networks: %v", err)
+		}
+		for _, network := range page {
+			stored[network.ID] = network
+		}
+		if len(page) < storedNetworksPageSize {
+			return stored, nil
+		}
 	}
-	defer resp.Body.Close()
+}
+
+// compare records how a network from a source differs from the stored row
+func (d *CatalogueDiff) compare(stored storedNetwork, network NetworkRecord) {
+	citybikesID := network.source.ID
 
-	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("HTTP %d error", resp.StatusCode)
+	if stored.ID == "" {
+		d.Added = append(d.Added, citybikesID)
+		return
+	}
+	if !stored.Active {
+		d.Restored = append(d.Restored, citybikesID)
 	}
 
-	var data map[string]any
-	if err := json.NewDecoder(resp.Body).Decode(&data); err != nil {
-		return nil, fmt.Errorf("failed to decode JSON: %v", err)
+	var fields []string
+	if stored.Name != network.Name {
+		fields = append(fields, "name")
+	}
+	if deref(stored.Company) != deref(network.Company) {
+		fields = append(fields, "company")
+	}
+	if deref(stored.City) != deref(network.City) || deref(stored.Country) != deref(network.Country) {
+		fields = append(fields, "city")
 	}
+	if !sameLocation(stored.RawData.Location, network.source.Location) {
+		fields = append(fields, "location")
+	}
+	if len(fields) > 0 {
+		d.Changed = append(d.Changed, NetworkChange{ID: citybikesID, Fields: fields})
+	}
+}
 
-	networksData, ok := data["networks"]
-	if !ok {
-		return nil, fmt.Errorf("no 'networks' field in response")
+// report logs the diff, adds it to the current span and stores it as an event
+func (d *CatalogueDiff) report(ctx context.Context) {
+	trace.SpanFromContext(ctx).AddEvent("network_catalogue.diff", trace.WithAttributes(
+		attribute.Int("added", len(d.Added)),
+		attribute.Int("removed", len(d.Removed)),
+		attribute.Int("changed", len(d.Changed)),
+		attribute.Int("restored", len(d.Restored)),
+		tracing.Count(d.Synced),
+	))
+
+	logger.InfoContext(ctx, "network catalogue refreshed",
+		"synced", d.Synced,
+		"added", len(d.Added),
+		"removed", len(d.Removed),
+		"changed", len(d.Changed),
+		"restored", len(d.Restored),
+	)
+	if d.Empty() {
+		return
 	}
+	logger.DebugContext(ctx, "network catalogue diff",
+		"added", d.Added,
+		"removed", d.Removed,
+		"changed", d.Changed,
+		"restored", d.Restored,
+	)
+
+	event := catalogueEvent{
+		Added:    len(d.Added),
+		Removed:  len(d.Removed),
+		Changed:  len(d.Changed),
+		Restored: len(d.Restored),
+		Diff:     *d,
+	}
+	if _, _, err := Config.Client.From("network_catalogue_event").Insert(event, false, "", "minimal", "").Execute(); err != nil {
+		logger.WarnContext(ctx, "failed to record network catalogue event", logging.Err(err))
+	}
+}
 
-	networksArray, ok := networksData.([]any)
-	if !ok {
-		return nil, fmt.Errorf("'networks' field is not an array")
+// deactivateNetworks marks networks as no longer listed upstream
+func deactivateNetworks(ids []string) error {
+	if len(ids) == 0 {
+		return nil
 	}
 
-	var networks []NetworkRecord
-	for _, networkData := range networksArray {
-		network, ok := networkData.(map[string]any)
-		if !ok {
-			continue
+	update := map[string]any{
+		"active":     false,
+		"removed_at": time.Now().UTC().Format(time.RFC3339),
+	}
+	for i := 0; i < len(ids); i += 100 {
+		end := min(i+100, len(ids))
+		if _, _, err := Config.Client.From("network").Update(update, "minimal", "").In("id", ids[i:end]).Execute(); err != nil {
+			return fmt.Errorf("failed to mark networks inactive: %v", err)
 		}
+	}
+	return nil
+}
+
+// citybikesID is the upstream network ID kept in raw_data
+func (n storedNetwork) citybikesID() string {
+	if n.RawData.ID != "" {
+		return n.RawData.ID
+	}
+	return n.ID
+}
+
+// sameLocation compares the coordinates of two network locations
+func sameLocation(a, b *citybikes.Location) bool {
+	var locationA, locationB citybikes.Location
+	if a != nil {
+		locationA = *a
+	}
+	if b != nil {
+		locationB = *b
+	}
+	return math.Abs(locationA.Latitude-locationB.Latitude) <= 1e-6 &&
+		math.Abs(locationA.Longitude-locationB.Longitude) <= 1e-6
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// fetchNetworksFromSource fetches network data from a discovery URL. It also
+// returns the record IDs of listed networks that couldn't be mapped.
+func fetchNetworksFromSource(ctx context.Context, discoveryURL string) ([]NetworkRecord, []string, error) {
+	ctx, span := tracer.Start(ctx, "supabase.fetch_networks")
+	defer span.End()
 
+	// Discovery requests share the upstream budget with the poller
+	client := ratelimit.NewClient(30 * time.Second)
+
+	sourceNetworks, err := citybikes.FetchNetworks(ctx, client, discoveryURL)
+	if err != nil {
+		return nil, nil, tracing.RecordError(span, err)
+	}
+
+	var skipped []string
+	networks := make([]NetworkRecord, 0, len(sourceNetworks))
+	for _, network := range sourceNetworks {
 		record, err := mapNetworkToRecord(network)
 		if err != nil {
-			log.Printf("⚠️  Skipping network: %v", err)
+			logger.Warn("skipping network", "id", network.ID, logging.Err(err))
+			if id, err := uuidfy.Network(network.ID); err == nil && network.ID != "" {
+				skipped = append(skipped, id)
+			}
 			continue
 		}
 
 		networks = append(networks, record)
 	}
 
-	return networks, nil
+	return networks, skipped, nil
 }
 
-// mapNetworkToRecord converts raw network data to a NetworkRecord
-func mapNetworkToRecord(network map[string]any) (NetworkRecord, error) {
-	// Extract required fields
-	networkID, hasID := network["id"].(string)
-	networkName, hasName := network["name"].(string)
-	location, hasLocation := network["location"].(map[string]any)
-
-	if !hasID || !hasName {
+// mapNetworkToRecord converts a citybik.es network to a NetworkRecord
+func mapNetworkToRecord(network citybikes.Network) (NetworkRecord, error) {
+	// Check required fields
+	if network.ID == "" || network.Name == "" {
 		return NetworkRecord{}, fmt.Errorf("missing required fields (id or name)")
 	}
 
 	// Generate UUID for network
-	recordID, err := uuidfy.UUIDfy(networkID)
+	recordID, err := uuidfy.Network(network.ID)
 	if err != nil {
-		return NetworkRecord{}, fmt.Errorf("failed to generate UUID for %s: %v", networkID, err)
+		return NetworkRecord{}, fmt.Errorf("failed to generate UUID for %s: %v", network.ID, err)
 	}
 
 	// Helper to create string pointers
@@ -167,43 +449,35 @@ func mapNetworkToRecord(network map[string]any) (NetworkRecord, error) {
 
 	record := NetworkRecord{
 		ID:                    recordID,
-		Name:                  networkName,
-		StationStatusURL:      strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/station_status.json", networkID)),
-		StationInformationURL: strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/station_information.json", networkID)),
-		VehicleStatusURL:      strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/vehicle_status.json", networkID)),
-		RawData:               network,
+		Name:                  network.Name,
+		StationStatusURL:      strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/station_status.json", network.ID)),
+		StationInformationURL: strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/station_information.json", network.ID)),
+		VehicleStatusURL:      strPtr(fmt.Sprintf("https://api.citybik.es/gbfs/3/%s/vehicle_status.json", network.ID)),
+		Active:                true,
+		RawData:               network.Raw,
+		source:                network,
 	}
 
 	// Extract location data
-	if hasLocation {
-		latitude, _ := location["latitude"].(float64)
-		longitude, _ := location["longitude"].(float64)
-		city, _ := location["city"].(string)
-		country, _ := location["country"].(string)
-
-		record.Location = strPtr(fmt.Sprintf("POINT(%f %f)", longitude, latitude))
-		record.City = strPtr(city)
-		record.Country = strPtr(country)
+	if location := network.Location; location != nil {
+		record.Location = strPtr(fmt.Sprintf("POINT(%f %f)", location.Longitude, location.Latitude))
+		record.City = strPtr(location.City)
+		record.Country = strPtr(location.Country)
 	}
 
 	// Extract company data
-	if companies, ok := network["company"].([]any); ok {
-		var companyNames []string
-		for _, comp := range companies {
-			if compName, ok := comp.(string); ok {
-				companyNames = append(companyNames, compName)
-			}
-		}
-		record.Company = strPtr(strings.Join(companyNames, ", "))
+	if network.Company != nil {
+		record.Company = strPtr(strings.Join(network.Company, ", "))
 	}
 
 	return record, nil
 }
 
-// upsertNetworks batch upserts network records to Supabase
-func upsertNetworks(networks []NetworkRecord) error {
+// upsertNetworks batch upserts network records to Supabase and returns how
+// many, from the start, were written
+func upsertNetworks(networks []NetworkRecord) (int, error) {
 	if len(networks) == 0 {
-		return nil
+		return 0, nil
 	}
 
 	// Batch upsert in chunks of 100
@@ -220,11 +494,11 @@ func upsertNetworks(networks []NetworkRecord) error {
 			Execute()
 
 		if err != nil {
-			return fmt.Errorf("failed to upsert batch %d-%d: %v", i, end, err)
+			return i, fmt.Errorf("failed to upsert batch %d-%d: %v", i, end, err)
 		}
 
-		log.Printf("  📤 Upserted networks %d-%d of %d", i+1, end, len(networks))
+		logger.Debug("upserted networks batch", "from", i+1, "to", end, "total", len(networks))
 	}
 
-	return nil
+	return len(networks), nil
 }
@@ -0,0 +1,141 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/uuidfy"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// catalogueServer fakes PostgREST and a citybik.es discovery endpoint
+type catalogueServer struct {
+	stored     []string // Active citybik.es network IDs
+	listing    string   // Discovery response body
+	failUpsert bool
+
+	mu          sync.Mutex
+	deactivated int // PATCH requests on network
+}
+
+func (s *catalogueServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case r.URL.Path == "/v2/networks":
+		fmt.Fprint(w, s.listing)
+	case r.URL.Path == "/rest/v1/api_source":
+		fmt.Fprintf(w, `[{"name": "citybikes", "discovery_url": "http://%s/v2/networks", "active": true}]`, r.Host)
+	case r.URL.Path == "/rest/v1/network" && r.Method == http.MethodGet:
+		var rows []storedNetwork
+		for _, id := range s.stored {
+			recordID, _ := uuidfy.Network(id)
+			rows = append(rows, storedNetwork{ID: recordID, Name: id, Active: true})
+			rows[len(rows)-1].RawData.ID = id
+		}
+		json.NewEncoder(w).Encode(rows)
+	case r.URL.Path == "/rest/v1/network" && r.Method == http.MethodPost:
+		if s.failUpsert {
+			http.Error(w, `{"message": "unavailable"}`, http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, "[]")
+	case r.URL.Path == "/rest/v1/network" && r.Method == http.MethodPatch:
+		s.mu.Lock()
+		s.deactivated++
+		s.mu.Unlock()
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+// listing returns a discovery response for networks with ids; an empty name
+// fails to map
+func listing(networks ...[2]string) string {
+	var entries []string
+	for _, n := range networks {
+		entries = append(entries, fmt.Sprintf(`{"id": %q, "name": %q}`, n[0], n[1]))
+	}
+	return `{"networks": [` + strings.Join(entries, ",") + `]}`
+}
+
+func TestRefreshNetworks(t *testing.T) {
+	many := make([]string, 20)
+	for i := range many {
+		many[i] = fmt.Sprintf("network-%d", i)
+	}
+
+	tests := []struct {
+		name        string
+		server      *catalogueServer
+		added       []string
+		removed     []string
+		deactivated bool
+		synced      int
+	}{
+		{
+			name:    "networks no longer listed are removed",
+			server:  &catalogueServer{stored: []string{"a", "b", "c"}, listing: listing([2]string{"a", "A"}, [2]string{"b", "B"})},
+			removed: []string{"c"}, deactivated: true, synced: 2,
+		},
+		{
+			name:   "an empty listing removes nothing",
+			server: &catalogueServer{stored: []string{"a", "b"}, listing: listing()},
+		},
+		{
+			name:   "networks failing to map stay listed",
+			server: &catalogueServer{stored: []string{"a", "b"}, listing: listing([2]string{"a", "A"}, [2]string{"b", ""})},
+			synced: 1,
+		},
+		{
+			name:   "implausibly many removals are refused",
+			server: &catalogueServer{stored: many, listing: listing([2]string{"network-0", "Zero"})},
+			synced: 1,
+		},
+		{
+			name:   "networks that failed to write aren't reported",
+			server: &catalogueServer{stored: []string{"a"}, listing: listing([2]string{"a", "A"}, [2]string{"new", "New"}), failUpsert: true},
+		},
+		{
+			name:   "new networks are added",
+			server: &catalogueServer{stored: []string{"a"}, listing: listing([2]string{"a", "A"}, [2]string{"new", "New"})},
+			added:  []string{"new"}, synced: 2,
+		},
+	}
+
+	previous := Config
+	t.Cleanup(func() { Config = previous })
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.server)
+			defer server.Close()
+			if err := InitSupabase(config.SupabaseConfig{URL: server.URL, Key: "key", Schema: "bikeshare"}); err != nil {
+				t.Fatal(err)
+			}
+
+			diff, err := RefreshNetworks(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !slices.Equal(diff.Added, tt.added) {
+				t.Errorf("added %v, want %v", diff.Added, tt.added)
+			}
+			if !slices.Equal(diff.Removed, tt.removed) {
+				t.Errorf("removed %v, want %v", diff.Removed, tt.removed)
+			}
+			if deactivated := tt.server.deactivated > 0; deactivated != tt.deactivated {
+				t.Errorf("deactivated networks: %v, want %v", deactivated, tt.deactivated)
+			}
+			if diff.Synced != tt.synced {
+				t.Errorf("synced %d, want %d", diff.Synced, tt.synced)
+			}
+		})
+	}
+}
@@ -1,99 +1,81 @@
 package supabase
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"gbfs-service/internal/envkeys"
+	"gbfs-service/internal/logging"
 	stationMapper "gbfs-service/internal/station-mapper"
-	"log"
+	"gbfs-service/internal/tracing"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"log/slog"
 )
 
-// UpsertStation inserts or updates a station record in Supabase
-func UpsertStation(stationData map[string]any) error {
+// UpsertStation inserts or updates a station record in Supabase. A station
+// whose ID belongs to another network is refused with an error.
+func UpsertStation(ctx context.Context, station *stationMapper.StationRecord) error {
+	ctx, span := tracer.Start(ctx, "supabase.upsert_station")
+	defer span.End()
+
 	if Config == nil || Config.Client == nil {
 		return fmt.Errorf("supabase client not initialized")
 	}
 
-	// Convert the map to StationRecord
-	jsonData, err := json.Marshal(stationData)
-	if err != nil {
-		return fmt.Errorf("failed to marshal station data: %v", err)
-	}
-
-	var station stationMapper.StationRecord
-	if err := json.Unmarshal(jsonData, &station); err != nil {
-		return fmt.Errorf("failed to unmarshal station data: %v", err)
+	if len(rejectCollisions(ctx, []*stationMapper.StationRecord{station})) == 0 {
+		return tracing.RecordError(span, fmt.Errorf("station %s belongs to another network, not written", station.ID))
 	}
 
 	// Upsert the station using Supabase's upsert functionality
 	// This will insert if the record doesn't exist, or update if it does
 	// Note: Using bikeshare.station table
-	_, _, err = Config.Client.From("station").
+	_, _, err := Config.Client.From("station").
 		Upsert(station, "id", "*", "merge-duplicates").
 		Execute()
 
 	if err != nil {
-		return fmt.Errorf("failed to upsert station %s: %v", station.ID, err)
+		return tracing.RecordError(span, fmt.Errorf("failed to upsert station %s: %v", station.ID, err))
 	}
+	rememberOwners([]*stationMapper.StationRecord{station})
+	recordStatusHistory(ctx, []*stationMapper.StationRecord{station})
 
-	if envkeys.Environment.Verbose {
-		log.Printf("✅ Successfully upserted station: %s (%s)", station.Name, station.ID)
-	}
+	logger.DebugContext(ctx, "upserted station", logging.StationID(station.ID), "name", station.Name)
 	return nil
 }
 
-// BatchUpsertStations upserts multiple stations in a single request
-func BatchUpsertStations(stationsData []map[string]any) error {
+// BatchUpsertStations upserts multiple stations in a single request and
+// returns the ones written; stations whose ID belongs to another network are
+// left out
+func BatchUpsertStations(ctx context.Context, stations []*stationMapper.StationRecord) ([]*stationMapper.StationRecord, error) {
+	ctx, span := tracer.Start(ctx, "supabase.upsert_stations")
+	defer span.End()
+
 	if Config == nil || Config.Client == nil {
-		return fmt.Errorf("supabase client not initialized")
+		return nil, fmt.Errorf("supabase client not initialized")
 	}
 
-	if len(stationsData) == 0 {
-		return nil
+	if len(stations) == 0 {
+		return nil, nil
 	}
 
-	verbose := envkeys.Environment.Verbose
+	verbose := logger.Enabled(ctx, slog.LevelDebug)
 
 	if verbose {
 		// Extended logging: Log unique network_ids in this batch
 		networkIDs := make(map[string]bool)
-		for _, stationData := range stationsData {
-			if networkID, ok := stationData["network_id"].(string); ok {
-				networkIDs[networkID] = true
-			}
+		for _, station := range stations {
+			networkIDs[station.NetworkID] = true
 		}
 		networkIDList := make([]string, 0, len(networkIDs))
 		for id := range networkIDs {
 			networkIDList = append(networkIDList, id)
 		}
-		log.Printf("🔍 DEBUG: Unique network_ids in batch: %v", networkIDList)
+		logger.Debug("unique network_ids in station batch", "network_ids", networkIDList)
 	}
 
-	// Convert all station data to StationRecords
-	stations := make([]stationMapper.StationRecord, 0, len(stationsData))
-	for i, stationData := range stationsData {
-		jsonData, err := json.Marshal(stationData)
-		if err != nil {
-			if verbose {
-				log.Printf("Warning: failed to marshal station data at index %d: %v", i, err)
-			}
-			continue
-		}
-
-		var station stationMapper.StationRecord
-		if err := json.Unmarshal(jsonData, &station); err != nil {
-			if verbose {
-				log.Printf("Warning: failed to unmarshal station data at index %d: %v", i, err)
-			}
-			continue
-		}
-
-		stations = append(stations, station)
-	}
-
-	if len(stations) == 0 {
-		return fmt.Errorf("no valid stations to upsert")
+	// Never let a station of one network overwrite another network's
+	if stations = rejectCollisions(ctx, stations); len(stations) == 0 {
+		return nil, nil
 	}
+	span.SetAttributes(tracing.Count(len(stations)))
 
 	// Batch upsert to station table
 	_, _, err := Config.Client.From("station").
@@ -102,98 +84,62 @@ func BatchUpsertStations(stationsData []map[string]any) error {
 
 	if err != nil {
 		// Always log errors
-		log.Printf("❌ Batch upsert failed for %d stations: %v", len(stations), err)
-		
+		logger.ErrorContext(ctx, "batch upsert failed for stations", "count", len(stations), logging.Err(err))
+
 		if verbose {
 			// Log all unique network_ids in the failed batch
 			failedNetworkIDs := make(map[string]int)
 			for _, s := range stations {
 				failedNetworkIDs[s.NetworkID]++
 			}
-			log.Printf("❌ DEBUG: Failed batch network_ids (with station counts): %v", failedNetworkIDs)
-			
+			logger.Debug("failed station batch network_ids", "station_counts", failedNetworkIDs)
+
 			// Log all station IDs in the failed batch
 			ids := make([]string, 0, len(stations))
 			for _, s := range stations {
 				ids = append(ids, s.ID)
 			}
-			log.Printf("❌ DEBUG: Failed station IDs: %v", ids)
+			logger.Debug("failed station IDs", "station_ids", ids)
 		}
 
-		return fmt.Errorf("failed to batch upsert %d stations: %v", len(stations), err)
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to batch upsert %d stations: %v", len(stations), err))
 	}
 
-	log.Printf("✅ Batch upserted %d stations", len(stations))
-	return nil
+	rememberOwners(stations)
+	recordStatusHistory(ctx, stations)
+	logger.InfoContext(ctx, "batch upserted stations", "count", len(stations))
+	return stations, nil
 }
 
+// BatchUpsertVehicles upserts multiple vehicles in a single request and
+// returns the ones written
+func BatchUpsertVehicles(ctx context.Context, vehicles []*vehicleMapper.VehicleRecord) ([]*vehicleMapper.VehicleRecord, error) {
+	ctx, span := tracer.Start(ctx, "supabase.upsert_vehicles")
+	defer span.End()
 
-// VehicleRecord represents a vehicle for Supabase upsert
-type VehicleRecord struct {
-	ID            string         `json:"id"`
-	NetworkID     string         `json:"network_id"`
-	Location      string         `json:"location"`
-	VehicleType   *string        `json:"vehicle_type,omitempty"`
-	IsReserved    *bool          `json:"is_reserved,omitempty"`
-	IsDisabled    *bool          `json:"is_disabled,omitempty"`
-	BatteryLevel  *int           `json:"battery_level,omitempty"`
-	LastReported  *string        `json:"last_reported,omitempty"`
-	PricingPlanID *string        `json:"pricing_plan_id,omitempty"`
-	RentalURIs    map[string]any `json:"rental_uris,omitempty"`
-	RawData       map[string]any `json:"raw_data"`
-}
-
-// BatchUpsertVehicles upserts multiple vehicles in a single request
-func BatchUpsertVehicles(vehiclesData []map[string]any) error {
 	if Config == nil || Config.Client == nil {
-		return fmt.Errorf("supabase client not initialized")
+		return nil, fmt.Errorf("supabase client not initialized")
 	}
 
-	if len(vehiclesData) == 0 {
-		return nil
+	if len(vehicles) == 0 {
+		return nil, nil
 	}
 
-	verbose := envkeys.Environment.Verbose
+	verbose := logger.Enabled(ctx, slog.LevelDebug)
 
 	if verbose {
 		networkIDs := make(map[string]bool)
-		for _, vehicleData := range vehiclesData {
-			if networkID, ok := vehicleData["network_id"].(string); ok {
-				networkIDs[networkID] = true
-			}
+		for _, vehicle := range vehicles {
+			networkIDs[vehicle.NetworkID] = true
 		}
 		networkIDList := make([]string, 0, len(networkIDs))
 		for id := range networkIDs {
 			networkIDList = append(networkIDList, id)
 		}
-		log.Printf("🛴 DEBUG: Unique network_ids in vehicle batch: %v", networkIDList)
+		logger.Debug("unique network_ids in vehicle batch", "network_ids", networkIDList)
 	}
 
-	// Convert all vehicle data to VehicleRecords
-	vehicles := make([]VehicleRecord, 0, len(vehiclesData))
-	for i, vehicleData := range vehiclesData {
-		jsonData, err := json.Marshal(vehicleData)
-		if err != nil {
-			if verbose {
-				log.Printf("Warning: failed to marshal vehicle data at index %d: %v", i, err)
-			}
-			continue
-		}
-
-		var vehicle VehicleRecord
-		if err := json.Unmarshal(jsonData, &vehicle); err != nil {
-			if verbose {
-				log.Printf("Warning: failed to unmarshal vehicle data at index %d: %v", i, err)
-			}
-			continue
-		}
-
-		vehicles = append(vehicles, vehicle)
-	}
-
-	if len(vehicles) == 0 {
-		return fmt.Errorf("no valid vehicles to upsert")
-	}
+	span.SetAttributes(tracing.Count(len(vehicles)))
 
 	// Batch upsert to vehicle table
 	_, _, err := Config.Client.From("vehicle").
@@ -201,19 +147,19 @@ func BatchUpsertVehicles(vehiclesData []map[string]any) error {
 		Execute()
 
 	if err != nil {
-		log.Printf("❌ Batch upsert failed for %d vehicles: %v", len(vehicles), err)
+		logger.ErrorContext(ctx, "batch upsert failed for vehicles", "count", len(vehicles), logging.Err(err))
 
 		if verbose {
 			failedNetworkIDs := make(map[string]int)
 			for _, v := range vehicles {
 				failedNetworkIDs[v.NetworkID]++
 			}
-			log.Printf("❌ DEBUG: Failed batch network_ids (with vehicle counts): %v", failedNetworkIDs)
+			logger.Debug("failed vehicle batch network_ids", "vehicle_counts", failedNetworkIDs)
 		}
 
-		return fmt.Errorf("failed to batch upsert %d vehicles: %v", len(vehicles), err)
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to batch upsert %d vehicles: %v", len(vehicles), err))
 	}
 
-	log.Printf("🛴 Batch upserted %d vehicles", len(vehicles))
-	return nil
+	logger.InfoContext(ctx, "batch upserted vehicles", "count", len(vehicles))
+	return vehicles, nil
 }
@@ -0,0 +1,107 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
+	"time"
+
+	"github.com/supabase-community/postgrest-go"
+)
+
+// StaleNetwork is a row of bikeshare.network_staleness
+type StaleNetwork struct {
+	ID              string  `json:"id"`
+	Name            string  `json:"name"`
+	CitybikesID     string  `json:"citybikes_id"`      // Network ID on citybik.es
+	OldestFetchedAt *string `json:"oldest_fetched_at"` // nil when the network has no stations yet
+}
+
+// FetchStaleNetworks returns up to limit active networks with a station
+// last fetched before threshold, or no stations at all, stalest first
+func FetchStaleNetworks(ctx context.Context, threshold time.Time, limit int) ([]StaleNetwork, error) {
+	ctx, span := tracer.Start(ctx, "supabase.fetch_stale_networks")
+	defer span.End()
+
+	if Config == nil || Config.Client == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	cutoff := threshold.UTC().Format(time.RFC3339)
+	data, _, err := Config.Client.From("network_staleness").
+		Select("id,name,citybikes_id,oldest_fetched_at", "", false).
+		Or("oldest_fetched_at.is.null,oldest_fetched_at.lt."+cutoff, "").
+		Order("oldest_fetched_at", &postgrest.OrderOpts{Ascending: true, NullsFirst: true}).
+		Limit(limit, "").
+		Execute()
+	if err != nil {
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to fetch stale networks: %v", err))
+	}
+
+	var networks []StaleNetwork
+	if err := json.Unmarshal(data, &networks); err != nil {
+		return nil, tracing.RecordError(span, fmt.Errorf("failed to parse stale networks: %v", err))
+	}
+
+	span.SetAttributes(tracing.Count(len(networks)))
+	logger.DebugContext(ctx, "fetched stale networks", "count", len(networks), "threshold", cutoff)
+	return networks, nil
+}
+
+// MarkStationsRemoved sets removed_at on the network's stations that are not
+// in listed, the station IDs the network currently reports upstream. It
+// returns the IDs it marked.
+func MarkStationsRemoved(ctx context.Context, networkID string, listed map[string]bool) ([]string, error) {
+	ctx, span := tracer.Start(ctx, "supabase.mark_stations_removed")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(networkID))
+
+	if Config == nil || Config.Client == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var removed []string
+	for from := 0; ; from += storedNetworksPageSize {
+		data, _, err := Config.Client.From("station").
+			Select("id", "", false).
+			Eq("network_id", networkID).
+			Is("removed_at", "null").
+			Order("id", nil).
+			Range(from, from+storedNetworksPageSize-1, "").
+			Execute()
+		if err != nil {
+			return nil, tracing.RecordError(span, fmt.Errorf("failed to fetch stations of network %s: %v", networkID, err))
+		}
+
+		var page []struct {
+			ID string `json:"id"`
+		}
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, tracing.RecordError(span, fmt.Errorf("failed to parse stations of network %s: %v", networkID, err))
+		}
+		for _, station := range page {
+			if !listed[station.ID] {
+				removed = append(removed, station.ID)
+			}
+		}
+		if len(page) < storedNetworksPageSize {
+			break
+		}
+	}
+
+	update := map[string]any{"removed_at": time.Now().UTC().Format(time.RFC3339)}
+	for i := 0; i < len(removed); i += 100 {
+		end := min(i+100, len(removed))
+		if _, _, err := Config.Client.From("station").Update(update, "minimal", "").In("id", removed[i:end]).Execute(); err != nil {
+			return removed[:i], tracing.RecordError(span, fmt.Errorf("failed to mark stations removed: %v", err))
+		}
+	}
+
+	span.SetAttributes(tracing.Count(len(removed)))
+	if len(removed) > 0 {
+		logger.InfoContext(ctx, "marked vanished stations removed", logging.NetworkID(networkID), "count", len(removed))
+	}
+	return removed, nil
+}
@@ -0,0 +1,52 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/tracing"
+)
+
+// TripFlow is a count of inferred trips between two stations that started
+// in one hour, added to bikeshare.trip_flow
+type TripFlow struct {
+	NetworkID            string `json:"network_id"`
+	HourStart            string `json:"hour_start"`
+	OriginStationID      string `json:"origin_station_id"`
+	DestinationStationID string `json:"destination_station_id"`
+	Trips                int    `json:"trips"`
+	TotalDuration        int64  `json:"total_duration_seconds"`
+}
+
+// AddTripFlows adds the flows to the hourly OD matrix. Each pair may appear
+// only once.
+func AddTripFlows(ctx context.Context, flows []TripFlow) error {
+	ctx, span := tracer.Start(ctx, "supabase.add_trip_flows")
+	defer span.End()
+
+	if Config == nil || Config.Client == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	if len(flows) == 0 {
+		return nil
+	}
+	span.SetAttributes(tracing.Count(len(flows)))
+
+	// Rpc returns the response body: the rows touched, or a PostgREST error
+	body := Config.Client.Rpc("add_trip_flows", "", map[string]any{"flows": flows})
+
+	var touched int
+	if err := json.Unmarshal([]byte(body), &touched); err != nil {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal([]byte(body), &apiError) == nil && apiError.Message != "" {
+			return tracing.RecordError(span, fmt.Errorf("failed to add %d trip flows: %s", len(flows), apiError.Message))
+		}
+		return tracing.RecordError(span, fmt.Errorf("failed to add %d trip flows: unexpected response %q", len(flows), body))
+	}
+
+	logger.DebugContext(ctx, "added trip flows", "count", touched)
+	return nil
+}
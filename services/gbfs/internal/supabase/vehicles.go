@@ -0,0 +1,105 @@
+package supabase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/logging"
+	"gbfs-service/internal/tracing"
+	"time"
+)
+
+// StoredVehicle is a vehicle row that hasn't been marked gone
+type StoredVehicle struct {
+	ID        string  `json:"id"`
+	FetchedAt *string `json:"fetched_at"`
+}
+
+// FetchPresentVehicles returns the network's vehicles that aren't marked gone
+func FetchPresentVehicles(ctx context.Context, networkID string) ([]StoredVehicle, error) {
+	ctx, span := tracer.Start(ctx, "supabase.fetch_present_vehicles")
+	defer span.End()
+	span.SetAttributes(tracing.NetworkID(networkID))
+
+	if Config == nil || Config.Client == nil {
+		return nil, fmt.Errorf("supabase client not initialized")
+	}
+
+	var vehicles []StoredVehicle
+	for from := 0; ; from += storedNetworksPageSize {
+		data, _, err := Config.Client.From("vehicle").
+			Select("id,fetched_at", "", false).
+			Eq("network_id", networkID).
+			Is("gone_at", "null").
+			Order("id", nil).
+			Range(from, from+storedNetworksPageSize-1, "").
+			Execute()
+		if err != nil {
+			return nil, tracing.RecordError(span, fmt.Errorf("failed to fetch vehicles of network %s: %v", networkID, err))
+		}
+
+		var page []StoredVehicle
+		if err := json.Unmarshal(data, &page); err != nil {
+			return nil, tracing.RecordError(span, fmt.Errorf("failed to parse vehicles of network %s: %v", networkID, err))
+		}
+		vehicles = append(vehicles, page...)
+		if len(page) < storedNetworksPageSize {
+			break
+		}
+	}
+
+	span.SetAttributes(tracing.Count(len(vehicles)))
+	logger.DebugContext(ctx, "fetched present vehicles", logging.NetworkID(networkID), "count", len(vehicles))
+	return vehicles, nil
+}
+
+// MarkVehiclesGone sets gone_at to now and last_seen_at to lastSeen on the
+// given vehicles
+func MarkVehiclesGone(ctx context.Context, ids []string, lastSeen time.Time) error {
+	ctx, span := tracer.Start(ctx, "supabase.mark_vehicles_gone")
+	defer span.End()
+	span.SetAttributes(tracing.Count(len(ids)))
+
+	if Config == nil || Config.Client == nil {
+		return fmt.Errorf("supabase client not initialized")
+	}
+
+	update := map[string]any{
+		"gone_at":      time.Now().UTC().Format(time.RFC3339),
+		"last_seen_at": lastSeen.UTC().Format(time.RFC3339),
+	}
+	for i := 0; i < len(ids); i += 100 {
+		end := min(i+100, len(ids))
+		if _, _, err := Config.Client.From("vehicle").Update(update, "minimal", "").In("id", ids[i:end]).Execute(); err != nil {
+			return tracing.RecordError(span, fmt.Errorf("failed to mark vehicles gone: %v", err))
+		}
+	}
+
+	logger.DebugContext(ctx, "marked vehicles gone", "count", len(ids))
+	return nil
+}
+
+// DeleteGoneVehicles deletes vehicles marked gone before cutoff and returns
+// how many were deleted
+func DeleteGoneVehicles(ctx context.Context, cutoff time.Time) (int64, error) {
+	ctx, span := tracer.Start(ctx, "supabase.delete_gone_vehicles")
+	defer span.End()
+
+	if Config == nil || Config.Client == nil {
+		return 0, fmt.Errorf("supabase client not initialized")
+	}
+
+	_, count, err := Config.Client.From("vehicle").
+		Delete("minimal", "exact").
+		Lt("gone_at", cutoff.UTC().Format(time.RFC3339)).
+		Execute()
+	if err != nil {
+		return 0, tracing.RecordError(span, fmt.Errorf("failed to delete gone vehicles: %v", err))
+	}
+
+	span.SetAttributes(tracing.Count(int(count)))
+	if count > 0 {
+		logger.InfoContext(ctx, "deleted gone vehicles", "count", count)
+	}
+	return count, nil
+}
@@ -0,0 +1,23 @@
+package timestamps
+
+import "time"
+
+// layouts are tried in order. Fractional seconds are accepted by all of
+// them; the ones without an offset are read as UTC.
+var layouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05Z07",
+	"2006-01-02 15:04:05Z07:00",
+	"2006-01-02 15:04:05Z0700",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// millisFrom is the smallest epoch value read as milliseconds rather than
+// seconds: 1e11 seconds is in the year 5138, 1e11 milliseconds in 1973
+const millisFrom = 1e11
+
+// layout is how timestamps are written: UTC with microseconds, the
+// precision timestamptz stores, and no trailing zeros
+const layout = "2006-01-02T15:04:05.999999Z07:00"
@@ -0,0 +1,9 @@
+package timestamps
+
+import "time"
+
+// Timestamp is a normalized point in time and whether the source sent it
+type Timestamp struct {
+	Time        time.Time
+	Synthesized bool // The source sent none we could read; Time is when it was fetched
+}
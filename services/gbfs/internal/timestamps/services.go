@@ -0,0 +1,94 @@
+package timestamps
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Parse reads a timestamp the way feeds send them: RFC 3339 with or without
+// fractional seconds, offsets with or without a colon or missing (UTC), or
+// epoch seconds or milliseconds as GBFS uses, optionally with a fraction.
+// The result is in UTC.
+func Parse(value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, fmt.Errorf("empty timestamp")
+	}
+
+	if parsed, ok := parseEpoch(value); ok {
+		return parsed, nil
+	}
+
+	for _, layout := range layouts {
+		if parsed, err := time.Parse(layout, value); err == nil {
+			return parsed.UTC(), nil
+		}
+	}
+
+	// Some feeds append a Z to a timestamp that already has an offset
+	if trimmed, ok := strings.CutSuffix(value, "Z"); ok {
+		for _, layout := range layouts {
+			if parsed, err := time.Parse(layout, trimmed); err == nil {
+				return parsed.UTC(), nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("unrecognized timestamp %q", value)
+}
+
+// parseEpoch reads seconds or milliseconds since 1970, keeping every digit
+// of the fraction
+func parseEpoch(value string) (time.Time, bool) {
+	whole, fraction, _ := strings.Cut(value, ".")
+	if !digits(whole) || (fraction != "" && !digits(fraction)) {
+		return time.Time{}, false
+	}
+
+	n, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	// The fraction in nanoseconds of the unit
+	fraction = (fraction + "000000000")[:9]
+	nanos, _ := strconv.ParseInt(fraction, 10, 64)
+
+	if n >= millisFrom {
+		return time.UnixMilli(n).Add(time.Duration(nanos) * time.Millisecond / time.Second).UTC(), true
+	}
+	return time.Unix(n, nanos).UTC(), true
+}
+
+func digits(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// Resolve parses value, falling back to now, marked synthesized, if it is
+// missing or unreadable
+func Resolve(value string, now time.Time) Timestamp {
+	if parsed, err := Parse(value); err == nil {
+		return Timestamp{Time: parsed}
+	}
+	return Timestamp{Time: now.UTC(), Synthesized: true}
+}
+
+// Format writes t in UTC to the microsecond
+func Format(t time.Time) string {
+	return t.UTC().Format(layout)
+}
+
+// String is the formatted time
+func (t Timestamp) String() string {
+	return Format(t.Time)
+}
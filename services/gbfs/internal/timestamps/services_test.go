@@ -0,0 +1,98 @@
+package timestamps
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParse(t *testing.T) {
+	// 2026-01-05T08:00:00Z is 1767600000
+	at := func(nanos int) time.Time {
+		return time.Date(2026, 1, 5, 8, 0, 0, nanos, time.UTC)
+	}
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		// Epoch seconds and milliseconds
+		{"1767600000", at(0)},
+		{"1767600000.25", at(250_000_000)},
+		{"1767600000.123456789", at(123_456_789)},
+		{"1767600000123", at(123_000_000)},
+		{"1767600000123.456", at(123_456_000)},
+		{"1767600000123.000001", at(123_000_001)},
+		{"1767600000123.0000001", at(123_000_000)}, // Below a nanosecond
+		{" 1767600000 ", at(0)},
+
+		// Either side of the seconds/milliseconds threshold
+		{"99999999999", time.Date(5138, 11, 16, 9, 46, 39, 0, time.UTC)},
+		{"100000000000", time.Date(1973, 3, 3, 9, 46, 40, 0, time.UTC)},
+
+		// RFC 3339 and its variants
+		{"2026-01-05T08:00:00Z", at(0)},
+		{"2026-01-05T08:00:00.5Z", at(500_000_000)},
+		{"2026-01-05T10:00:00+02:00", at(0)},
+		{"2026-01-05T10:00:00+0200", at(0)},
+		{"2026-01-05T10:00:00+02", at(0)},
+		{"2026-01-05T03:30:00.000001-04:30", at(1000)},
+		{"2026-01-05 10:00:00+02:00", at(0)},
+		{"2026-01-05 10:00:00+0200", at(0)},
+
+		// A Z appended to an offset
+		{"2026-01-05T10:00:00+02:00Z", at(0)},
+		{"2026-01-05T10:00:00.25+0200Z", at(250_000_000)},
+
+		// Naive timestamps are UTC
+		{"2026-01-05T08:00:00", at(0)},
+		{"2026-01-05T08:00:00.123", at(123_000_000)},
+		{"2026-01-05 08:00:00", at(0)},
+	}
+
+	for _, tt := range tests {
+		got, err := Parse(tt.value)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) || got.Location() != time.UTC {
+			t.Errorf("Parse(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestParseRejects(t *testing.T) {
+	for _, value := range []string{"", "  ", "yesterday", "1.2.3", "-1767600000", "0x10", "2026-13-05T08:00:00Z", "2026-01-05"} {
+		if got, err := Parse(value); err == nil {
+			t.Errorf("Parse(%q) = %s, want an error", value, got)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	now := time.Date(2026, 1, 5, 9, 0, 0, 0, time.FixedZone("CET", 3600))
+
+	if got := Resolve("1767600000", now); got.Synthesized || !got.Time.Equal(time.Unix(1767600000, 0)) {
+		t.Errorf("Resolve of a valid timestamp = %+v", got)
+	}
+	if got := Resolve("garbage", now); !got.Synthesized || !got.Time.Equal(now) || got.Time.Location() != time.UTC {
+		t.Errorf("Resolve of garbage = %+v, want now in UTC, synthesized", got)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	tests := []struct {
+		t    time.Time
+		want string
+	}{
+		{time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC), "2026-01-05T08:00:00Z"},
+		{time.Date(2026, 1, 5, 8, 0, 0, 250_000_000, time.UTC), "2026-01-05T08:00:00.25Z"},
+		{time.Date(2026, 1, 5, 8, 0, 0, 123_456_789, time.UTC), "2026-01-05T08:00:00.123456Z"},
+		{time.Date(2026, 1, 5, 10, 0, 0, 0, time.FixedZone("EET", 7200)), "2026-01-05T08:00:00Z"},
+	}
+	for _, tt := range tests {
+		if got := Format(tt.t); got != tt.want {
+			t.Errorf("Format(%s) = %s, want %s", tt.t, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,9 @@
+package tracing
+
+import "gbfs-service/internal/logging"
+
+// instrumentationPrefix scopes tracer names; the service name itself comes
+// from config and is set on the resource in Setup
+const instrumentationPrefix = "gbfs-service/"
+
+var logger = logging.For("tracing")
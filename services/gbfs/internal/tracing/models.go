@@ -0,0 +1,31 @@
+package tracing
+
+import "go.opentelemetry.io/otel/attribute"
+
+// Attribute keys mirror the logging keys so spans and logs can be joined
+const (
+	KeyNetworkID = attribute.Key("network_id")
+	KeyStationID = attribute.Key("station_id")
+	KeyVehicleID = attribute.Key("vehicle_id")
+	KeyCount     = attribute.Key("count")
+)
+
+// NetworkID returns the network_id span attribute
+func NetworkID(id string) attribute.KeyValue {
+	return KeyNetworkID.String(id)
+}
+
+// StationID returns the station_id span attribute
+func StationID(id string) attribute.KeyValue {
+	return KeyStationID.String(id)
+}
+
+// VehicleID returns the vehicle_id span attribute
+func VehicleID(id string) attribute.KeyValue {
+	return KeyVehicleID.String(id)
+}
+
+// Count returns the count span attribute
+func Count(n int) attribute.KeyValue {
+	return KeyCount.Int(n)
+}
@@ -0,0 +1,19 @@
+package tripinference
+
+import (
+	"gbfs-service/internal/logging"
+	"time"
+)
+
+var logger = logging.For("trip-inference")
+
+// maxSpeedFactor bounds the speed a pair may imply, as a multiple of the
+// typical speed; faster pairs can't be one rider
+const maxSpeedFactor = 2.5
+
+// shutdownFlushTimeout bounds the flush made when the service stops
+const shutdownFlushTimeout = 10 * time.Second
+
+// maxPending bounds the departures waiting for an arrival per network; the
+// oldest are dropped first
+const maxPending = 10_000
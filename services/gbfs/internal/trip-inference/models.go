@@ -0,0 +1,55 @@
+package tripinference
+
+import (
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/geo"
+	"time"
+)
+
+// Stats counts what was inferred for a network since startup
+type Stats struct {
+	Network            string `json:"network"`
+	Departures         uint64 `json:"departures"`
+	Arrivals           uint64 `json:"arrivals"`
+	Trips              uint64 `json:"trips"`               // Arrivals paired with a departure
+	UnpairedDepartures uint64 `json:"unpaired_departures"` // Expired or dropped without an arrival
+	UnpairedArrivals   uint64 `json:"unpaired_arrivals"`
+	Rebalanced         uint64 `json:"rebalanced"` // Bikes moved in changes above max_delta
+	Pending            int    `json:"pending"`    // Departures waiting for an arrival
+}
+
+// departure is a bike that left a station
+type departure struct {
+	stationID string
+	location  geo.Point
+	at        time.Time
+}
+
+// network is the inference state of one network
+type network struct {
+	id         string         // Mapped network UUID
+	bikes      map[string]int // Last bike count per mapped station ID
+	departures []departure    // Oldest first
+	stats      Stats
+}
+
+// flowKey identifies a cell of the hourly OD matrix
+type flowKey struct {
+	networkID   string
+	hour        int64 // Unix seconds of the hour the trips started
+	origin      string
+	destination string
+}
+
+// flow is the trips of one cell not yet written
+type flow struct {
+	trips    int
+	duration time.Duration
+}
+
+// engine is the inference state shared by every network
+type engine struct {
+	cfg      config.TripsConfig
+	networks map[string]*network // Keyed by citybik.es network ID
+	flows    map[flowKey]flow
+}
@@ -0,0 +1,269 @@
+package tripinference
+
+import (
+	"cmp"
+	"context"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/geo"
+	"gbfs-service/internal/logging"
+	stationMapper "gbfs-service/internal/station-mapper"
+	supabaseClient "gbfs-service/internal/supabase"
+	"math"
+	"slices"
+	"sync"
+	"time"
+)
+
+var state = struct {
+	sync.Mutex
+	engine
+}{
+	engine: engine{
+		networks: make(map[string]*network),
+		flows:    make(map[flowKey]flow),
+	},
+}
+
+// addTripFlows writes flows to the database; tests replace it
+var addTripFlows = supabaseClient.AddTripFlows
+
+// Configure applies the inference settings; inference is off until it is
+// called with an enabled config
+func Configure(cfg config.TripsConfig) {
+	state.Lock()
+	defer state.Unlock()
+
+	state.cfg = cfg
+	logger.Info("trip inference configured",
+		"enabled", cfg.Enabled,
+		"min_duration", cfg.MinDuration,
+		"max_duration", cfg.MaxDuration,
+		"speed_kmh", cfg.Speed,
+		"max_delta", cfg.MaxDelta,
+	)
+}
+
+// Observe feeds a station update seen at now. The first update of a station
+// only sets its bike count; later ones infer departures and arrivals from
+// the change. Stations out of service are tracked but infer nothing.
+func Observe(record *stationMapper.StationRecord, networkID string, now time.Time) {
+	state.Lock()
+	defer state.Unlock()
+
+	if !state.cfg.Enabled {
+		return
+	}
+
+	n := state.networks[networkID]
+	if n == nil {
+		n = &network{id: record.NetworkID, bikes: make(map[string]int), stats: Stats{Network: networkID}}
+		state.networks[networkID] = n
+	}
+
+	bikes := record.NumBikesAvailable + record.NumEbikesAvailable
+	previous, seen := n.bikes[record.ID]
+	n.bikes[record.ID] = bikes
+	if !seen || !record.IsOperational {
+		return
+	}
+
+	location := geo.Point{Latitude: record.Latitude, Longitude: record.Longitude}
+
+	state.expire(n, now)
+
+	delta := bikes - previous
+	switch {
+	case delta == 0:
+	case abs(delta) > state.cfg.MaxDelta:
+		n.stats.Rebalanced += uint64(abs(delta))
+	case delta < 0:
+		for range -delta {
+			n.departures = append(n.departures, departure{stationID: record.ID, location: location, at: now})
+		}
+		n.stats.Departures += uint64(-delta)
+		if dropped := len(n.departures) - maxPending; dropped > 0 {
+			n.departures = slices.Delete(n.departures, 0, dropped)
+			n.stats.UnpairedDepartures += uint64(dropped)
+		}
+	default:
+		for range delta {
+			state.arrive(n, record.ID, location, now)
+		}
+		n.stats.Arrivals += uint64(delta)
+	}
+	n.stats.Pending = len(n.departures)
+}
+
+// expire drops the departures that waited longer than a trip can take
+func (e *engine) expire(n *network, now time.Time) {
+	expired := 0
+	for expired < len(n.departures) && now.Sub(n.departures[expired].at) > e.cfg.MaxDuration {
+		expired++
+	}
+	if expired > 0 {
+		n.departures = slices.Delete(n.departures, 0, expired)
+		n.stats.UnpairedDepartures += uint64(expired)
+	}
+}
+
+// arrive pairs an arrival with the pending departure whose elapsed time is
+// closest to the time riding the distance takes, and adds the trip to its
+// flow. Departures too recent or too far for the time elapsed don't pair.
+func (e *engine) arrive(n *network, stationID string, location geo.Point, now time.Time) {
+	best, bestScore := -1, math.Inf(1)
+	for i, candidate := range n.departures {
+		elapsed := now.Sub(candidate.at)
+		if elapsed < e.cfg.MinDuration || elapsed <= 0 {
+			break // Departures are oldest first
+		}
+
+		km := geo.Distance(candidate.location, location)
+		if km/elapsed.Hours() > e.cfg.Speed*maxSpeedFactor {
+			continue
+		}
+
+		expected := km / e.cfg.Speed // Hours
+		if score := math.Abs(elapsed.Hours() - expected); score < bestScore {
+			best, bestScore = i, score
+		}
+	}
+
+	if best < 0 {
+		n.stats.UnpairedArrivals++
+		return
+	}
+
+	origin := n.departures[best]
+	n.departures = slices.Delete(n.departures, best, best+1)
+	n.stats.Trips++
+
+	key := flowKey{
+		networkID:   n.id,
+		hour:        origin.at.UTC().Truncate(time.Hour).Unix(),
+		origin:      origin.stationID,
+		destination: stationID,
+	}
+	f := e.flows[key]
+	f.trips++
+	f.duration += now.Sub(origin.at)
+	e.flows[key] = f
+}
+
+// Flush adds the trips inferred since the last flush to the hourly OD
+// matrix. Flows that fail to write are kept for the next flush.
+func Flush(ctx context.Context) error {
+	state.Lock()
+	pending := state.flows
+	state.flows = make(map[flowKey]flow)
+	state.Unlock()
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	keys := make([]flowKey, 0, len(pending))
+	for key := range pending {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b flowKey) int {
+		return cmp.Or(
+			cmp.Compare(a.networkID, b.networkID),
+			cmp.Compare(a.hour, b.hour),
+			cmp.Compare(a.origin, b.origin),
+			cmp.Compare(a.destination, b.destination),
+		)
+	})
+
+	flows := make([]supabaseClient.TripFlow, 0, len(keys))
+	for _, key := range keys {
+		f := pending[key]
+		flows = append(flows, supabaseClient.TripFlow{
+			NetworkID:            key.networkID,
+			HourStart:            time.Unix(key.hour, 0).UTC().Format(time.RFC3339),
+			OriginStationID:      key.origin,
+			DestinationStationID: key.destination,
+			Trips:                f.trips,
+			TotalDuration:        int64(f.duration / time.Second),
+		})
+	}
+
+	if err := addTripFlows(ctx, flows); err != nil {
+		// Put the flows back, merged with whatever was inferred meanwhile
+		state.Lock()
+		for key, f := range pending {
+			merged := state.flows[key]
+			merged.trips += f.trips
+			merged.duration += f.duration
+			state.flows[key] = merged
+		}
+		state.Unlock()
+		return err
+	}
+
+	logger.InfoContext(ctx, "flushed trip flows", "count", len(flows))
+	return nil
+}
+
+// Run flushes the inferred trips every interval until ctx is done, then
+// once more so the trips since the last flush aren't lost
+func Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			flushOnShutdown(ctx)
+			return
+		case <-ticker.C:
+		}
+
+		if err := Flush(ctx); err != nil {
+			logger.WarnContext(ctx, "trip flow flush failed", logging.Err(err))
+		}
+	}
+}
+
+// flushOnShutdown flushes for at most shutdownFlushTimeout. The write
+// doesn't stop with its context, so the wait is bounded here.
+func flushOnShutdown(ctx context.Context) {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), shutdownFlushTimeout)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() { done <- Flush(ctx) }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			logger.WarnContext(ctx, "trip flow flush on shutdown failed", logging.Err(err))
+		}
+	case <-ctx.Done():
+		logger.WarnContext(ctx, "trip flow flush on shutdown timed out", "timeout", shutdownFlushTimeout)
+	}
+}
+
+// AllStats returns the inference statistics, of one network if networkID
+// is set
+func AllStats(networkID string) []Stats {
+	state.Lock()
+	defer state.Unlock()
+
+	var all []Stats
+	for id, n := range state.networks {
+		if networkID != "" && id != networkID {
+			continue
+		}
+		all = append(all, n.stats)
+	}
+	slices.SortFunc(all, func(a, b Stats) int { return cmp.Compare(a.Network, b.Network) })
+	return all
+}
+
+// abs returns the absolute value of v
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
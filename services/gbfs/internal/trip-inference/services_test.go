@@ -0,0 +1,260 @@
+package tripinference
+
+import (
+	"context"
+	"errors"
+	"gbfs-service/internal/config"
+	"gbfs-service/internal/geo"
+	stationMapper "gbfs-service/internal/station-mapper"
+	supabaseClient "gbfs-service/internal/supabase"
+	"slices"
+	"testing"
+	"time"
+)
+
+// Stations about 1.1 km (a to b) and 11 km (a to far) apart
+var locations = map[string]geo.Point{
+	"a":   {Latitude: 48.80, Longitude: 2.35},
+	"b":   {Latitude: 48.81, Longitude: 2.35},
+	"far": {Latitude: 48.90, Longitude: 2.35},
+}
+
+// observation is a station update at minutes past start
+type observation struct {
+	minutes      float64
+	station      string
+	bikes        int
+	outOfOrder   bool
+	otherNetwork bool
+}
+
+// observe feeds o to Observe, minutes after start
+func observe(start time.Time, o observation) {
+	network := "velib"
+	if o.otherNetwork {
+		network = "other"
+	}
+	location := locations[o.station]
+	Observe(&stationMapper.StationRecord{
+		ID:                o.station,
+		NetworkID:         "net",
+		Latitude:          location.Latitude,
+		Longitude:         location.Longitude,
+		NumBikesAvailable: o.bikes,
+		IsOperational:     !o.outOfOrder,
+	}, network, start.Add(time.Duration(o.minutes*float64(time.Minute))))
+}
+
+// reset restores a fresh engine with cfg and a writer recording its flows
+func reset(t *testing.T, cfg config.TripsConfig) *[]supabaseClient.TripFlow {
+	t.Helper()
+
+	var written []supabaseClient.TripFlow
+	previous := addTripFlows
+	addTripFlows = func(ctx context.Context, flows []supabaseClient.TripFlow) error {
+		written = append(written, flows...)
+		return nil
+	}
+	t.Cleanup(func() { addTripFlows = previous })
+
+	state.Lock()
+	state.engine = engine{cfg: cfg, networks: make(map[string]*network), flows: make(map[flowKey]flow)}
+	state.Unlock()
+	return &written
+}
+
+func enabled() config.TripsConfig {
+	cfg := config.Defaults().Trips
+	cfg.Enabled = true
+	return cfg
+}
+
+func TestObserveAndFlush(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 40, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		cfg          func(*config.TripsConfig)
+		observations []observation
+		want         []supabaseClient.TripFlow
+		stats        Stats
+	}{
+		{
+			name: "departure paired with a plausible arrival",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "b", 5, false, false},
+				{1, "a", 4, false, false},
+				{7, "b", 6, false, false},
+			},
+			want: []supabaseClient.TripFlow{
+				{NetworkID: "net", HourStart: "2026-01-05T08:00:00Z", OriginStationID: "a", DestinationStationID: "b", Trips: 1, TotalDuration: 360},
+			},
+			stats: Stats{Departures: 1, Arrivals: 1, Trips: 1},
+		},
+		{
+			name: "arrival picks the departure fitting the ride time",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "far", 5, false, false},
+				{0, "b", 5, false, false},
+				{1, "far", 4, false, false}, // 11 km away, 6 min before: too fast
+				{2, "a", 4, false, false},   // 1.1 km away, 5 min before
+				{7, "b", 6, false, false},
+			},
+			want: []supabaseClient.TripFlow{
+				{NetworkID: "net", HourStart: "2026-01-05T08:00:00Z", OriginStationID: "a", DestinationStationID: "b", Trips: 1, TotalDuration: 300},
+			},
+			stats: Stats{Departures: 2, Arrivals: 1, Trips: 1, Pending: 1},
+		},
+		{
+			name: "too quick a return is a re-dock, not a trip",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{1, "a", 4, false, false},
+				{1.5, "a", 5, false, false},
+			},
+			stats: Stats{Departures: 1, Arrivals: 1, UnpairedArrivals: 1, Pending: 1},
+		},
+		{
+			name: "departures expire after max_duration",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "b", 5, false, false},
+				{1, "a", 4, false, false},
+				{47, "b", 6, false, false},
+			},
+			stats: Stats{Departures: 1, Arrivals: 1, UnpairedDepartures: 1, UnpairedArrivals: 1},
+		},
+		{
+			name: "changes above max_delta are rebalancing",
+			observations: []observation{
+				{0, "a", 10, false, false},
+				{0, "b", 0, false, false},
+				{1, "a", 2, false, false},
+				{20, "b", 8, false, false},
+			},
+			stats: Stats{Rebalanced: 16},
+		},
+		{
+			name: "trips count in the hour they started",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "b", 5, false, false},
+				{15, "a", 3, false, false}, // 08:55
+				{22, "b", 6, false, false}, // 09:02
+				{25, "a", 2, false, false}, // 09:05
+				{31, "b", 8, false, false}, // 09:11, two arrivals
+			},
+			want: []supabaseClient.TripFlow{
+				{NetworkID: "net", HourStart: "2026-01-05T08:00:00Z", OriginStationID: "a", DestinationStationID: "b", Trips: 2, TotalDuration: 7*60 + 16*60},
+				{NetworkID: "net", HourStart: "2026-01-05T09:00:00Z", OriginStationID: "a", DestinationStationID: "b", Trips: 1, TotalDuration: 6 * 60},
+			},
+			stats: Stats{Departures: 3, Arrivals: 3, Trips: 3},
+		},
+		{
+			name: "stations out of service infer nothing",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{1, "a", 4, true, false},
+				{2, "a", 3, false, false},
+			},
+			stats: Stats{Departures: 1, Pending: 1},
+		},
+		{
+			name: "networks don't pair with each other",
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "b", 5, false, true},
+				{1, "a", 4, false, false},
+				{7, "b", 6, false, true},
+			},
+			stats: Stats{Departures: 1, Pending: 1},
+		},
+		{
+			name: "no same-instant trip without a minimum duration",
+			cfg:  func(cfg *config.TripsConfig) { cfg.MinDuration = 0 },
+			observations: []observation{
+				{0, "a", 5, false, false},
+				{0, "b", 5, false, false},
+				{1, "a", 4, false, false},
+				{1, "a", 5, false, false},
+				{1, "b", 6, false, false},
+			},
+			stats: Stats{Departures: 1, Arrivals: 2, UnpairedArrivals: 2, Pending: 1},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := enabled()
+			if tt.cfg != nil {
+				tt.cfg(&cfg)
+			}
+			written := reset(t, cfg)
+
+			for _, o := range tt.observations {
+				observe(start, o)
+			}
+
+			if err := Flush(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if !slices.Equal(*written, tt.want) {
+				t.Errorf("flushed %+v, want %+v", *written, tt.want)
+			}
+
+			tt.stats.Network = "velib"
+			if stats := AllStats("velib"); len(stats) != 1 || stats[0] != tt.stats {
+				t.Errorf("stats %+v, want %+v", stats, tt.stats)
+			}
+		})
+	}
+}
+
+func TestFlushKeepsFlowsThatFailToWrite(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	written := reset(t, enabled())
+
+	for _, o := range []observation{{0, "a", 5, false, false}, {0, "b", 5, false, false}, {1, "a", 4, false, false}, {7, "b", 6, false, false}} {
+		observe(start, o)
+	}
+
+	recorder := addTripFlows
+	addTripFlows = func(context.Context, []supabaseClient.TripFlow) error { return errors.New("unavailable") }
+	if err := Flush(context.Background()); err == nil {
+		t.Fatal("flush didn't report the failed write")
+	}
+
+	// Another trip of the same hour and pair is merged into the kept one
+	observe(start, observation{10, "a", 3, false, false})
+	observe(start, observation{16, "b", 7, false, false})
+
+	addTripFlows = recorder
+	if err := Flush(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := []supabaseClient.TripFlow{
+		{NetworkID: "net", HourStart: "2026-01-05T08:00:00Z", OriginStationID: "a", DestinationStationID: "b", Trips: 2, TotalDuration: 12 * 60},
+	}
+	if !slices.Equal(*written, want) {
+		t.Errorf("flushed %+v, want %+v", *written, want)
+	}
+}
+
+func TestRunFlushesOnShutdown(t *testing.T) {
+	start := time.Date(2026, 1, 5, 8, 0, 0, 0, time.UTC)
+	written := reset(t, enabled())
+
+	for _, o := range []observation{{0, "a", 5, false, false}, {0, "b", 5, false, false}, {1, "a", 4, false, false}, {7, "b", 6, false, false}} {
+		observe(start, o)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	Run(ctx, time.Hour)
+
+	if len(*written) != 1 || (*written)[0].Trips != 1 {
+		t.Errorf("flushed %+v on shutdown, want the one trip", *written)
+	}
+}
@@ -0,0 +1,16 @@
+package uuidfy
+
+// namespaceURL is the RFC 4122 name space for URLs
+var namespaceURL = [16]byte{0x6b, 0xa7, 0xb8, 0x11, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+var (
+	// Root name space of every ID the service generates, derived from the
+	// citybik.es API URL so it can be reproduced anywhere
+	root = newV5(namespaceURL, "https://api.citybik.es/v2")
+
+	// Per-entity name spaces. Stations and vehicles are further scoped by
+	// their network, so a local ID like "1" differs between networks.
+	networkNamespace = newV5(root, "network")
+	stationNamespace = newV5(root, "station")
+	vehicleNamespace = newV5(root, "vehicle")
+)
@@ -6,7 +6,36 @@ import (
 	"fmt"
 )
 
+// Network returns the UUID of a citybik.es network
+func Network(networkID string) (string, error) {
+	if networkID == "" {
+		return "", fmt.Errorf("network ID is empty")
+	}
+	return format(newV5(networkNamespace, networkID)), nil
+}
+
+// Station returns the UUID of a station within a citybik.es network
+func Station(networkID, stationID string) (string, error) {
+	if networkID == "" || stationID == "" {
+		return "", fmt.Errorf("network and station IDs are required")
+	}
+	return format(newV5(newV5(stationNamespace, networkID), stationID)), nil
+}
+
+// Vehicle returns the UUID of a vehicle within a citybik.es network
+func Vehicle(networkID, vehicleID string) (string, error) {
+	if networkID == "" || vehicleID == "" {
+		return "", fmt.Errorf("network and vehicle IDs are required")
+	}
+	return format(newV5(newV5(vehicleNamespace, networkID), vehicleID)), nil
+}
+
 // UUIDfy generates a deterministic UUIDv5-like string from the input.
+//
+// Deprecated: the result has no version or variant bits and no name space,
+// so the same input collides across entities and networks. It is only kept
+// so cmd/migrate-ids can recognise IDs written before Network, Station and
+// Vehicle existed.
 func UUIDfy(otherid string) (string, error) {
 	if otherid == "" {
 		return "", fmt.Errorf("input string is empty")
@@ -34,3 +63,22 @@ func UUIDfy(otherid string) (string, error) {
 
 	return string(uuid), nil
 }
+
+// newV5 generates a name-based UUID using SHA-1 (RFC 4122, section 4.3)
+func newV5(namespace [16]byte, name string) [16]byte {
+	h := sha1.New()
+	h.Write(namespace[:])
+	h.Write([]byte(name))
+
+	var uuid [16]byte
+	copy(uuid[:], h.Sum(nil))
+	uuid[6] = uuid[6]&0x0f | 0x50 // Version 5
+	uuid[8] = uuid[8]&0x3f | 0x80 // RFC 4122 variant
+	return uuid
+}
+
+// format renders a UUID in its 8-4-4-4-12 string form
+func format(uuid [16]byte) string {
+	hexstr := hex.EncodeToString(uuid[:])
+	return hexstr[0:8] + "-" + hexstr[8:12] + "-" + hexstr[12:16] + "-" + hexstr[16:20] + "-" + hexstr[20:32]
+}
@@ -0,0 +1,95 @@
+package uuidfy
+
+import (
+	"encoding/hex"
+	"strings"
+	"testing"
+)
+
+// namespaceDNS is the RFC 4122 name space for domain names
+var namespaceDNS = [16]byte{0x6b, 0xa7, 0xb8, 0x10, 0x9d, 0xad, 0x11, 0xd1, 0x80, 0xb4, 0x00, 0xc0, 0x4f, 0xd4, 0x30, 0xc8}
+
+func TestNewV5(t *testing.T) {
+	// Python's uuid.uuid5(uuid.NAMESPACE_DNS, "python.org")
+	if got := format(newV5(namespaceDNS, "python.org")); got != "886313e1-3b8a-5372-9b90-0c9aee199e5d" {
+		t.Errorf("newV5(DNS, python.org) = %s, want the RFC 4122 result", got)
+	}
+}
+
+// TestIDs pins the generated IDs. They are the primary keys already stored,
+// so a change here re-keys the whole database; cmd/migrate-ids would be
+// needed to move existing rows.
+func TestIDs(t *testing.T) {
+	tests := []struct {
+		name     string
+		generate func() (string, error)
+		want     string
+	}{
+		{"network velib", func() (string, error) { return Network("velib") }, "decac0c2-7d7d-5114-a4d0-a1e0890fe308"},
+		{"network capital-bikeshare", func() (string, error) { return Network("capital-bikeshare") }, "e2bd6755-2e3f-510d-8f4e-783013d4b5b4"},
+		{"station 1 of velib", func() (string, error) { return Station("velib", "1") }, "e4fa2a99-21ad-5aad-a609-216314705958"},
+		{"station 1 of capital-bikeshare", func() (string, error) { return Station("capital-bikeshare", "1") }, "47ef6516-582f-58aa-a945-e65ae5e937b4"},
+		{"vehicle 1 of velib", func() (string, error) { return Vehicle("velib", "1") }, "070baa86-937c-5594-ac99-85b1e51fac25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.generate()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+
+			raw, err := hex.DecodeString(strings.ReplaceAll(got, "-", ""))
+			if err != nil || len(raw) != 16 {
+				t.Fatalf("%s isn't a UUID", got)
+			}
+			if version := raw[6] >> 4; version != 5 {
+				t.Errorf("version %d, want 5", version)
+			}
+			if variant := raw[8] >> 6; variant != 0b10 {
+				t.Errorf("variant bits %02b, want 10 (RFC 4122)", variant)
+			}
+		})
+	}
+}
+
+func TestIDsAreScoped(t *testing.T) {
+	must := func(id string, err error) string {
+		t.Helper()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return id
+	}
+
+	pairs := []struct {
+		name string
+		a, b string
+	}{
+		{"same station ID in two networks", must(Station("a", "1")), must(Station("b", "1"))},
+		{"same vehicle ID in two networks", must(Vehicle("a", "1")), must(Vehicle("b", "1"))},
+		{"network and station with the same ID", must(Network("x")), must(Station("x", "x"))},
+		{"station and vehicle with the same ID", must(Station("a", "1")), must(Vehicle("a", "1"))},
+		{"network and its legacy ID", must(Network("x")), must(UUIDfy("x"))},
+	}
+	for _, p := range pairs {
+		if p.a == p.b {
+			t.Errorf("%s: both are %s", p.name, p.a)
+		}
+	}
+}
+
+func TestEmptyIDs(t *testing.T) {
+	if _, err := Network(""); err == nil {
+		t.Error("Network accepted an empty ID")
+	}
+	if _, err := Station("velib", ""); err == nil {
+		t.Error("Station accepted an empty station ID")
+	}
+	if _, err := Vehicle("", "1"); err == nil {
+		t.Error("Vehicle accepted an empty network ID")
+	}
+}
@@ -1,13 +1,5 @@
 package vehicleMapper
 
-import (
-	"gbfs-service/internal/envkeys"
-)
+import "gbfs-service/internal/logging"
 
-type vehicleMapperConfig struct {
-	verbose bool
-}
-
-var Config = vehicleMapperConfig{
-	verbose: envkeys.Environment.Verbose,
-}
+var logger = logging.For("vehicle-mapper")
@@ -0,0 +1,101 @@
+package vehicleMapper_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/config"
+	mappingvalidation "gbfs-service/internal/mapping-validation"
+	"gbfs-service/internal/timestamps"
+	vehicleMapper "gbfs-service/internal/vehicle-mapper"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// FuzzMapVehicleData maps arbitrary vehicles. Mapping may fail, but it must
+// not panic, and a vehicle that maps and passes validation must be one the
+// database accepts.
+func FuzzMapVehicleData(f *testing.F) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "*.json"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	for _, path := range paths {
+		if strings.HasSuffix(path, ".golden.json") {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			f.Fatal(err)
+		}
+		f.Add(data, "lime-paris")
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte, network string) {
+		var vehicle citybikes.Vehicle
+		if err := json.Unmarshal(data, &vehicle); err != nil {
+			return
+		}
+
+		record, err := vehicleMapper.MapVehicleData(vehicle, network)
+		if err != nil {
+			return
+		}
+		if err := mappingvalidation.Vehicle(vehicle, record, network); err != nil {
+			return
+		}
+
+		checkVehicle(t, record)
+	})
+}
+
+// checkVehicle fails unless the record satisfies the vehicle table
+func checkVehicle(t *testing.T, record *vehicleMapper.VehicleRecord) {
+	t.Helper()
+
+	if _, err := uuid.Parse(record.ID); err != nil {
+		t.Errorf("id %q: %v", record.ID, err)
+	}
+	if _, err := uuid.Parse(record.NetworkID); err != nil {
+		t.Errorf("network_id %q: %v", record.NetworkID, err)
+	}
+
+	var longitude, latitude float64
+	if _, err := fmt.Sscanf(record.Location, "POINT(%f %f)", &longitude, &latitude); err != nil {
+		t.Errorf("location %q: %v", record.Location, err)
+	}
+	if latitude < -90 || latitude > 90 || longitude < -180 || longitude > 180 || (latitude == 0 && longitude == 0) {
+		t.Errorf("location %q is not a real place", record.Location)
+	}
+
+	if record.VehicleType == nil || *record.VehicleType == "" {
+		t.Errorf("vehicle_type is missing")
+	}
+
+	if level := record.BatteryLevel; level != nil && (*level < math.MinInt32 || *level > math.MaxInt32) {
+		t.Errorf("battery_level doesn't fit the column (%d)", *level)
+	}
+
+	if record.LastReported != nil {
+		reported, err := timestamps.Parse(*record.LastReported)
+		if err != nil {
+			t.Errorf("last_reported: %v", err)
+		}
+		if limit := time.Now().Add(config.Defaults().Validation.MaxClockSkew + time.Minute); reported.After(limit) {
+			t.Errorf("last_reported %s is in the future", *record.LastReported)
+		}
+	}
+
+	if _, err := json.Marshal(record); err != nil {
+		t.Errorf("record doesn't encode: %v", err)
+	}
+	if !json.Valid(record.RawData) {
+		t.Errorf("raw_data is not JSON: %s", record.RawData)
+	}
+}
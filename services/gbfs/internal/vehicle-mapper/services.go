@@ -1,90 +1,107 @@
 package vehicleMapper
 
 import (
+	"encoding/json"
 	"fmt"
+	"gbfs-service/internal/citybikes"
+	"gbfs-service/internal/logging"
+	mappingrules "gbfs-service/internal/mapping-rules"
+	"gbfs-service/internal/timestamps"
 	"gbfs-service/internal/uuidfy"
-	"log"
-	"strings"
-	"time"
 )
 
-// VehicleRecord represents a free-floating vehicle in Supabase bikeshare.vehicle table
+// VehicleRecord represents a free-floating vehicle in Supabase bikeshare.vehicle table.
+// Optional fields are omitted when unknown so an upsert keeps what is stored.
 type VehicleRecord struct {
-	ID            string         `json:"id"`
-	NetworkID     string         `json:"network_id"`
-	Location      string         `json:"location"`
-	VehicleType   *string        `json:"vehicle_type"`
-	IsReserved    *bool          `json:"is_reserved"`
-	IsDisabled    *bool          `json:"is_disabled"`
-	BatteryLevel  *int           `json:"battery_level"`
-	LastReported  *string        `json:"last_reported"`
-	PricingPlanID *string        `json:"pricing_plan_id"`
-	RentalURIs    map[string]any `json:"rental_uris"`
-	RawData       map[string]any `json:"raw_data"`
-	FetchedAt     *string        `json:"fetched_at,omitempty"`
+	ID            string          `json:"id"`
+	NetworkID     string          `json:"network_id"`
+	Location      string          `json:"location"`
+	VehicleType   *string         `json:"vehicle_type,omitempty"`
+	IsReserved    *bool           `json:"is_reserved,omitempty"`
+	IsDisabled    *bool           `json:"is_disabled,omitempty"`
+	BatteryLevel  *int            `json:"battery_level,omitempty"`
+	LastReported  *string         `json:"last_reported,omitempty"`
+	PricingPlanID *string         `json:"pricing_plan_id,omitempty"`
+	RentalURIs    map[string]any  `json:"rental_uris,omitempty"`
+	RawData       json.RawMessage `json:"raw_data"` // The vehicle as received
+	FetchedAt     *string         `json:"fetched_at,omitempty"`
+	GoneAt        *string         `json:"gone_at"` // Always written as nil so a vehicle seen again is restored
 }
 
-// parseTimestampFlexible tries to parse various timestamp formats
-func parseTimestampFlexible(ts string) *time.Time {
-	if ts == "" {
-		return nil
-	}
+// RecordID is the mapped vehicle ID
+func (v *VehicleRecord) RecordID() string {
+	return v.ID
+}
 
-	// Remove trailing 'Z' if there is already a timezone offset
-	if strings.HasSuffix(ts, "Z") && (strings.Contains(ts, "+") || strings.Contains(ts, "-")) {
-		ts = strings.TrimSuffix(ts, "Z")
+// Compared returns the fields change detection compares. Everything else
+// (raw_data, last_reported, fetched_at) is only rewritten on the heartbeat.
+func (v *VehicleRecord) Compared() any {
+	return []any{
+		v.Location, v.VehicleType, v.BatteryLevel,
+		v.IsReserved, v.IsDisabled, v.PricingPlanID,
 	}
+}
 
-	layouts := []string{
-		"2006-01-02T15:04:05.999999-07:00",
-		"2006-01-02T15:04:05.999999Z07:00",
-		"2006-01-02T15:04:05.999999Z",
-		"2006-01-02T15:04:05-07:00",
-		"2006-01-02T15:04:05Z07:00",
-		"2006-01-02T15:04:05Z",
-		time.RFC3339,
-		time.RFC3339Nano,
+// SetFetchedAt stamps when the vehicle was fetched
+func (v *VehicleRecord) SetFetchedAt(fetchedAt string) {
+	v.FetchedAt = &fetchedAt
+}
+
+// nonEmpty returns a pointer to the first non-empty string
+func nonEmpty(values ...string) *string {
+	for _, value := range values {
+		if value != "" {
+			return &value
+		}
 	}
+	return nil
+}
 
-	for _, layout := range layouts {
-		if t, err := time.Parse(layout, ts); err == nil {
-			return &t
+// firstInt returns the first value that is set, truncated to an int
+func firstInt(values ...*float64) *int {
+	for _, value := range values {
+		if value != nil {
+			level := int(*value)
+			return &level
 		}
 	}
+	return nil
+}
 
-	if Config.verbose {
-		log.Printf("⚠️ Warning: failed to parse vehicle timestamp '%s'", ts)
+// firstBool returns the first value that is set
+func firstBool(values ...*bool) *bool {
+	for _, value := range values {
+		if value != nil {
+			return value
+		}
 	}
 	return nil
 }
 
 // extractVehicleType determines the vehicle type from the data
-func extractVehicleType(vehicleData map[string]any) *string {
-	// Check for "kind" field (used by citybik.es)
-	if kind, ok := vehicleData["kind"].(string); ok && kind != "" {
-		return &kind
+func extractVehicleType(vehicle citybikes.Vehicle, rule mappingrules.VehicleRule) *string {
+	// The network's rule knows better than any field
+	if vehicleType := nonEmpty(rule.VehicleType); vehicleType != nil {
+		return vehicleType
 	}
 
-	// Check for explicit vehicle_type field
-	if vt, ok := vehicleData["vehicle_type"].(string); ok && vt != "" {
-		return &vt
+	// "kind" is used by citybik.es, then an explicit vehicle_type field
+	if vehicleType := nonEmpty(vehicle.Kind, vehicle.VehicleType); vehicleType != nil {
+		return vehicleType
 	}
 
 	// Check in extra data
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if vt, ok := extra["vehicle_type"].(string); ok && vt != "" {
-			return &vt
-		}
-		if kind, ok := extra["kind"].(string); ok && kind != "" {
-			return &kind
+	if extra := vehicle.Extra; extra != nil {
+		if vehicleType := nonEmpty(extra.VehicleType, extra.Kind); vehicleType != nil {
+			return vehicleType
 		}
 		// Check for bike type indicators
-		if _, hasEbike := extra["ebike"]; hasEbike {
-			vt := "ebike"
-			return &vt
+		if extra.HasEbike() {
+			vehicleType := "ebike"
+			return &vehicleType
 		}
-		if bikeType, ok := extra["bike_type"].(string); ok && bikeType != "" {
-			return &bikeType
+		if vehicleType := nonEmpty(extra.BikeType); vehicleType != nil {
+			return vehicleType
 		}
 	}
 
@@ -94,171 +111,109 @@ func extractVehicleType(vehicleData map[string]any) *string {
 }
 
 // extractBatteryLevel extracts battery level from vehicle data
-func extractBatteryLevel(vehicleData map[string]any) *int {
-	// Direct battery field
-	if battery, ok := vehicleData["battery"].(float64); ok {
-		level := int(battery)
-		return &level
-	}
-
-	// Check extra data
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if battery, ok := extra["battery"].(float64); ok {
-			level := int(battery)
-			return &level
-		}
-		if batteryLevel, ok := extra["battery_level"].(float64); ok {
-			level := int(batteryLevel)
-			return &level
-		}
+func extractBatteryLevel(vehicle citybikes.Vehicle) *int {
+	if extra := vehicle.Extra; extra != nil {
 		// Some systems use percentage
-		if batteryPct, ok := extra["battery_percentage"].(float64); ok {
-			level := int(batteryPct)
-			return &level
-		}
+		return firstInt(vehicle.Battery, extra.Battery, extra.BatteryLevel, extra.BatteryPercentage)
 	}
-
-	return nil
+	return firstInt(vehicle.Battery)
 }
 
 // extractIsReserved determines if the vehicle is reserved
-func extractIsReserved(vehicleData map[string]any) *bool {
-	if reserved, ok := vehicleData["is_reserved"].(bool); ok {
-		return &reserved
-	}
-
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if reserved, ok := extra["is_reserved"].(bool); ok {
-			return &reserved
-		}
-		if reserved, ok := extra["reserved"].(bool); ok {
-			return &reserved
-		}
+func extractIsReserved(vehicle citybikes.Vehicle) *bool {
+	if extra := vehicle.Extra; extra != nil {
+		return firstBool(vehicle.IsReserved, extra.IsReserved, extra.Reserved)
 	}
-
-	return nil
+	return vehicle.IsReserved
 }
 
 // extractIsDisabled determines if the vehicle is disabled
-func extractIsDisabled(vehicleData map[string]any) *bool {
-	if disabled, ok := vehicleData["is_disabled"].(bool); ok {
-		return &disabled
+func extractIsDisabled(vehicle citybikes.Vehicle) *bool {
+	if extra := vehicle.Extra; extra != nil {
+		return firstBool(vehicle.IsDisabled, extra.IsDisabled, extra.Disabled)
 	}
-
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if disabled, ok := extra["is_disabled"].(bool); ok {
-			return &disabled
-		}
-		if disabled, ok := extra["disabled"].(bool); ok {
-			return &disabled
-		}
-	}
-
-	return nil
+	return vehicle.IsDisabled
 }
 
 // extractRentalURIs extracts rental URI information
-func extractRentalURIs(vehicleData map[string]any) map[string]any {
-	if uris, ok := vehicleData["rental_uris"].(map[string]any); ok {
-		return uris
+func extractRentalURIs(vehicle citybikes.Vehicle) map[string]any {
+	if vehicle.RentalURIs != nil {
+		return vehicle.RentalURIs
 	}
 
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if uris, ok := extra["rental_uris"].(map[string]any); ok {
-			return uris
-		}
+	if vehicle.Extra != nil {
+		return vehicle.Extra.RentalURIs
 	}
 
 	return nil
 }
 
-// MapVehicleData transforms WebSocket vehicle data to Supabase bikeshare.vehicle format
-func MapVehicleData(vehicleData map[string]any, networkName string) (map[string]any, error) {
-	// Generate vehicle ID using uuidfy
-	vehicleID, ok := vehicleData["id"].(string)
-	if !ok {
+// MapVehicleData transforms citybik.es vehicle data to Supabase bikeshare.vehicle format
+func MapVehicleData(vehicle citybikes.Vehicle, networkName string) (*VehicleRecord, error) {
+	// Generate vehicle ID, scoped by its network
+	if vehicle.ID == "" {
 		return nil, fmt.Errorf("vehicle id not found or not a string")
 	}
 
-	mappedVehicleID, err := uuidfy.UUIDfy(vehicleID)
+	// Apply the network's field remapping before reading anything else
+	rule := mappingrules.For(networkName).Vehicle
+	vehicle = rule.Remap(vehicle)
+
+	mappedVehicleID, err := uuidfy.Vehicle(networkName, vehicle.ID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate vehicle ID: %v", err)
 	}
 
 	// Generate network ID using uuidfy
-	networkID, err := uuidfy.UUIDfy(networkName)
+	networkID, err := uuidfy.Network(networkName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate network ID: %v", err)
 	}
 
 	// Extract location
-	latitude, hasLat := vehicleData["latitude"].(float64)
-	longitude, hasLon := vehicleData["longitude"].(float64)
-	if !hasLat || !hasLon {
+	latitude, longitude, ok := vehicle.Coordinates()
+	if !ok {
 		return nil, fmt.Errorf("vehicle location not found")
 	}
 	location := fmt.Sprintf("POINT(%f %f)", longitude, latitude)
 
-	// Extract timestamp
+	// Extract timestamp, left out if there is none so the stored one stays
 	var lastReported *string
-	if timestamp, ok := vehicleData["timestamp"].(string); ok && timestamp != "" {
-		parsed := parseTimestampFlexible(timestamp)
-		if parsed != nil {
-			formatted := parsed.Format(time.RFC3339)
-			lastReported = &formatted
-		}
+	if parsed, err := timestamps.Parse(string(vehicle.Timestamp)); err == nil {
+		formatted := timestamps.Format(parsed)
+		lastReported = &formatted
+	} else if vehicle.Timestamp != "" {
+		logger.Debug("failed to parse vehicle timestamp", "timestamp", vehicle.Timestamp)
 	}
 
-	// Extract other fields
-	vehicleType := extractVehicleType(vehicleData)
-	batteryLevel := extractBatteryLevel(vehicleData)
-	isReserved := extractIsReserved(vehicleData)
-	isDisabled := extractIsDisabled(vehicleData)
-	rentalURIs := extractRentalURIs(vehicleData)
-
 	// Extract pricing plan ID if available
 	var pricingPlanID *string
-	if extra, ok := vehicleData["extra"].(map[string]any); ok {
-		if planID, ok := extra["pricing_plan_id"].(string); ok && planID != "" {
-			pricingPlanID = &planID
-		}
+	if vehicle.Extra != nil {
+		pricingPlanID = nonEmpty(vehicle.Extra.PricingPlanID)
 	}
 
 	// Build the mapped vehicle record
-	mappedVehicle := map[string]any{
-		"id":         mappedVehicleID,
-		"network_id": networkID,
-		"location":   location,
-		"raw_data":   vehicleData,
-	}
-
-	// Add optional fields
-	if vehicleType != nil {
-		mappedVehicle["vehicle_type"] = *vehicleType
-	}
-	if batteryLevel != nil {
-		mappedVehicle["battery_level"] = *batteryLevel
-	}
-	if isReserved != nil {
-		mappedVehicle["is_reserved"] = *isReserved
-	}
-	if isDisabled != nil {
-		mappedVehicle["is_disabled"] = *isDisabled
-	}
-	if lastReported != nil {
-		mappedVehicle["last_reported"] = *lastReported
-	}
-	if pricingPlanID != nil {
-		mappedVehicle["pricing_plan_id"] = *pricingPlanID
-	}
-	if rentalURIs != nil {
-		mappedVehicle["rental_uris"] = rentalURIs
-	}
-
-	if Config.verbose {
-		log.Printf("🛴 Mapped vehicle %s - type: %v, battery: %v, location: %s",
-			mappedVehicleID, vehicleType, batteryLevel, location)
-	}
+	mappedVehicle := &VehicleRecord{
+		ID:            mappedVehicleID,
+		NetworkID:     networkID,
+		Location:      location,
+		VehicleType:   extractVehicleType(vehicle, rule),
+		IsReserved:    extractIsReserved(vehicle),
+		IsDisabled:    extractIsDisabled(vehicle),
+		BatteryLevel:  extractBatteryLevel(vehicle),
+		LastReported:  lastReported,
+		PricingPlanID: pricingPlanID,
+		RentalURIs:    extractRentalURIs(vehicle),
+		RawData:       vehicle.Raw,
+	}
+
+	logger.Debug("mapped vehicle",
+		logging.NetworkID(networkName),
+		logging.VehicleID(mappedVehicleID),
+		"vehicle_type", mappedVehicle.VehicleType,
+		"battery_level", mappedVehicle.BatteryLevel,
+		"location", location,
+	)
 
 	return mappedVehicle, nil
 }